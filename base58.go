@@ -0,0 +1,77 @@
+package tesoro
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+var errChecksum = errors.New("wrong checksum")
+
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix, mod := big.NewInt(58), new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58Decode(str string) ([]byte, error) {
+	n, radix := new(big.Int), big.NewInt(58)
+	for _, r := range str {
+		i := bytes.IndexRune([]byte(base58Alphabet), r)
+		if i < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	var zeros []byte
+	for i := 0; i < len(str) && str[i] == base58Alphabet[0]; i++ {
+		zeros = append(zeros, 0)
+	}
+	return append(zeros, n.Bytes()...), nil
+}
+
+// base58CheckEncode appends the first 4 bytes of the double SHA-256 of
+// data and encodes it in base58
+func base58CheckEncode(data []byte) string {
+	return base58Encode(append(data[:len(data):len(data)], checksum(data)...))
+}
+
+// base58CheckDecode decodes str and checks and removes its checksum
+func base58CheckDecode(str string) ([]byte, error) {
+	data, err := base58Decode(str)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, errChecksum
+	}
+	payload := data[:len(data)-4]
+	if !bytes.Equal(checksum(payload), data[len(data)-4:]) {
+		return nil, errChecksum
+	}
+	return payload, nil
+}
+
+func checksum(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
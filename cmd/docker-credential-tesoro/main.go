@@ -0,0 +1,105 @@
+// docker-credential-tesoro is a docker credential helper that keeps the
+// registry secrets encrypted by the device. Set "credsStore": "tesoro" in
+// ~/.docker/config.json; every get has to be confirmed on the device.
+//
+// The encrypted secrets are kept in ~/.docker/tesoro-credentials.json, or
+// in the file named by $TESORO_DOCKER_CREDENTIALS.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/internal/cli"
+	"github.com/conejoninja/tesoro/internal/dockercred"
+)
+
+// credentials as the docker-credential-helpers protocol sends them
+type credentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: docker-credential-tesoro get|store|erase|list")
+		os.Exit(1)
+	}
+
+	s, err := dockercred.Load(storeFile())
+	if err != nil {
+		fail(err)
+	}
+	input, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fail(err)
+	}
+
+	switch os.Args[1] {
+	case "get":
+		serverURL := strings.TrimSpace(string(input))
+		if _, ok := s.Credentials[serverURL]; !ok {
+			fail(dockercred.ErrNotFound)
+		}
+		client := openDevice()
+		defer client.CloseTransport()
+		username, secret, err := s.Get(client, serverURL)
+		if err != nil {
+			fail(err)
+		}
+		err = json.NewEncoder(os.Stdout).Encode(credentials{ServerURL: serverURL, Username: username, Secret: secret})
+	case "store":
+		var c credentials
+		if err = json.Unmarshal(input, &c); err != nil {
+			fail(err)
+		}
+		client := openDevice()
+		defer client.CloseTransport()
+		if err = s.Put(client, c.ServerURL, c.Username, c.Secret); err == nil {
+			err = s.Save()
+		}
+	case "erase":
+		serverURL := strings.TrimSpace(string(input))
+		if err = s.Erase(serverURL); err == nil {
+			err = s.Save()
+		}
+	case "list":
+		err = json.NewEncoder(os.Stdout).Encode(s.List())
+	default:
+		err = fmt.Errorf("unknown action %s", os.Args[1])
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func storeFile() string {
+	if filename := os.Getenv("TESORO_DOCKER_CREDENTIALS"); filename != "" {
+		return filename
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".docker", "tesoro-credentials.json")
+}
+
+func openDevice() *tesoro.Client {
+	client, err := cli.Open()
+	if err != nil {
+		fail(err)
+	}
+	return client
+}
+
+// fail prints the error on stdout, where docker reads it from
+func fail(err error) {
+	fmt.Fprintln(os.Stdout, err)
+	os.Exit(1)
+}
@@ -0,0 +1,203 @@
+// git-credential-tesoro is a git credential helper that keeps the
+// credentials in the TREZOR Password Manager vault. Configure it with
+//
+//	git config --global credential.helper "tesoro -vault ~/tpm"
+//
+// Credentials git asks to store are added with the "git" tag, and only
+// entries with that tag are erased when git reports them as rejected.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/conejoninja/tesoro/internal/cli"
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+const gitTag = "git"
+
+type credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+}
+
+func main() {
+	location := flag.String("vault", "", "vault directory, WebDAV URL or dropbox:<token>, $"+cli.VaultEnv+" by default")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: git-credential-tesoro [-vault location] get|store|erase")
+		os.Exit(1)
+	}
+	// git may call with actions added after this helper, ignore them
+	// without opening the device
+	switch flag.Arg(0) {
+	case "get", "store", "erase":
+	default:
+		return
+	}
+
+	c, err := readCredential(os.Stdin)
+	if err != nil {
+		fail(err)
+	}
+	// git ignores helpers that print nothing, never touch the device for
+	// requests that can not match
+	if c.Host == "" {
+		return
+	}
+
+	backend, err := cli.Backend(*location)
+	if err != nil {
+		fail(err)
+	}
+	client, err := cli.Open()
+	if err != nil {
+		fail(err)
+	}
+	defer client.CloseTransport()
+	v, err := tpm.Open(client, backend)
+	if err != nil {
+		fail(err)
+	}
+
+	switch flag.Arg(0) {
+	case "get":
+		err = get(v, c, os.Stdout)
+	case "store":
+		err = store(v, c)
+	case "erase":
+		err = erase(v, c)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "git-credential-tesoro:", err)
+	os.Exit(1)
+}
+
+// readCredential parses the key=value lines git writes, up to a blank line
+func readCredential(r io.Reader) (credential, error) {
+	var c credential
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], line[i+1:]
+		switch key {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		}
+	}
+	return c, scanner.Err()
+}
+
+func (c credential) title() string {
+	title := c.Protocol + "://" + c.Host
+	if c.Path != "" {
+		title += "/" + strings.TrimPrefix(c.Path, "/")
+	}
+	return title
+}
+
+func get(v *tpm.Vault, c credential, w io.Writer) error {
+	matches := v.Match(c.Protocol, c.Host, c.Path, c.Username)
+	if len(matches) == 0 {
+		return nil
+	}
+	item, err := v.Get(matches[0].ID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "username=%s\npassword=%s\n", item.Username, item.Password)
+	return nil
+}
+
+func store(v *tpm.Vault, c credential) error {
+	if c.Username == "" || c.Password == "" {
+		return nil
+	}
+	for _, r := range v.Match(c.Protocol, c.Host, c.Path, c.Username) {
+		item, err := v.Get(r.ID)
+		if err != nil {
+			return err
+		}
+		if item.Password == c.Password {
+			return nil
+		}
+		// a new password for a credential we stored
+		if hasTag(v, item.Tags, gitTag) {
+			item.Password = c.Password
+			if err = v.Update(r.ID, item); err != nil {
+				return err
+			}
+			_, err = v.Sync()
+			return err
+		}
+	}
+
+	_, err := v.Add(tpm.Item{
+		Title:    c.title(),
+		Username: c.Username,
+		Note:     "git " + c.Host,
+		Tags:     []int{v.AddTag(gitTag)},
+		Password: c.Password,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = v.Sync()
+	return err
+}
+
+func erase(v *tpm.Vault, c credential) error {
+	erased := false
+	for _, r := range v.Match(c.Protocol, c.Host, c.Path, c.Username) {
+		if hasTag(v, r.Entry.Tags, gitTag) {
+			v.Delete(r.ID)
+			erased = true
+		}
+	}
+	if !erased {
+		return nil
+	}
+	_, err := v.Sync()
+	return err
+}
+
+func hasTag(v *tpm.Vault, tags []int, title string) bool {
+	id, ok := v.TagID(title)
+	if !ok {
+		return false
+	}
+	for _, t := range tags {
+		if t == id {
+			return true
+		}
+	}
+	return false
+}
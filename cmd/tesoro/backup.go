@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/crypt"
+	"github.com/conejoninja/tesoro/internal/cli"
+)
+
+const backupUsage = `usage:
+	tesoro backup create [-path m/10019'/0'] [-label text] -o archive dir...
+	tesoro backup restore [-C dir] archive
+	tesoro backup recipients archive
+	tesoro backup add archive
+	tesoro backup remove archive device-id`
+
+// backup encrypts tar archives for every device connected when they are
+// created; any one of them can restore it. Recipients are added and
+// removed by rewriting the header only.
+func backup(args []string) error {
+	if len(args) == 0 {
+		return errors.New(backupUsage)
+	}
+	switch args[0] {
+	case "create":
+		return backupCreate(args[1:])
+	case "restore":
+		return backupRestore(args[1:])
+	case "recipients":
+		return backupRecipients(args[1:])
+	case "add":
+		return backupAdd(args[1:])
+	case "remove":
+		return backupRemove(args[1:])
+	}
+	return errors.New(backupUsage)
+}
+
+func backupCreate(args []string) error {
+	fs := flag.NewFlagSet("backup create", flag.ExitOnError)
+	path := fs.String("path", crypt.DefaultPath, "BIP32 path of the device keys")
+	label := fs.String("label", "", "key label shown on the devices when restoring, \"Restore <archive>?\" by default")
+	output := fs.String("o", "", "archive to create")
+	fs.Parse(args)
+	if *output == "" || fs.NArg() == 0 {
+		return errors.New(backupUsage)
+	}
+	if *label == "" {
+		*label = "Restore " + filepath.Base(*output) + "?"
+	}
+
+	clients, err := cli.OpenAll()
+	if err != nil {
+		return err
+	}
+	defer closeAll(clients)
+	key, err := crypt.NewKey()
+	if err != nil {
+		return err
+	}
+	var h crypt.Header
+	if h, err = crypt.NewHeader(); err != nil {
+		return err
+	}
+	for _, client := range clients {
+		wk, err := crypt.WrapFor(client, key, *path, *label)
+		if err != nil {
+			return err
+		}
+		h.AddRecipient(wk)
+		fmt.Fprintf(os.Stderr, "recipient %s %s\n", wk.DeviceID, wk.DeviceLabel)
+	}
+
+	return writeOutput(*output, func(out io.Writer) error {
+		w, err := crypt.NewWriter(out, key, h)
+		if err != nil {
+			return err
+		}
+		if err = crypt.WriteTar(w, fs.Args()...); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+}
+
+func backupRestore(args []string) error {
+	fs := flag.NewFlagSet("backup restore", flag.ExitOnError)
+	dir := fs.String("C", ".", "directory to restore to")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New(backupUsage)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h, err := crypt.ReadHeader(f)
+	if err != nil {
+		return err
+	}
+
+	clients, err := cli.OpenAll()
+	if err != nil {
+		return err
+	}
+	defer closeAll(clients)
+	key, err := unwrapWithAny(clients, h)
+	if err != nil {
+		return err
+	}
+	r, err := crypt.NewReader(f, key, h)
+	if err != nil {
+		return err
+	}
+	names, err := crypt.ExtractTar(r, *dir)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return err
+}
+
+func backupRecipients(args []string) error {
+	if len(args) != 1 {
+		return errors.New(backupUsage)
+	}
+	h, err := readHeader(args[0])
+	if err != nil {
+		return err
+	}
+	for _, wk := range h.Keys {
+		fmt.Printf("%s\t%s\t%s\t%s\n", wk.DeviceID, wk.DeviceLabel, wk.Path, wk.Label)
+	}
+	return nil
+}
+
+// backupAdd unwraps the key with a device that is a recipient and wraps
+// it for the other devices connected. With a single USB port the device
+// can be swapped when asked.
+func backupAdd(args []string) error {
+	if len(args) != 1 {
+		return errors.New(backupUsage)
+	}
+	name := args[0]
+	h, err := readHeader(name)
+	if err != nil {
+		return err
+	}
+
+	clients, err := cli.OpenAll()
+	if err != nil {
+		return err
+	}
+	key, err := unwrapWithAny(clients, h)
+	if err != nil {
+		closeAll(clients)
+		return err
+	}
+	added, err := addRecipients(clients, &h, key)
+	closeAll(clients)
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		if _, err = cli.NewPrompter().ReadLine("Connect the device to add and press enter"); err != nil {
+			return err
+		}
+		if clients, err = cli.OpenAll(); err != nil {
+			return err
+		}
+		added, err = addRecipients(clients, &h, key)
+		closeAll(clients)
+		if err != nil {
+			return err
+		}
+		if added == 0 {
+			return errors.New("the devices connected are recipients already")
+		}
+	}
+	return rewrap(name, h)
+}
+
+func addRecipients(clients []*tesoro.Client, h *crypt.Header, key []byte) (int, error) {
+	added := 0
+	// every recipient shares the path and label of the first one
+	path, label := h.Keys[0].Path, h.Keys[0].Label
+	for _, client := range clients {
+		id, _, err := crypt.DeviceID(client)
+		if err != nil {
+			return added, err
+		}
+		if _, ok := h.Recipient(id); ok {
+			continue
+		}
+		wk, err := crypt.WrapFor(client, key, path, label)
+		if err != nil {
+			return added, err
+		}
+		h.AddRecipient(wk)
+		added++
+		fmt.Fprintf(os.Stderr, "added %s %s\n", wk.DeviceID, wk.DeviceLabel)
+	}
+	return added, nil
+}
+
+func backupRemove(args []string) error {
+	if len(args) != 2 {
+		return errors.New(backupUsage)
+	}
+	h, err := readHeader(args[0])
+	if err != nil {
+		return err
+	}
+	if err = h.RemoveRecipient(args[1]); err != nil {
+		return err
+	}
+	return rewrap(args[0], h)
+}
+
+// unwrapWithAny unwraps the key with the first device that is a recipient
+func unwrapWithAny(clients []*tesoro.Client, h crypt.Header) ([]byte, error) {
+	for _, client := range clients {
+		key, err := crypt.UnwrapAny(client, h)
+		if err != crypt.ErrNoKey {
+			return key, err
+		}
+	}
+	return nil, crypt.ErrNoKey
+}
+
+func readHeader(name string) (crypt.Header, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return crypt.Header{}, err
+	}
+	defer f.Close()
+	return crypt.ReadHeader(f)
+}
+
+// rewrap replaces the header of the archive, the data is copied as is
+func rewrap(name string, h crypt.Header) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return replaceFile(name, func(out io.Writer) error {
+		return crypt.Rewrap(out, f, h)
+	})
+}
+
+func closeAll(clients []*tesoro.Client) {
+	for _, client := range clients {
+		client.CloseTransport()
+	}
+}
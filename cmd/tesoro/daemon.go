@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/conejoninja/tesoro/daemon"
+	"github.com/conejoninja/tesoro/policy"
+	"github.com/conejoninja/tesoro/transport"
+)
+
+// listFlag collects repeated flags
+type listFlag []string
+
+func (f *listFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *listFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// daemonCmd serves the connected devices to the local processes, see the
+// daemon package for the API
+func daemonCmd(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:21327", "loopback address to listen on")
+	var origins, keys listFlag
+	fs.Var(&origins, "origin", "origin allowed to call from a browser, can be repeated")
+	configFile := fs.String("config", "", "signed configuration with the allowed URLs and devices")
+	fs.Var(&keys, "config-key", "public key in hex the configuration is signed with, can be repeated")
+	fs.Parse(args)
+
+	s := daemon.New(&transport.BusHID{}, origins)
+	if *configFile != "" {
+		pinned := make([][]byte, len(keys))
+		for i, key := range keys {
+			var err error
+			if pinned[i], err = hex.DecodeString(key); err != nil {
+				return fmt.Errorf("config key %s: %v", key, err)
+			}
+		}
+		p, err := policy.LoadFile(*configFile, pinned, time.Now())
+		if err != nil {
+			return err
+		}
+		s.Policy = p
+		if until := p.ValidUntil(); !until.IsZero() {
+			fmt.Fprintln(os.Stderr, "configuration valid until", until)
+		}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		s.Close()
+		os.Exit(0)
+	}()
+	fmt.Fprintln(os.Stderr, "listening on", *listen)
+	return s.ListenAndServe(*listen)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/conejoninja/tesoro/crypt"
+	"github.com/conejoninja/tesoro/internal/cli"
+)
+
+const extension = ".tsro"
+
+// encrypt encrypts a file with a data key wrapped by the device
+func encrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	path := fs.String("path", crypt.DefaultPath, "BIP32 path of the device key")
+	label := fs.String("label", "", "key label shown on the device when decrypting, \"Decrypt <file>?\" by default")
+	output := fs.String("o", "", "output file, - for stdout, <file>"+extension+" by default")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: tesoro encrypt [-path m/10019'/0'] [-label text] [-o output] file")
+	}
+	input := fs.Arg(0)
+	if *output == "" {
+		if input == "-" {
+			*output = "-"
+		} else {
+			*output = input + extension
+		}
+	}
+	if *label == "" {
+		*label = "Decrypt " + filepath.Base(input) + "?"
+	}
+
+	in, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	client, err := cli.Open()
+	if err != nil {
+		return err
+	}
+	defer client.CloseTransport()
+
+	return writeOutput(*output, func(out io.Writer) error {
+		w, err := crypt.Encrypt(client, out, *path, *label)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(w, in); err != nil {
+			return err
+		}
+		return w.Close()
+	})
+}
+
+// decrypt unwraps the data key of a file on the device and decrypts it
+func decrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	output := fs.String("o", "", "output file, - for stdout, the file without "+extension+" by default")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: tesoro decrypt [-o output] file" + extension)
+	}
+	input := fs.Arg(0)
+	if *output == "" {
+		if input == "-" {
+			*output = "-"
+		} else if strings.HasSuffix(input, extension) {
+			*output = strings.TrimSuffix(input, extension)
+		} else {
+			return fmt.Errorf("%s does not end in %s, use -o", input, extension)
+		}
+	}
+
+	in, err := openInput(input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	client, err := cli.Open()
+	if err != nil {
+		return err
+	}
+	defer client.CloseTransport()
+
+	return writeOutput(*output, func(out io.Writer) error {
+		r, _, err := crypt.Decrypt(client, in)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, r)
+		return err
+	})
+}
+
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(name)
+}
+
+// writeOutput writes to a new file, or stdout for -
+func writeOutput(name string, write func(io.Writer) error) error {
+	if name == "-" {
+		return write(os.Stdout)
+	}
+	if _, err := os.Stat(name); err == nil {
+		return fmt.Errorf("%s already exists", name)
+	}
+	return replaceFile(name, write)
+}
+
+// replaceFile writes to a temporary file renamed over name once done, so
+// a failure never leaves half a file behind
+func replaceFile(name string, write func(io.Writer) error) error {
+	tmp, err := os.OpenFile(name+".tmp", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err = write(tmp); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
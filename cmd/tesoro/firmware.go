@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/firmware"
+	"github.com/conejoninja/tesoro/internal/cli"
+)
+
+// bootloaderWait is how long firmwareCmd waits for the device to come
+// back in bootloader mode
+const bootloaderWait = 2 * time.Minute
+
+// firmwareCmd installs a firmware after checking its signatures and,
+// when the device starts in normal mode, that it is not a downgrade
+func firmwareCmd(args []string) error {
+	fs := flag.NewFlagSet("firmware", flag.ExitOnError)
+	version := fs.String("version", "", "version of the firmware, taken from the file name by default")
+	unofficial := fs.Bool("unofficial", false, "install a firmware not signed by SatoshiLabs")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: tesoro firmware [-version 1.6.3] [-unofficial] firmware.bin")
+	}
+
+	data, err := ioutil.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	f, err := firmware.Parse(data)
+	if err != nil {
+		return err
+	}
+	if err = f.Verify(nil); err != nil {
+		if !*unofficial {
+			return fmt.Errorf("%v, use -unofficial to install it anyway", err)
+		}
+		fmt.Fprintln(os.Stderr, "warning:", err)
+	}
+	next, known := firmware.VersionFromName(fs.Arg(0))
+	if *version != "" {
+		if next, err = firmware.ParseVersion(*version); err != nil {
+			return err
+		}
+		known = true
+	}
+
+	client, err := cli.Open()
+	if err != nil {
+		return err
+	}
+	info, err := client.DeviceInfo()
+	if err != nil {
+		client.CloseTransport()
+		return err
+	}
+	if !info.BootloaderMode {
+		client.CloseTransport()
+		fmt.Fprintln(os.Stderr, "installed firmware", info.Version)
+		if known && firmware.Downgrade(info, next) {
+			answer, _ := cli.NewPrompter().ReadLine(fmt.Sprintf("warning: %s is older than the installed firmware, install it anyway? [y/N]", next))
+			if strings.ToLower(answer) != "y" {
+				return errors.New("cancelled")
+			}
+		}
+		fmt.Fprintln(os.Stderr, "disconnect the device and connect it again while holding both buttons")
+		if client, err = waitBootloader(bootloaderWait); err != nil {
+			return err
+		}
+	}
+	defer client.CloseTransport()
+
+	fmt.Fprintln(os.Stderr, "fingerprint", f.Fingerprint(), "check the device shows the same")
+	err = firmware.Update(client, f, func(written, total int) {
+		fmt.Fprintf(os.Stderr, "\ruploading %3d%%", written*100/total)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "firmware installed")
+	return nil
+}
+
+// waitBootloader waits for a device in bootloader mode
+func waitBootloader(timeout time.Duration) (*tesoro.Client, error) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		client, err := cli.Open()
+		if err != nil {
+			continue
+		}
+		info, err := client.DeviceInfo()
+		if err == nil && info.BootloaderMode {
+			return client, nil
+		}
+		client.CloseTransport()
+	}
+	return nil, firmware.ErrNotBootloader
+}
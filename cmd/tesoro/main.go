@@ -0,0 +1,53 @@
+// tesoro gathers the command line tools that work with the device
+//
+//	tesoro run [-vault location] -env NAME=entry-id [-file NAME=entry-id] -- command args
+//	tesoro encrypt [-path m/10019'/0'] [-label text] [-o output] file
+//	tesoro decrypt [-o output] file.tsro
+//	tesoro backup create|restore|recipients|add|remove
+//	tesoro daemon [-listen 127.0.0.1:21327] [-origin https://example.com] [-config signed.bin -config-key hex]
+//	tesoro firmware [-version 1.6.3] [-unofficial] firmware.bin
+//	tesoro verify-device [-releases releases.json]
+//	tesoro tpm audit [-vault location] [-y] [breach list]
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// commands by name, each one parses its own flags
+var commands = map[string]func(args []string) error{
+	"run":           run,
+	"encrypt":       encrypt,
+	"decrypt":       decrypt,
+	"backup":        backup,
+	"daemon":        daemonCmd,
+	"firmware":      firmwareCmd,
+	"verify-device": verifyDevice,
+	"tpm":           tpmCmd,
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		usage()
+		os.Exit(2)
+	}
+	if err := commands[os.Args[1]](os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "tesoro:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "usage: tesoro <command> [arguments]")
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "\t"+name)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/conejoninja/tesoro/internal/cli"
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+// validName is a name of environment variable, also safe as a file name
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// secretFlag collects the repeated NAME=entry-id[:field] flags
+type secretFlag []secretRef
+
+type secretRef struct {
+	Name  string
+	ID    string
+	Field string
+}
+
+func (f *secretFlag) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *secretFlag) Set(value string) error {
+	i := strings.Index(value, "=")
+	if i <= 0 || i == len(value)-1 {
+		return errors.New("expected NAME=entry-id[:field]")
+	}
+	ref := secretRef{Name: value[:i], ID: value[i+1:], Field: "password"}
+	if !validName.MatchString(ref.Name) {
+		return fmt.Errorf("invalid name %q, use letters, digits and _", ref.Name)
+	}
+	if j := strings.Index(ref.ID, ":"); j >= 0 {
+		ref.ID, ref.Field = ref.ID[:j], ref.ID[j+1:]
+	}
+	switch ref.Field {
+	case "password", "safenote", "username", "title", "note":
+	default:
+		return fmt.Errorf("unknown field %s, use password, safenote, username, title or note", ref.Field)
+	}
+	*f = append(*f, ref)
+	return nil
+}
+
+// run unlocks vault entries on the device and runs a command with them in
+// its environment. Secrets given with -file are written to 0600 files whose
+// paths go in the environment, the files are overwritten and removed when
+// the command exits.
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	location := fs.String("vault", "", "vault directory, WebDAV URL or dropbox:<token>, $"+cli.VaultEnv+" by default")
+	var envs, files secretFlag
+	fs.Var(&envs, "env", "NAME=entry-id[:field] sets NAME to the password, or the field, of the entry")
+	fs.Var(&files, "file", "NAME=entry-id[:field] writes the secret to a temporary file and sets NAME to its path")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("usage: tesoro run [-vault location] -env NAME=entry-id [-file NAME=entry-id] -- command args")
+	}
+	if len(envs)+len(files) == 0 {
+		return errors.New("no secrets requested, use -env or -file")
+	}
+
+	backend, err := cli.Backend(*location)
+	if err != nil {
+		return err
+	}
+	client, err := cli.Open()
+	if err != nil {
+		return err
+	}
+	v, err := tpm.Open(client, backend)
+	if err != nil {
+		client.CloseTransport()
+		return err
+	}
+	// every entry is unlocked before the command starts
+	items := map[string]tpm.Item{}
+	for _, ref := range append(append(secretFlag{}, envs...), files...) {
+		if _, ok := items[ref.ID]; ok {
+			continue
+		}
+		if items[ref.ID], err = v.Get(ref.ID); err != nil {
+			client.CloseTransport()
+			return fmt.Errorf("entry %s: %v", ref.ID, err)
+		}
+	}
+	client.CloseTransport()
+
+	env := os.Environ()
+	for _, ref := range envs {
+		env = append(env, ref.Name+"="+field(items[ref.ID], ref.Field))
+	}
+
+	var dir string
+	if len(files) > 0 {
+		if dir, err = ioutil.TempDir(memoryDir(), "tesoro"); err != nil {
+			return err
+		}
+		defer scrub(dir)
+		for _, ref := range files {
+			path := filepath.Join(dir, ref.Name)
+			if err = ioutil.WriteFile(path, []byte(field(items[ref.ID], ref.Field)), 0600); err != nil {
+				return err
+			}
+			env = append(env, ref.Name+"="+path)
+		}
+	}
+
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	// the child gets the signals, we stay to clean up after it
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		scrub(dir)
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			os.Exit(status.ExitStatus())
+		}
+		os.Exit(1)
+	}
+	return err
+}
+
+func field(item tpm.Item, name string) string {
+	switch name {
+	case "safenote":
+		return item.SafeNote
+	case "username":
+		return item.Username
+	case "title":
+		return item.Title
+	case "note":
+		return item.Note
+	}
+	return item.Password
+}
+
+// memoryDir returns a directory kept in memory when the system has one,
+// so the secrets do not reach the disk
+func memoryDir() string {
+	for _, dir := range []string{os.Getenv("XDG_RUNTIME_DIR"), "/dev/shm"} {
+		if fi, err := os.Stat(dir); dir != "" && err == nil && fi.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// scrub overwrites the secret files before removing them
+func scrub(dir string) {
+	if dir == "" {
+		return
+	}
+	files, _ := ioutil.ReadDir(dir)
+	for _, fi := range files {
+		path := filepath.Join(dir, fi.Name())
+		if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			f.Write(make([]byte, fi.Size()))
+			f.Sync()
+			f.Close()
+		}
+	}
+	os.RemoveAll(dir)
+}
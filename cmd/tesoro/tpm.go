@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/conejoninja/tesoro/internal/cli"
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+const tpmUsage = `usage:
+	tesoro tpm audit [-vault location] [-y] [breach list file or directory]`
+
+var errFindings = errors.New("the audit found problems in the vault")
+
+// tpmCmd works with the password vault without the shell
+func tpmCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(tpmUsage)
+	}
+	switch args[0] {
+	case "audit":
+		return tpmAudit(args[1:])
+	}
+	return errors.New(tpmUsage)
+}
+
+// tpmAudit reports weak, reused and breached passwords by entry id, the
+// passwords are never printed. The run is confirmed once here, the device
+// still asks for each entry.
+func tpmAudit(args []string) error {
+	fs := flag.NewFlagSet("tpm audit", flag.ExitOnError)
+	location := fs.String("vault", "", "vault directory, WebDAV URL or dropbox:<token>, $"+cli.VaultEnv+" by default")
+	yes := fs.Bool("y", false, "do not ask before unlocking the entries")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		return errors.New(tpmUsage)
+	}
+
+	opts := tpm.DefaultAuditOptions
+	if fs.NArg() == 1 {
+		breaches, err := tpm.OpenBreaches(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		opts.Breaches = breaches
+	}
+
+	backend, err := cli.Backend(*location)
+	if err != nil {
+		return err
+	}
+	client, err := cli.Open()
+	if err != nil {
+		return err
+	}
+	defer client.CloseTransport()
+	v, err := tpm.Open(client, backend)
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		line, err := cli.NewPrompter().ReadLine(fmt.Sprintf("Audit %d entries? The device asks to confirm each one [y/N]", len(v.Storage.Entries)))
+		if err != nil || strings.ToLower(line) != "y" {
+			return errors.New("audit cancelled")
+		}
+	}
+
+	findings, err := v.Audit(opts)
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	if err != nil {
+		return fmt.Errorf("audit stopped: %v", err)
+	}
+	fmt.Printf("%d findings in %d entries\n", len(findings), len(v.Storage.Entries))
+	if len(findings) > 0 {
+		return errFindings
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/conejoninja/tesoro/firmware"
+	"github.com/conejoninja/tesoro/internal/cli"
+)
+
+var (
+	errNeedsAttention = errors.New("the device does not run an official bootloader and firmware without advisories")
+	errEmptyTable     = errors.New("nothing verified, the releases table is empty")
+)
+
+// verifyDevice compares the bootloader hash and firmware revision of the
+// device with the official releases and lists the advisories of its
+// firmware
+func verifyDevice(args []string) error {
+	fs := flag.NewFlagSet("verify-device", flag.ExitOnError)
+	table := fs.String("releases", "", "releases table to use instead of the bundled one")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return errors.New("usage: tesoro verify-device [-releases releases.json]")
+	}
+
+	var releases *firmware.Releases
+	var err error
+	if *table != "" {
+		releases, err = firmware.LoadReleases(*table)
+	} else {
+		releases, err = firmware.BundledReleases()
+	}
+	if err != nil {
+		return err
+	}
+	empty := len(releases.Bootloaders) == 0 && len(releases.Firmware) == 0
+	if empty {
+		fmt.Fprintln(os.Stderr, "WARNING: the releases table has no verified bootloaders or firmware yet, so the device can not be reported as official and \"unknown\" below does not mean it was modified. Pass a table checked against the SatoshiLabs release notes with -releases.")
+	}
+
+	client, err := cli.Open()
+	if err != nil {
+		return err
+	}
+	defer client.CloseTransport()
+	info, err := client.DeviceInfo()
+	if err != nil {
+		return err
+	}
+	if info.BootloaderMode {
+		return errors.New("the device is in bootloader mode, connect it in normal mode to read its hashes")
+	}
+
+	report := releases.Check(info)
+	fmt.Printf("%s firmware %s revision %s: %s\n", info.Model, report.Version, hex.EncodeToString(info.Features.GetRevision()), report.Firmware)
+	bootloader := report.Bootloader.String()
+	if report.BootloaderVersion != "" {
+		bootloader += " " + report.BootloaderVersion
+	}
+	fmt.Printf("bootloader %s: %s\n", hex.EncodeToString(info.Features.GetBootloaderHash()), bootloader)
+	for _, a := range report.Advisories {
+		fmt.Printf("advisory %s, fixed in %s: %s %s\n", a.ID, a.Fixed, a.Summary, a.URL)
+	}
+	if releases.Updated != "" {
+		fmt.Println("releases table updated", releases.Updated)
+	}
+	if empty {
+		return errEmptyTable
+	}
+	if !report.OK() {
+		return errNeedsAttention
+	}
+	return nil
+}
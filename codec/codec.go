@@ -0,0 +1,237 @@
+// Package codec encodes and decodes any message of the wire protocol from
+// its descriptors, without generated code. The descriptors come compiled
+// in, from the generated packages, or from a FileDescriptorSet such as the
+// WireProtocol of a config.Configuration, so messages of newer firmware
+// can be used before the generated code knows them.
+//
+// Messages are written as JSON objects with the field names of the .proto
+// files. Enums are written by name and read by name or number, bytes are
+// hex and 64 bits integers are numbers.
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/conejoninja/tesoro/pb/config"
+	protobuf "github.com/conejoninja/tesoro/pb/google/protobuf"
+	"github.com/golang/protobuf/proto"
+
+	// the compiled descriptors register themselves
+	_ "github.com/conejoninja/tesoro/pb/messages"
+	_ "github.com/conejoninja/tesoro/pb/types"
+)
+
+// messageTypePrefix starts the values of the MessageType enum, the rest
+// of the value is the name of the message
+const messageTypePrefix = "MessageType_"
+
+var errUnknownMessage = errors.New("unknown message")
+
+// Codec knows the messages of a set of descriptors
+type Codec struct {
+	messages map[string]*protobuf.DescriptorProto
+	// nested has the messages declared inside another
+	nested  map[string]bool
+	enums   map[string]*protobuf.EnumDescriptorProto
+	types   map[uint16]string
+	numbers map[string]uint16
+}
+
+// Compiled returns the codec of the messages compiled in the pb packages
+func Compiled() (*Codec, error) {
+	set, err := Descriptors()
+	if err != nil {
+		return nil, err
+	}
+	return New(set)
+}
+
+// Descriptors returns the descriptors compiled in the pb packages, with
+// their options and extensions
+func Descriptors() (*protobuf.FileDescriptorSet, error) {
+	var set protobuf.FileDescriptorSet
+	for _, name := range []string{"types.proto", "messages.proto"} {
+		gz := proto.FileDescriptor(name)
+		if gz == nil {
+			return nil, fmt.Errorf("descriptor %s not registered", name)
+		}
+		r, err := gzip.NewReader(bytes.NewReader(gz))
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		var file protobuf.FileDescriptorProto
+		if err = proto.Unmarshal(data, &file); err != nil {
+			return nil, err
+		}
+		set.File = append(set.File, &file)
+	}
+	return &set, nil
+}
+
+// Load returns the codec of a serialized FileDescriptorSet, as written by
+// protoc --descriptor_set_out
+func Load(data []byte) (*Codec, error) {
+	var set protobuf.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	return New(&set)
+}
+
+// FromConfiguration returns the codec of the WireProtocol of a
+// configuration, such as the one of a policy.Policy once verified
+func FromConfiguration(conf *config.Configuration) (*Codec, error) {
+	if len(conf.GetWireProtocol().GetFile()) == 0 {
+		return nil, fmt.Errorf("no wire protocol in the configuration")
+	}
+	return New(conf.GetWireProtocol())
+}
+
+// New returns the codec of a FileDescriptorSet. The message numbers come
+// from its MessageType enum.
+func New(set *protobuf.FileDescriptorSet) (*Codec, error) {
+	c := &Codec{
+		messages: map[string]*protobuf.DescriptorProto{},
+		nested:   map[string]bool{},
+		enums:    map[string]*protobuf.EnumDescriptorProto{},
+		types:    map[uint16]string{},
+		numbers:  map[string]uint16{},
+	}
+	for _, file := range set.GetFile() {
+		prefix := ""
+		if file.GetPackage() != "" {
+			prefix = file.GetPackage() + "."
+		}
+		for _, msg := range file.GetMessageType() {
+			c.addMessage(prefix, msg)
+		}
+		for _, enum := range file.GetEnumType() {
+			c.enums[prefix+enum.GetName()] = enum
+		}
+	}
+
+	var found []string
+	for name := range c.enums {
+		if name == "MessageType" || strings.HasSuffix(name, ".MessageType") {
+			found = append(found, name)
+		}
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("no MessageType enum in the descriptors")
+	}
+	if len(found) > 1 {
+		sort.Strings(found)
+		return nil, fmt.Errorf("several MessageType enums in the descriptors: %s", strings.Join(found, ", "))
+	}
+	// the messages of the package of the enum come first
+	prefix := strings.TrimSuffix(found[0], "MessageType")
+	for _, value := range c.enums[found[0]].GetValue() {
+		name := strings.TrimPrefix(value.GetName(), messageTypePrefix)
+		full := prefix + name
+		if _, ok := c.messages[full]; !ok {
+			var err error
+			full, err = c.fullName(name)
+			if errors.Is(err, errUnknownMessage) {
+				// a value without its message, left for older firmware
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		c.types[uint16(value.GetNumber())] = full
+		c.numbers[full] = uint16(value.GetNumber())
+	}
+	return c, nil
+}
+
+func (c *Codec) addMessage(prefix string, msg *protobuf.DescriptorProto) {
+	name := prefix + msg.GetName()
+	c.messages[name] = msg
+	for _, nested := range msg.GetNestedType() {
+		c.addMessage(name+".", nested)
+		c.nested[name+"."+nested.GetName()] = true
+	}
+	for _, enum := range msg.GetEnumType() {
+		c.enums[name+"."+enum.GetName()] = enum
+	}
+}
+
+// fullName finds a message by its full name, or by its short name when
+// one message only has it, or one only that is not nested in another
+func (c *Codec) fullName(name string) (string, error) {
+	name = strings.TrimPrefix(name, ".")
+	if _, ok := c.messages[name]; ok {
+		return name, nil
+	}
+	var found, top []string
+	for full := range c.messages {
+		if strings.HasSuffix(full, "."+name) {
+			found = append(found, full)
+			if !c.nested[full] {
+				top = append(top, full)
+			}
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return "", fmt.Errorf("%w %s", errUnknownMessage, name)
+	case len(found) == 1:
+		return found[0], nil
+	case len(top) == 1:
+		return top[0], nil
+	}
+	sort.Strings(found)
+	return "", fmt.Errorf("ambiguous message %s, use one of %s", name, strings.Join(found, ", "))
+}
+
+// Type returns the MessageType number of a message
+func (c *Codec) Type(name string) (uint16, bool) {
+	full, err := c.fullName(name)
+	if err != nil {
+		return 0, false
+	}
+	number, ok := c.numbers[full]
+	return number, ok
+}
+
+// Name returns the message of a MessageType number
+func (c *Codec) Name(msgType uint16) (string, bool) {
+	name, ok := c.types[msgType]
+	return name, ok
+}
+
+// Messages returns the names of the messages with a MessageType number
+func (c *Codec) Messages() []string {
+	names := make([]string, 0, len(c.numbers))
+	for name := range c.numbers {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (c *Codec) message(name string) (*protobuf.DescriptorProto, error) {
+	full, err := c.fullName(name)
+	if err != nil {
+		return nil, err
+	}
+	return c.messages[full], nil
+}
+
+func (c *Codec) enum(name string) (*protobuf.EnumDescriptorProto, error) {
+	name = strings.TrimPrefix(name, ".")
+	if enum, ok := c.enums[name]; ok {
+		return enum, nil
+	}
+	return nil, fmt.Errorf("unknown enum %s", name)
+}
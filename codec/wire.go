@@ -0,0 +1,493 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	protobuf "github.com/conejoninja/tesoro/pb/google/protobuf"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("truncated message")
+
+// Marshal encodes the JSON object of a message
+func (c *Codec) Marshal(name string, data []byte) ([]byte, error) {
+	msg, err := c.message(name)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var value interface{}
+	if err = d.Decode(&value); err != nil {
+		return nil, err
+	}
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s has to be a JSON object", name)
+	}
+	return c.encodeMessage(msg, obj)
+}
+
+// Unmarshal decodes a message to JSON
+func (c *Codec) Unmarshal(name string, data []byte) ([]byte, error) {
+	msg, err := c.message(name)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := c.decodeMessage(msg, data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(obj)
+}
+
+func (c *Codec) encodeMessage(msg *protobuf.DescriptorProto, obj map[string]interface{}) ([]byte, error) {
+	known := map[string]bool{}
+	var out []byte
+	for _, field := range msg.GetField() {
+		value, ok := obj[field.GetName()]
+		known[field.GetName()] = true
+		if !ok || value == nil {
+			continue
+		}
+		values := []interface{}{value}
+		if field.GetLabel() == protobuf.FieldDescriptorProto_LABEL_REPEATED {
+			if values, ok = value.([]interface{}); !ok {
+				return nil, fmt.Errorf("%s.%s has to be an array", msg.GetName(), field.GetName())
+			}
+		}
+		for _, v := range values {
+			encoded, err := c.encodeField(field, v)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", msg.GetName(), field.GetName(), err)
+			}
+			out = append(out, encoded...)
+		}
+	}
+	for name := range obj {
+		if !known[name] {
+			return nil, fmt.Errorf("%s has no field %s", msg.GetName(), name)
+		}
+	}
+	return out, nil
+}
+
+func tag(field *protobuf.FieldDescriptorProto, wireType int) []byte {
+	return proto.EncodeVarint(uint64(field.GetNumber())<<3 | uint64(wireType))
+}
+
+func (c *Codec) encodeField(field *protobuf.FieldDescriptorProto, value interface{}) ([]byte, error) {
+	switch field.GetType() {
+	case protobuf.FieldDescriptorProto_TYPE_INT32, protobuf.FieldDescriptorProto_TYPE_INT64:
+		n, err := toInt(value, field.GetType() == protobuf.FieldDescriptorProto_TYPE_INT32)
+		if err != nil {
+			return nil, err
+		}
+		return append(tag(field, wireVarint), proto.EncodeVarint(uint64(n))...), nil
+	case protobuf.FieldDescriptorProto_TYPE_UINT32, protobuf.FieldDescriptorProto_TYPE_UINT64:
+		n, err := toUint(value, field.GetType() == protobuf.FieldDescriptorProto_TYPE_UINT32)
+		if err != nil {
+			return nil, err
+		}
+		return append(tag(field, wireVarint), proto.EncodeVarint(n)...), nil
+	case protobuf.FieldDescriptorProto_TYPE_SINT32, protobuf.FieldDescriptorProto_TYPE_SINT64:
+		n, err := toInt(value, field.GetType() == protobuf.FieldDescriptorProto_TYPE_SINT32)
+		if err != nil {
+			return nil, err
+		}
+		return append(tag(field, wireVarint), proto.EncodeVarint(uint64(n<<1)^uint64(n>>63))...), nil
+	case protobuf.FieldDescriptorProto_TYPE_BOOL:
+		b, ok := value.(bool)
+		if !ok {
+			return nil, errors.New("expected true or false")
+		}
+		n := uint64(0)
+		if b {
+			n = 1
+		}
+		return append(tag(field, wireVarint), proto.EncodeVarint(n)...), nil
+	case protobuf.FieldDescriptorProto_TYPE_ENUM:
+		n, err := c.enumValue(field, value)
+		if err != nil {
+			return nil, err
+		}
+		return append(tag(field, wireVarint), proto.EncodeVarint(uint64(n))...), nil
+	case protobuf.FieldDescriptorProto_TYPE_FIXED32, protobuf.FieldDescriptorProto_TYPE_SFIXED32, protobuf.FieldDescriptorProto_TYPE_FLOAT:
+		var n uint32
+		switch field.GetType() {
+		case protobuf.FieldDescriptorProto_TYPE_FIXED32:
+			u, err := toUint(value, true)
+			if err != nil {
+				return nil, err
+			}
+			n = uint32(u)
+		case protobuf.FieldDescriptorProto_TYPE_SFIXED32:
+			i, err := toInt(value, true)
+			if err != nil {
+				return nil, err
+			}
+			n = uint32(int32(i))
+		default:
+			f, err := toFloat(value)
+			if err != nil {
+				return nil, err
+			}
+			n = math.Float32bits(float32(f))
+		}
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, n)
+		return append(tag(field, wireFixed32), b...), nil
+	case protobuf.FieldDescriptorProto_TYPE_FIXED64, protobuf.FieldDescriptorProto_TYPE_SFIXED64, protobuf.FieldDescriptorProto_TYPE_DOUBLE:
+		var n uint64
+		switch field.GetType() {
+		case protobuf.FieldDescriptorProto_TYPE_FIXED64:
+			u, err := toUint(value, false)
+			if err != nil {
+				return nil, err
+			}
+			n = u
+		case protobuf.FieldDescriptorProto_TYPE_SFIXED64:
+			i, err := toInt(value, false)
+			if err != nil {
+				return nil, err
+			}
+			n = uint64(i)
+		default:
+			f, err := toFloat(value)
+			if err != nil {
+				return nil, err
+			}
+			n = math.Float64bits(f)
+		}
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, n)
+		return append(tag(field, wireFixed64), b...), nil
+	case protobuf.FieldDescriptorProto_TYPE_STRING:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("expected a string")
+		}
+		return lengthDelimited(field, []byte(s)), nil
+	case protobuf.FieldDescriptorProto_TYPE_BYTES:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("expected bytes in hex")
+		}
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, errors.New("expected bytes in hex")
+		}
+		return lengthDelimited(field, b), nil
+	case protobuf.FieldDescriptorProto_TYPE_MESSAGE:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("expected an object")
+		}
+		msg, err := c.message(field.GetTypeName())
+		if err != nil {
+			return nil, err
+		}
+		b, err := c.encodeMessage(msg, obj)
+		if err != nil {
+			return nil, err
+		}
+		return lengthDelimited(field, b), nil
+	}
+	return nil, fmt.Errorf("unsupported field type %s", field.GetType())
+}
+
+func lengthDelimited(field *protobuf.FieldDescriptorProto, b []byte) []byte {
+	out := append(tag(field, wireBytes), proto.EncodeVarint(uint64(len(b)))...)
+	return append(out, b...)
+}
+
+func toInt(value interface{}, bits32 bool) (int64, error) {
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return 0, errors.New("expected an integer")
+	}
+	size := 64
+	if bits32 {
+		size = 32
+	}
+	return strconv.ParseInt(s, 10, size)
+}
+
+func toUint(value interface{}, bits32 bool) (uint64, error) {
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return 0, errors.New("expected an unsigned integer")
+	}
+	size := 64
+	if bits32 {
+		size = 32
+	}
+	return strconv.ParseUint(s, 10, size)
+}
+
+func toFloat(value interface{}) (float64, error) {
+	if n, ok := value.(json.Number); ok {
+		return n.Float64()
+	}
+	return 0, errors.New("expected a number")
+}
+
+func (c *Codec) enumValue(field *protobuf.FieldDescriptorProto, value interface{}) (int32, error) {
+	enum, err := c.enum(field.GetTypeName())
+	if err != nil {
+		return 0, err
+	}
+	switch v := value.(type) {
+	case string:
+		for _, ev := range enum.GetValue() {
+			if ev.GetName() == v {
+				return ev.GetNumber(), nil
+			}
+		}
+		return 0, fmt.Errorf("%s has no value %s", enum.GetName(), v)
+	case json.Number:
+		n, err := strconv.ParseInt(v.String(), 10, 32)
+		return int32(n), err
+	}
+	return 0, errors.New("expected an enum name or number")
+}
+
+// object keeps the fields in the order of the message
+type object []member
+
+type member struct {
+	name  string
+	value interface{}
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(m.name)
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (c *Codec) decodeMessage(msg *protobuf.DescriptorProto, data []byte) (object, error) {
+	values := map[int32][]interface{}{}
+	for len(data) > 0 {
+		key, n := proto.DecodeVarint(data)
+		if n == 0 {
+			return nil, errTruncated
+		}
+		data = data[n:]
+		number, wireType := int32(key>>3), int(key&7)
+
+		var raw []byte
+		var varint uint64
+		switch wireType {
+		case wireVarint:
+			if varint, n = proto.DecodeVarint(data); n == 0 {
+				return nil, errTruncated
+			}
+			raw, data = data[:n], data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return nil, errTruncated
+			}
+			raw, data = data[:8], data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return nil, errTruncated
+			}
+			raw, data = data[:4], data[4:]
+		case wireBytes:
+			size, n := proto.DecodeVarint(data)
+			if n == 0 || uint64(len(data)-n) < size {
+				return nil, errTruncated
+			}
+			raw, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", wireType)
+		}
+
+		field := fieldByNumber(msg, number)
+		if field == nil {
+			// unknown to these descriptors
+			continue
+		}
+		if wireType == wireBytes && packable(field) {
+			for len(raw) > 0 {
+				v, rest, err := c.decodePacked(field, raw)
+				if err != nil {
+					return nil, err
+				}
+				values[number] = append(values[number], v)
+				raw = rest
+			}
+			continue
+		}
+		v, err := c.decodeField(field, wireType, raw, varint)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %v", msg.GetName(), field.GetName(), err)
+		}
+		values[number] = append(values[number], v)
+	}
+
+	var obj object
+	for _, field := range msg.GetField() {
+		vs, ok := values[field.GetNumber()]
+		if !ok {
+			continue
+		}
+		if field.GetLabel() == protobuf.FieldDescriptorProto_LABEL_REPEATED {
+			obj = append(obj, member{field.GetName(), vs})
+		} else {
+			// the last one wins, as in any decoder
+			obj = append(obj, member{field.GetName(), vs[len(vs)-1]})
+		}
+	}
+	if obj == nil {
+		obj = object{}
+	}
+	return obj, nil
+}
+
+func fieldByNumber(msg *protobuf.DescriptorProto, number int32) *protobuf.FieldDescriptorProto {
+	for _, field := range msg.GetField() {
+		if field.GetNumber() == number {
+			return field
+		}
+	}
+	return nil
+}
+
+// packable tells if a repeated field can come packed in bytes
+func packable(field *protobuf.FieldDescriptorProto) bool {
+	if field.GetLabel() != protobuf.FieldDescriptorProto_LABEL_REPEATED {
+		return false
+	}
+	switch field.GetType() {
+	case protobuf.FieldDescriptorProto_TYPE_STRING, protobuf.FieldDescriptorProto_TYPE_BYTES, protobuf.FieldDescriptorProto_TYPE_MESSAGE, protobuf.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+	return true
+}
+
+func (c *Codec) decodePacked(field *protobuf.FieldDescriptorProto, raw []byte) (interface{}, []byte, error) {
+	switch field.GetType() {
+	case protobuf.FieldDescriptorProto_TYPE_FIXED32, protobuf.FieldDescriptorProto_TYPE_SFIXED32, protobuf.FieldDescriptorProto_TYPE_FLOAT:
+		if len(raw) < 4 {
+			return nil, nil, errTruncated
+		}
+		v, err := c.decodeField(field, wireFixed32, raw[:4], 0)
+		return v, raw[4:], err
+	case protobuf.FieldDescriptorProto_TYPE_FIXED64, protobuf.FieldDescriptorProto_TYPE_SFIXED64, protobuf.FieldDescriptorProto_TYPE_DOUBLE:
+		if len(raw) < 8 {
+			return nil, nil, errTruncated
+		}
+		v, err := c.decodeField(field, wireFixed64, raw[:8], 0)
+		return v, raw[8:], err
+	}
+	varint, n := proto.DecodeVarint(raw)
+	if n == 0 {
+		return nil, nil, errTruncated
+	}
+	v, err := c.decodeField(field, wireVarint, raw[:n], varint)
+	return v, raw[n:], err
+}
+
+func (c *Codec) decodeField(field *protobuf.FieldDescriptorProto, wireType int, raw []byte, varint uint64) (interface{}, error) {
+	expected := wireVarint
+	switch field.GetType() {
+	case protobuf.FieldDescriptorProto_TYPE_FIXED32, protobuf.FieldDescriptorProto_TYPE_SFIXED32, protobuf.FieldDescriptorProto_TYPE_FLOAT:
+		expected = wireFixed32
+	case protobuf.FieldDescriptorProto_TYPE_FIXED64, protobuf.FieldDescriptorProto_TYPE_SFIXED64, protobuf.FieldDescriptorProto_TYPE_DOUBLE:
+		expected = wireFixed64
+	case protobuf.FieldDescriptorProto_TYPE_STRING, protobuf.FieldDescriptorProto_TYPE_BYTES, protobuf.FieldDescriptorProto_TYPE_MESSAGE:
+		expected = wireBytes
+	}
+	if wireType != expected {
+		return nil, fmt.Errorf("wire type %d for a %s", wireType, field.GetType())
+	}
+
+	switch field.GetType() {
+	case protobuf.FieldDescriptorProto_TYPE_INT32:
+		return json.Number(strconv.FormatInt(int64(int32(varint)), 10)), nil
+	case protobuf.FieldDescriptorProto_TYPE_INT64:
+		return json.Number(strconv.FormatInt(int64(varint), 10)), nil
+	case protobuf.FieldDescriptorProto_TYPE_UINT32:
+		return json.Number(strconv.FormatUint(uint64(uint32(varint)), 10)), nil
+	case protobuf.FieldDescriptorProto_TYPE_UINT64:
+		return json.Number(strconv.FormatUint(varint, 10)), nil
+	case protobuf.FieldDescriptorProto_TYPE_SINT32, protobuf.FieldDescriptorProto_TYPE_SINT64:
+		return json.Number(strconv.FormatInt(int64(varint>>1)^-int64(varint&1), 10)), nil
+	case protobuf.FieldDescriptorProto_TYPE_BOOL:
+		return varint != 0, nil
+	case protobuf.FieldDescriptorProto_TYPE_ENUM:
+		enum, err := c.enum(field.GetTypeName())
+		if err != nil {
+			return nil, err
+		}
+		for _, ev := range enum.GetValue() {
+			if ev.GetNumber() == int32(varint) {
+				return ev.GetName(), nil
+			}
+		}
+		return json.Number(strconv.FormatInt(int64(int32(varint)), 10)), nil
+	case protobuf.FieldDescriptorProto_TYPE_FIXED32:
+		return json.Number(strconv.FormatUint(uint64(binary.LittleEndian.Uint32(raw)), 10)), nil
+	case protobuf.FieldDescriptorProto_TYPE_SFIXED32:
+		return json.Number(strconv.FormatInt(int64(int32(binary.LittleEndian.Uint32(raw))), 10)), nil
+	case protobuf.FieldDescriptorProto_TYPE_FLOAT:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(raw))), nil
+	case protobuf.FieldDescriptorProto_TYPE_FIXED64:
+		return json.Number(strconv.FormatUint(binary.LittleEndian.Uint64(raw), 10)), nil
+	case protobuf.FieldDescriptorProto_TYPE_SFIXED64:
+		return json.Number(strconv.FormatInt(int64(binary.LittleEndian.Uint64(raw)), 10)), nil
+	case protobuf.FieldDescriptorProto_TYPE_DOUBLE:
+		return math.Float64frombits(binary.LittleEndian.Uint64(raw)), nil
+	case protobuf.FieldDescriptorProto_TYPE_STRING:
+		return string(raw), nil
+	case protobuf.FieldDescriptorProto_TYPE_BYTES:
+		return hex.EncodeToString(raw), nil
+	case protobuf.FieldDescriptorProto_TYPE_MESSAGE:
+		msg, err := c.message(field.GetTypeName())
+		if err != nil {
+			return nil, err
+		}
+		return c.decodeMessage(msg, raw)
+	}
+	return nil, fmt.Errorf("unsupported field type %s", field.GetType())
+}
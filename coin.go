@@ -0,0 +1,231 @@
+package tesoro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/conejoninja/tesoro/pb/types"
+)
+
+var (
+	ErrUnknownCoin = errors.New("unknown coin")
+	ErrFeeTooHigh  = errors.New("fee over the maximum of the coin")
+)
+
+// Coin is a coin the firmware signs for, as the features list it or, for
+// the firmware that does not, from the fallback table
+type Coin struct {
+	Name                string
+	Shortcut            string
+	AddressType         uint32
+	AddressTypeP2SH     uint32
+	MaxFeeKb            uint64
+	SignedMessageHeader string
+	XpubMagic           uint32
+	XprvMagic           uint32
+	Segwit              bool
+	// ForkID is the fork id of the signatures, nil for a coin without
+	ForkID      *uint32
+	ForceBIP143 bool
+	// BIP44 is the SLIP-44 index of the coin, -1 when the fallback table
+	// does not have it
+	BIP44 int
+}
+
+// fallbackCoins are the coins of trezor-common, for the features without
+// coins and to know their SLIP-44 index
+var fallbackCoins = []Coin{
+	{Name: "Bitcoin", Shortcut: "BTC", AddressType: 0, AddressTypeP2SH: 5, MaxFeeKb: 2000000, SignedMessageHeader: "Bitcoin Signed Message:\n", XpubMagic: 0x0488b21e, XprvMagic: 0x0488ade4, Segwit: true, BIP44: 0},
+	{Name: "Testnet", Shortcut: "TEST", AddressType: 111, AddressTypeP2SH: 196, MaxFeeKb: 10000000, SignedMessageHeader: "Bitcoin Signed Message:\n", XpubMagic: 0x043587cf, XprvMagic: 0x04358394, Segwit: true, BIP44: 1},
+	{Name: "Bcash", Shortcut: "BCH", AddressType: 0, AddressTypeP2SH: 5, MaxFeeKb: 500000, SignedMessageHeader: "Bitcoin Signed Message:\n", XpubMagic: 0x0488b21e, XprvMagic: 0x0488ade4, ForkID: new(uint32), ForceBIP143: true, BIP44: 145},
+	{Name: "Litecoin", Shortcut: "LTC", AddressType: 48, AddressTypeP2SH: 50, MaxFeeKb: 40000000, SignedMessageHeader: "Litecoin Signed Message:\n", XpubMagic: 0x019da462, XprvMagic: 0x019d9cfe, Segwit: true, BIP44: 2},
+	{Name: "Dogecoin", Shortcut: "DOGE", AddressType: 30, AddressTypeP2SH: 22, MaxFeeKb: 1000000000, SignedMessageHeader: "Dogecoin Signed Message:\n", XpubMagic: 0x02facafd, XprvMagic: 0x02fac398, BIP44: 3},
+	{Name: "Dash", Shortcut: "DASH", AddressType: 76, AddressTypeP2SH: 16, MaxFeeKb: 100000, SignedMessageHeader: "DarkCoin Signed Message:\n", XpubMagic: 0x02fe52cc, XprvMagic: 0x02fe52f8, BIP44: 5},
+}
+
+// DefaultCoin is the coin used when none is given
+const DefaultCoin = "Bitcoin"
+
+// NewCoin returns the coin of a CoinType of the features, with the
+// SLIP-44 index of the fallback table
+func NewCoin(t *types.CoinType) *Coin {
+	coin := &Coin{
+		Name:                t.GetCoinName(),
+		Shortcut:            t.GetCoinShortcut(),
+		AddressType:         t.GetAddressType(),
+		AddressTypeP2SH:     t.GetAddressTypeP2Sh(),
+		MaxFeeKb:            t.GetMaxfeeKb(),
+		SignedMessageHeader: t.GetSignedMessageHeader(),
+		XpubMagic:           t.GetXpubMagic(),
+		XprvMagic:           t.GetXprvMagic(),
+		Segwit:              t.GetSegwit(),
+		ForceBIP143:         t.GetForceBip143(),
+		BIP44:               -1,
+	}
+	if t.Forkid != nil {
+		forkID := t.GetForkid()
+		coin.ForkID = &forkID
+	}
+	if fallback, err := LookupCoin(coin.Name); err == nil {
+		coin.BIP44 = fallback.BIP44
+	}
+	return coin
+}
+
+// LookupCoin finds a coin of the fallback table by name or shortcut, in
+// any case
+func LookupCoin(name string) (*Coin, error) {
+	for i := range fallbackCoins {
+		if strings.EqualFold(fallbackCoins[i].Name, name) || strings.EqualFold(fallbackCoins[i].Shortcut, name) {
+			coin := fallbackCoins[i]
+			return &coin, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownCoin, name)
+}
+
+// Coin finds a coin by name or shortcut, in the features when they were
+// read and list it, in the fallback table otherwise
+func (c *Client) Coin(name string) (*Coin, error) {
+	if c.info != nil {
+		if coin, ok := c.info.Coin(name); ok {
+			return coin, nil
+		}
+	}
+	return LookupCoin(name)
+}
+
+// coinName is the name the firmware knows a coin by, the name given when
+// the coin is unknown
+func (c *Client) coinName(name string) string {
+	if coin, err := c.Coin(name); err == nil {
+		return coin.Name
+	}
+	return name
+}
+
+// Path is the BIP44 path m/44'/coin'/account'/change/index of the coin
+func (c *Coin) Path(account, change, index uint32) ([]uint32, error) {
+	if c.BIP44 < 0 {
+		return nil, fmt.Errorf("no SLIP-44 index for %s", c.Name)
+	}
+	return []uint32{hardened(44), hardened(uint32(c.BIP44)), hardened(account), change, index}, nil
+}
+
+// AccountPath is the BIP44 path m/44'/coin'/account' of the coin
+func (c *Coin) AccountPath(account uint32) ([]uint32, error) {
+	path, err := c.Path(account, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return path[:3], nil
+}
+
+// Address encodes the HASH160 of a public key, or of a script when p2sh
+// is true, as an address of the coin
+func (c *Coin) Address(hash []byte, p2sh bool) string {
+	version := c.AddressType
+	if p2sh {
+		version = c.AddressTypeP2SH
+	}
+	return base58CheckEncode(append(versionBytes(version), hash...))
+}
+
+// DecodeAddress returns the HASH160 of an address of the coin and if it is
+// a P2SH address
+func (c *Coin) DecodeAddress(address string) ([]byte, bool, error) {
+	payload, err := base58CheckDecode(address)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid address %s: %v", address, err)
+	}
+	for _, p2sh := range []bool{false, true} {
+		version := c.AddressType
+		if p2sh {
+			version = c.AddressTypeP2SH
+		}
+		prefix := versionBytes(version)
+		if len(payload) == len(prefix)+20 && bytes.HasPrefix(payload, prefix) {
+			return payload[len(prefix):], p2sh, nil
+		}
+	}
+	return nil, false, fmt.Errorf("%s is not a %s address", address, c.Name)
+}
+
+// Xpub encodes a public node as an extended public key of the coin
+func (c *Coin) Xpub(node *types.HDNodeType) string {
+	data := make([]byte, 13, 78)
+	binary.BigEndian.PutUint32(data, c.XpubMagic)
+	data[4] = byte(node.GetDepth())
+	binary.BigEndian.PutUint32(data[5:], node.GetFingerprint())
+	binary.BigEndian.PutUint32(data[9:], node.GetChildNum())
+	data = append(data, node.GetChainCode()...)
+	data = append(data, node.GetPublicKey()...)
+	return base58CheckEncode(data)
+}
+
+// CheckFee refuses a fee over the maximum per kB of the coin for a
+// transaction of size bytes
+func (c *Coin) CheckFee(fee uint64, size int) error {
+	if c.MaxFeeKb == 0 {
+		return nil
+	}
+	if max := c.MaxFeeKb * uint64(size) / 1000; fee > max {
+		return fmt.Errorf("%w: %d for about %d bytes, %s allows %d", ErrFeeTooHigh, fee, size, c.Name, max)
+	}
+	return nil
+}
+
+// checkTransaction checks, before signing, that the base58 addresses of
+// the outputs are of the coin and that the fee is under its maximum. The
+// fee is only checked when the amounts of all the inputs are known.
+func (c *Coin) checkTransaction(tx types.TransactionType, prev map[string]types.TransactionType) error {
+	var spent, paid uint64
+	for _, out := range tx.Outputs {
+		paid += out.GetAmount()
+		if out.Address == nil {
+			continue
+		}
+		// bech32 and other encodings are left to the firmware
+		if _, err := base58CheckDecode(out.GetAddress()); err != nil {
+			continue
+		}
+		if _, _, err := c.DecodeAddress(out.GetAddress()); err != nil {
+			return err
+		}
+	}
+	for _, in := range tx.Inputs {
+		if in.Amount != nil {
+			spent += in.GetAmount()
+			continue
+		}
+		p, ok := prev[hex.EncodeToString(in.PrevHash)]
+		if !ok || int(in.GetPrevIndex()) >= len(p.BinOutputs) {
+			return nil
+		}
+		spent += p.BinOutputs[in.GetPrevIndex()].GetAmount()
+	}
+	if paid > spent {
+		return fmt.Errorf("the outputs pay %d, more than the %d of the inputs", paid, spent)
+	}
+	// the size of P2PKH inputs and outputs, segwit transactions are smaller
+	size := 10 + 148*len(tx.Inputs) + 34*len(tx.Outputs)
+	return c.CheckFee(spent-paid, size)
+}
+
+// versionBytes is the address type as the firmware writes it, without its
+// leading zero bytes
+func versionBytes(version uint32) []byte {
+	switch {
+	case version > 0xffffff:
+		return []byte{byte(version >> 24), byte(version >> 16), byte(version >> 8), byte(version)}
+	case version > 0xffff:
+		return []byte{byte(version >> 16), byte(version >> 8), byte(version)}
+	case version > 0xff:
+		return []byte{byte(version >> 8), byte(version)}
+	}
+	return []byte{byte(version)}
+}
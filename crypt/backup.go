@@ -0,0 +1,146 @@
+package crypt
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteTar writes the files and directories to a tar stream, named
+// relative to the parent of each one
+func WriteTar(w io.Writer, roots ...string) error {
+	tw := tar.NewWriter(w)
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		base := filepath.Dir(root)
+		err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			name, err := filepath.Rel(base, path)
+			if err != nil {
+				return err
+			}
+			link := ""
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if link, err = os.Readlink(path); err != nil {
+					return err
+				}
+			}
+			hdr, err := tar.FileInfoHeader(fi, link)
+			if err != nil {
+				// sockets, devices and the like are skipped
+				return nil
+			}
+			hdr.Name = filepath.ToSlash(name)
+			if fi.IsDir() {
+				hdr.Name += "/"
+			}
+			if err = tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.CopyN(tw, f, hdr.Size)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ExtractTar extracts a tar stream in dir. Entries that would end outside
+// of dir, or be written through a link, are refused, and existing files
+// are not overwritten.
+func ExtractTar(r io.Reader, dir string) ([]string, error) {
+	var names []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return names, err
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if outside(name) {
+			return names, fmt.Errorf("refusing to extract %s outside of %s", hdr.Name, dir)
+		}
+		if err = throughLink(dir, name); err != nil {
+			return names, err
+		}
+		path := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, os.FileMode(hdr.Mode).Perm()|0700)
+		case tar.TypeReg:
+			if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return names, err
+			}
+			var f *os.File
+			if f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return names, err
+			}
+			if _, err = io.Copy(f, tr); err == nil {
+				err = f.Close()
+			} else {
+				f.Close()
+			}
+		case tar.TypeSymlink:
+			if outside(filepath.Join(filepath.Dir(name), filepath.FromSlash(hdr.Linkname))) || filepath.IsAbs(hdr.Linkname) {
+				return names, fmt.Errorf("refusing to extract link %s to %s", hdr.Name, hdr.Linkname)
+			}
+			if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return names, err
+			}
+			err = os.Symlink(hdr.Linkname, path)
+		default:
+			continue
+		}
+		if err != nil {
+			return names, err
+		}
+		names = append(names, hdr.Name)
+	}
+}
+
+// throughLink refuses a name whose parents in dir are links, which could
+// lead anywhere once extracted
+func throughLink(dir, name string) error {
+	parent := dir
+	for _, elem := range strings.Split(filepath.Dir(name), string(filepath.Separator)) {
+		if elem == "." {
+			continue
+		}
+		parent = filepath.Join(parent, elem)
+		fi, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %s through the link %s", name, parent)
+		}
+	}
+	return nil
+}
+
+// outside tells if a clean relative name leaves its directory
+func outside(name string) bool {
+	name = filepath.Clean(name)
+	return filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator))
+}
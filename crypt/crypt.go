@@ -0,0 +1,317 @@
+// Package crypt encrypts files with a random data key that only the device
+// can unwrap. The data key is wrapped with CipherKeyValue at a BIP32 path
+// under a key label, the one shown on the device when decrypting.
+//
+// A file starts with "TSRO", the format version and a JSON header with
+// the wrapped keys, followed by the data in AES-256-GCM chunks. Each chunk
+// nonce holds its number and whether it is the last one, so chunks can not
+// be reordered, dropped or the file truncated.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/conejoninja/tesoro"
+)
+
+const (
+	magic   = "TSRO"
+	Version = 1
+
+	// DefaultChunkSize of the plaintext in every chunk
+	DefaultChunkSize = 64 * 1024
+	maxChunkSize     = 16 * 1024 * 1024
+	maxHeaderSize    = 1024 * 1024
+	noncePrefixSize  = 7
+)
+
+var (
+	ErrFormat    = errors.New("not a tesoro encrypted file")
+	ErrVersion   = errors.New("unsupported tesoro encrypted file version")
+	ErrTampered  = errors.New("encrypted file is corrupted or was modified")
+	ErrTruncated = errors.New("encrypted file is truncated")
+)
+
+// Header describes an encrypted file
+type Header struct {
+	Version   int    `json:"version"`
+	ChunkSize int    `json:"chunk_size"`
+	Nonce     []byte `json:"nonce"`
+	// Keys are the data key wrapped for each device that can decrypt
+	Keys []WrappedKey `json:"keys"`
+}
+
+// WrappedKey is the data key encrypted by a device
+type WrappedKey struct {
+	// Path and Label select the device key, Label is shown on the device
+	Path         string `json:"path"`
+	Label        string `json:"label"`
+	IV           []byte `json:"iv"`
+	Key          []byte `json:"key"`
+	Check        []byte `json:"check"`
+	AskOnEncrypt bool   `json:"ask_on_encrypt"`
+	AskOnDecrypt bool   `json:"ask_on_decrypt"`
+	// DeviceID and DeviceLabel identify the recipient, when known
+	DeviceID    string `json:"device_id,omitempty"`
+	DeviceLabel string `json:"device_label,omitempty"`
+}
+
+// NewHeader returns a header for a new file, with its own nonce
+func NewHeader(keys ...WrappedKey) (Header, error) {
+	nonce, err := tesoro.GenerateRandomBytes(noncePrefixSize)
+	if err != nil {
+		return Header{}, err
+	}
+	return Header{Version: Version, ChunkSize: DefaultChunkSize, Nonce: nonce, Keys: keys}, nil
+}
+
+// WriteHeader writes the magic, version and header
+func WriteHeader(w io.Writer, h Header) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	prefix := make([]byte, len(magic)+1+4)
+	copy(prefix, magic)
+	prefix[len(magic)] = byte(h.Version)
+	binary.BigEndian.PutUint32(prefix[len(magic)+1:], uint32(len(data)))
+	if _, err = w.Write(prefix); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadHeader reads the header, leaving r at the first chunk
+func ReadHeader(r io.Reader) (Header, error) {
+	var h Header
+	prefix := make([]byte, len(magic)+1+4)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return h, ErrFormat
+	}
+	if string(prefix[:len(magic)]) != magic {
+		return h, ErrFormat
+	}
+	if prefix[len(magic)] != Version {
+		return h, ErrVersion
+	}
+	size := binary.BigEndian.Uint32(prefix[len(magic)+1:])
+	if size > maxHeaderSize {
+		return h, ErrFormat
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return h, ErrTruncated
+	}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return h, fmt.Errorf("invalid header: %v", err)
+	}
+	if h.Version != Version {
+		return h, ErrVersion
+	}
+	if h.ChunkSize <= 0 || h.ChunkSize > maxChunkSize || len(h.Nonce) != noncePrefixSize {
+		return h, ErrFormat
+	}
+	return h, nil
+}
+
+// stream seals or opens the chunks of a file
+type stream struct {
+	aead    cipher.AEAD
+	header  Header
+	counter uint32
+}
+
+func newStream(key []byte, h Header) (*stream, error) {
+	if len(key) != 32 {
+		return nil, errors.New("data key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &stream{aead: aead, header: h}, nil
+}
+
+func (s *stream) nonce(last bool) ([]byte, error) {
+	if s.counter == ^uint32(0) {
+		return nil, errors.New("too many chunks")
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	copy(nonce, s.header.Nonce)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], s.counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	s.counter++
+	return nonce, nil
+}
+
+// ad binds the chunks to the parameters of the file, not to the wrapped
+// keys, so recipients can change without encrypting the data again
+func (s *stream) ad() []byte {
+	ad := make([]byte, len(magic)+1+4+noncePrefixSize)
+	copy(ad, magic)
+	ad[len(magic)] = byte(s.header.Version)
+	binary.BigEndian.PutUint32(ad[len(magic)+1:], uint32(s.header.ChunkSize))
+	copy(ad[len(magic)+5:], s.header.Nonce)
+	return ad
+}
+
+// Writer encrypts what is written to it. Close must be called to write
+// the last chunk.
+type Writer struct {
+	w      io.Writer
+	s      *stream
+	buf    []byte
+	closed bool
+}
+
+// NewWriter writes the header and returns a writer that encrypts with key
+func NewWriter(w io.Writer, key []byte, h Header) (*Writer, error) {
+	s, err := newStream(key, h)
+	if err != nil {
+		return nil, err
+	}
+	if err = WriteHeader(w, h); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, s: s, buf: make([]byte, 0, h.ChunkSize+1)}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed crypt.Writer")
+	}
+	n := 0
+	for len(p) > 0 {
+		// a full chunk is only written once more data follows it, the last
+		// one has to be marked as such
+		if len(w.buf) == w.s.header.ChunkSize {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(w.buf[len(w.buf):w.s.header.ChunkSize], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// Close writes the last chunk, it does not close the underlying writer
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+func (w *Writer) seal(last bool) error {
+	nonce, err := w.s.nonce(last)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(w.s.aead.Seal(nil, nonce, w.buf, w.s.ad()))
+	w.buf = w.buf[:0]
+	return err
+}
+
+// Reader decrypts a file, every chunk is authenticated before any of its
+// data is returned
+type Reader struct {
+	r    *bufio.Reader
+	s    *stream
+	buf  []byte
+	done bool
+}
+
+// NewReader returns a reader of the file whose header was already read
+// from r
+func NewReader(r io.Reader, key []byte, h Header) (*Reader, error) {
+	s, err := newStream(key, h)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: bufio.NewReaderSize(r, h.ChunkSize+s.aead.Overhead()+1), s: s}, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *Reader) open() error {
+	chunk := make([]byte, r.s.header.ChunkSize+r.s.aead.Overhead())
+	n, err := io.ReadFull(r.r, chunk)
+	last := false
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		// a full chunk is the last one if nothing follows
+		if _, err = r.r.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+	if n < r.s.aead.Overhead() {
+		return ErrTruncated
+	}
+
+	nonce, err := r.s.nonce(last)
+	if err != nil {
+		return err
+	}
+	plain, err := r.s.aead.Open(chunk[:0], nonce, chunk[:n], r.s.ad())
+	if err != nil {
+		if last {
+			return ErrTruncated
+		}
+		return ErrTampered
+	}
+	r.buf = plain
+	r.done = last
+	return nil
+}
+
+// Rewrap copies an encrypted file replacing its header, the chunks are not
+// touched. The data key must be the same.
+func Rewrap(w io.Writer, r io.Reader, h Header) error {
+	old, err := ReadHeader(r)
+	if err != nil {
+		return err
+	}
+	if old.ChunkSize != h.ChunkSize || !bytes.Equal(old.Nonce, h.Nonce) || old.Version != h.Version {
+		return errors.New("header does not belong to this file")
+	}
+	if err = WriteHeader(w, h); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
@@ -0,0 +1,150 @@
+package crypt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+)
+
+// DefaultPath is the BIP32 path used to wrap file keys
+const DefaultPath = "m/10019'/0'"
+
+var (
+	ErrNoKey    = errors.New("the file was not encrypted for this device")
+	ErrWrongKey = errors.New("the device unwrapped a different key, wrong device or passphrase?")
+)
+
+// NewKey returns a random data key
+func NewKey() ([]byte, error) {
+	return tesoro.GenerateRandomBytes(32)
+}
+
+// Wrap encrypts the data key on the device. Decrypting it will ask for
+// confirmation on the device, showing label.
+func Wrap(client *tesoro.Client, key []byte, path, label string) (WrappedKey, error) {
+	if !tesoro.ValidBIP32(path) {
+		return WrappedKey{}, fmt.Errorf("invalid BIP32 path %s", path)
+	}
+	iv, err := tesoro.GenerateRandomBytes(16)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	wk := WrappedKey{Path: path, Label: label, IV: iv, AskOnDecrypt: true}
+	if wk.Key, err = cipherKeyValue(client, true, wk, key); err != nil {
+		return WrappedKey{}, err
+	}
+	wk.Check = keyCheck(key)
+	return wk, nil
+}
+
+// Unwrap decrypts the data key on the device
+func Unwrap(client *tesoro.Client, wk WrappedKey) ([]byte, error) {
+	key, err := cipherKeyValue(client, false, wk, []byte(fmt.Sprintf("%x", wk.Key)))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 || !hmac.Equal(keyCheck(key), wk.Check) {
+		return nil, ErrWrongKey
+	}
+	return key, nil
+}
+
+// keyCheck tells if a key was unwrapped right, without revealing it
+func keyCheck(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("tesoro key check"))
+	return mac.Sum(nil)[:8]
+}
+
+func cipherKeyValue(client *tesoro.Client, encrypt bool, wk WrappedKey, value []byte) ([]byte, error) {
+	msg := client.CipherKeyValue(encrypt, wk.Label, value, tesoro.StringToBIP32Path(wk.Path), wk.IV, wk.AskOnEncrypt, wk.AskOnDecrypt)
+	str, msgType, err := client.Exchange(msg)
+	if err != nil {
+		return nil, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_CipheredKeyValue {
+		return nil, fmt.Errorf("unexpected response from device: %s", str)
+	}
+	return []byte(str), nil
+}
+
+// Encrypt returns a writer that encrypts to w with a new data key wrapped
+// by the device at path under label. Close has to be called.
+func Encrypt(client *tesoro.Client, w io.Writer, path, label string) (*Writer, error) {
+	key, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	wk, err := Wrap(client, key, path, label)
+	if err != nil {
+		return nil, err
+	}
+	h, err := NewHeader(wk)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriter(w, key, h)
+}
+
+// Decrypt reads the header from r, unwraps the data key on the device and
+// returns the reader of the plaintext. With several recipients the one
+// made for this device is used, or each one is tried in turn.
+func Decrypt(client *tesoro.Client, r io.Reader) (*Reader, Header, error) {
+	h, err := ReadHeader(r)
+	if err != nil {
+		return nil, h, err
+	}
+	key, err := UnwrapAny(client, h)
+	if err != nil {
+		return nil, h, err
+	}
+	reader, err := NewReader(r, key, h)
+	return reader, h, err
+}
+
+// UnwrapAny unwraps the data key of the header. Keys named for other
+// devices are skipped, the rest are tried until one unwraps.
+func UnwrapAny(client *tesoro.Client, h Header) ([]byte, error) {
+	keys := h.Keys
+	if h.HasRecipients() {
+		id, _, err := DeviceID(client)
+		if err != nil {
+			return nil, err
+		}
+		keys = nil
+		for _, wk := range h.Keys {
+			if wk.DeviceID == id || wk.DeviceID == "" {
+				keys = append(keys, wk)
+			}
+		}
+	}
+	for _, wk := range keys {
+		// a key wrapped by another device, or with another passphrase,
+		// fails the check; a failure means the user cancelled on the device
+		key, err := Unwrap(client, wk)
+		if err == nil {
+			return key, nil
+		}
+		if _, ok := err.(*tesoro.FailureError); ok {
+			return nil, err
+		}
+	}
+	return nil, ErrNoKey
+}
+
+// DeviceID returns the device id and label from its features
+func DeviceID(client *tesoro.Client) (string, string, error) {
+	info, err := client.DeviceInfo()
+	if err != nil {
+		return "", "", err
+	}
+	if info.DeviceID == "" {
+		return "", "", errors.New("the device has no id, is it initialized?")
+	}
+	return info.DeviceID, info.Label, nil
+}
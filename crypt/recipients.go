@@ -0,0 +1,71 @@
+package crypt
+
+import (
+	"errors"
+
+	"github.com/conejoninja/tesoro"
+)
+
+var ErrNoRecipient = errors.New("no such recipient")
+
+// WrapFor wraps the data key on the device and names the device as the
+// recipient of the wrapped key
+func WrapFor(client *tesoro.Client, key []byte, path, label string) (WrappedKey, error) {
+	id, deviceLabel, err := DeviceID(client)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	wk, err := Wrap(client, key, path, label)
+	if err != nil {
+		return WrappedKey{}, err
+	}
+	wk.DeviceID, wk.DeviceLabel = id, deviceLabel
+	return wk, nil
+}
+
+// HasRecipients tells if the keys name the devices they were wrapped for
+func (h *Header) HasRecipients() bool {
+	for _, wk := range h.Keys {
+		if wk.DeviceID != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Recipient returns the key wrapped for a device
+func (h *Header) Recipient(deviceID string) (WrappedKey, bool) {
+	for _, wk := range h.Keys {
+		if wk.DeviceID == deviceID {
+			return wk, true
+		}
+	}
+	return WrappedKey{}, false
+}
+
+// AddRecipient adds the key wrapped for a device, replacing the one it had
+func (h *Header) AddRecipient(wk WrappedKey) {
+	for i := range h.Keys {
+		if h.Keys[i].DeviceID == wk.DeviceID {
+			h.Keys[i] = wk
+			return
+		}
+	}
+	h.Keys = append(h.Keys, wk)
+}
+
+// RemoveRecipient removes the key of a device. The last recipient can not
+// be removed. The data key stays the same, a removed device that kept a
+// copy of the file, or of the key, can still decrypt it.
+func (h *Header) RemoveRecipient(deviceID string) error {
+	for i := range h.Keys {
+		if h.Keys[i].DeviceID == deviceID {
+			if len(h.Keys) == 1 {
+				return errors.New("can not remove the only recipient")
+			}
+			h.Keys = append(h.Keys[:i], h.Keys[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoRecipient
+}
@@ -0,0 +1,223 @@
+package daemon
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/pb/types"
+)
+
+// request has the parameters of every operation, each one uses some
+type request struct {
+	Device string `json:"device"`
+
+	Path    string `json:"path"`
+	Coin    string `json:"coin"`
+	Show    bool   `json:"show"`
+	Message string `json:"message"`
+
+	// sign-tx
+	Transaction  types.TransactionType            `json:"transaction"`
+	Transactions map[string]types.TransactionType `json:"transactions"`
+
+	// cipher-key-value, Value and IV in hex
+	Key          string `json:"key"`
+	Value        string `json:"value"`
+	IV           string `json:"iv"`
+	Encrypt      bool   `json:"encrypt"`
+	AskOnEncrypt bool   `json:"ask_on_encrypt"`
+	AskOnDecrypt bool   `json:"ask_on_decrypt"`
+}
+
+type operation func(client *tesoro.Client, req request) (interface{}, error)
+
+var operations = map[string]operation{
+	"features":         features,
+	"address":          address,
+	"sign-message":     signMessage,
+	"sign-tx":          signTx,
+	"cipher-key-value": cipherKeyValue,
+}
+
+func (s *Server) handleCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	op, ok := operations[strings.TrimPrefix(r.URL.Path, "/call/")]
+	if !ok {
+		http.Error(w, "unknown operation", http.StatusNotFound)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dev, err := s.acquire(r.Context(), req.Device)
+	if err == ErrUnknownDevice {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err == ErrDeviceNotAllowed {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.release(dev)
+
+	sess := &session{id: newSessionID(), ctx: r.Context(), timeout: s.PromptTimeout, answers: make(chan answer, 1), w: w}
+	s.mu.Lock()
+	s.sessions[sess.id] = sess
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess.id)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	sess.send(event{Event: "session"})
+	dev.client.SetPrompter(sess)
+	result, err := op(dev.client, req)
+	if err != nil {
+		if sess.unanswered {
+			// the device is still waiting for the answer
+			dev.client.Call(dev.client.Cancel())
+		}
+		sess.send(event{Event: "error", Error: err.Error()})
+		return
+	}
+	if f, ok := result.(*messages.Features); ok {
+		s.mu.Lock()
+		dev.id, dev.label = f.GetDeviceId(), f.GetLabel()
+		s.mu.Unlock()
+	}
+	sess.send(event{Event: "result", Result: result})
+}
+
+func exchange(client *tesoro.Client, msg []byte, expected messages.MessageType) (string, error) {
+	str, msgType, err := client.Exchange(msg)
+	if err != nil {
+		return "", err
+	}
+	if messages.MessageType(msgType) != expected {
+		return "", fmt.Errorf("unexpected response from device: %s", str)
+	}
+	return str, nil
+}
+
+func path(req request) ([]uint32, error) {
+	if !tesoro.ValidBIP32(req.Path) {
+		return nil, fmt.Errorf("invalid BIP32 path %q", req.Path)
+	}
+	return tesoro.StringToBIP32Path(req.Path), nil
+}
+
+func features(client *tesoro.Client, req request) (interface{}, error) {
+	info, err := client.DeviceInfo()
+	if err != nil {
+		return nil, err
+	}
+	return info.Features, nil
+}
+
+// coinName is the coin of the request, Bitcoin when it has none. The
+// client resolves tickers and sends unknown names as given.
+func coinName(req request) string {
+	if req.Coin == "" {
+		return tesoro.DefaultCoin
+	}
+	return req.Coin
+}
+
+// address gets the address of the path, the first BIP44 address of the
+// coin without one
+func address(client *tesoro.Client, req request) (interface{}, error) {
+	var p []uint32
+	var err error
+	if req.Path == "" {
+		var c *tesoro.Coin
+		if c, err = client.Coin(coinName(req)); err == nil {
+			p, err = c.Path(0, 0, 0)
+		}
+	} else {
+		p, err = path(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	str, err := exchange(client, client.GetAddress(p, req.Show, coinName(req)), messages.MessageType_MessageType_Address)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"address": str}, nil
+}
+
+func signMessage(client *tesoro.Client, req request) (interface{}, error) {
+	p, err := path(req)
+	if err != nil {
+		return nil, err
+	}
+	str, err := exchange(client, client.SignMessagePath(p, []byte(req.Message), req.Coin), messages.MessageType_MessageType_MessageSignature)
+	if err != nil {
+		return nil, err
+	}
+	var sig messages.MessageSignature
+	if err = json.Unmarshal([]byte(str), &sig); err != nil {
+		return nil, err
+	}
+	return map[string]string{"address": sig.GetAddress(), "signature": hex.EncodeToString(sig.GetSignature())}, nil
+}
+
+func signTx(client *tesoro.Client, req request) (interface{}, error) {
+	if len(req.Transaction.Inputs) == 0 || len(req.Transaction.Outputs) == 0 {
+		return nil, errors.New("the transaction needs inputs and outputs")
+	}
+	signatures, serialized, err := client.SignTransaction(coinName(req), req.Transaction, req.Transactions)
+	if err != nil {
+		return nil, err
+	}
+	sigs := make([]string, len(signatures))
+	for i, sig := range signatures {
+		sigs[i] = hex.EncodeToString(sig)
+	}
+	return map[string]interface{}{"signatures": sigs, "serialized_tx": hex.EncodeToString(serialized)}, nil
+}
+
+func cipherKeyValue(client *tesoro.Client, req request) (interface{}, error) {
+	p, err := path(req)
+	if err != nil {
+		return nil, err
+	}
+	value, err := hex.DecodeString(req.Value)
+	if err != nil || len(value) == 0 {
+		return nil, errors.New("value must be hex")
+	}
+	iv, err := hex.DecodeString(req.IV)
+	if err != nil || (len(iv) != 0 && len(iv) != 16) {
+		return nil, errors.New("iv must be 16 bytes in hex")
+	}
+	if !req.Encrypt && len(value)%16 != 0 {
+		return nil, errors.New("value to decrypt must be a multiple of 16 bytes")
+	}
+	// the client takes the value to decrypt in hex
+	if !req.Encrypt {
+		value = []byte(req.Value)
+	}
+	str, err := exchange(client, client.CipherKeyValue(req.Encrypt, req.Key, value, p, iv, req.AskOnEncrypt, req.AskOnDecrypt), messages.MessageType_MessageType_CipheredKeyValue)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"value": hex.EncodeToString([]byte(str))}, nil
+}
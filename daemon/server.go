@@ -0,0 +1,316 @@
+// Package daemon shares the devices between processes: it owns the bus and
+// serves the client operations as a JSON API on localhost, one operation
+// at a time per device.
+//
+// Every operation is a POST to /call/<operation> with a JSON body naming
+// the device, answered with a stream of JSON lines:
+//
+//	{"event":"session","session":"..."}
+//	{"event":"pin","session":"...","message":"..."}
+//	{"event":"result","result":{...}}
+//
+// PIN, passphrase and word requests are answered by posting
+// {"answer":"..."} to /prompt/<session>, or {"cancel":true}. Button
+// requests are only reported. The last line is a result or an error event.
+package daemon
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/policy"
+	"github.com/conejoninja/tesoro/transport"
+)
+
+const maxRequestSize = 1024 * 1024
+
+var (
+	ErrUnknownDevice    = errors.New("unknown device")
+	ErrDeviceNotAllowed = errors.New("device not allowed by the configuration")
+)
+
+// Server serves the devices of a bus
+type Server struct {
+	Bus transport.Bus
+	// Origins allowed to call from a browser, requests without an Origin
+	// header come from local programs and are always allowed
+	Origins []string
+	// PromptTimeout is how long a prompt waits for its answer
+	PromptTimeout time.Duration
+	// Policy, when set, adds the origins it allows, refuses the ones it
+	// blocks and limits the devices to its known devices
+	Policy *policy.Policy
+
+	mu sync.Mutex
+	// devices by device id, or by path for a device without id; paths
+	// has the key of the device at each path while the devices found stay
+	// the enumerated ones
+	devices    map[string]*device
+	paths      map[string]string
+	enumerated string
+	sessions   map[string]*session
+}
+
+// device is a connected device, lock is held by the session using it
+type device struct {
+	lock   chan struct{}
+	client *tesoro.Client
+	id     string
+	label  string
+}
+
+// DeviceInfo describes a device in /devices
+type DeviceInfo struct {
+	Path      string `json:"path"`
+	VendorID  int    `json:"vendor_id"`
+	ProductID int    `json:"product_id"`
+	DeviceID  string `json:"device_id,omitempty"`
+	Label     string `json:"label,omitempty"`
+	Busy      bool   `json:"busy"`
+}
+
+func New(bus transport.Bus, origins []string) *Server {
+	return &Server{
+		Bus:           bus,
+		Origins:       origins,
+		PromptTimeout: 5 * time.Minute,
+		devices:       map[string]*device{},
+		paths:         map[string]string{},
+		sessions:      map[string]*session{},
+	}
+}
+
+// Handler returns the HTTP handler of the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/devices", s.handleDevices)
+	mux.HandleFunc("/call/", s.handleCall)
+	mux.HandleFunc("/prompt/", s.handlePrompt)
+	return s.guard(mux)
+}
+
+// ListenAndServe serves the API on addr, which has to be a loopback address
+func (s *Server) ListenAndServe(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("%s is not a loopback address", host)
+	}
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+// guard refuses other hosts, against DNS rebinding, and origins not
+// allowed, and answers the CORS preflight of the allowed ones
+func (s *Server) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if ip := net.ParseIP(strings.Trim(host, "[]")); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			http.Error(w, "host not allowed", http.StatusForbidden)
+			return
+		}
+		if s.Policy != nil {
+			if err := s.Policy.Check(time.Now()); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !s.allowed(origin) {
+				http.Error(w, "origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Vary", "Origin")
+			if r.Method == http.MethodOptions {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) allowed(origin string) bool {
+	if s.Policy != nil && s.Policy.Blocked(origin) {
+		return false
+	}
+	for _, o := range s.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return s.Policy != nil && s.Policy.AllowURL(origin)
+}
+
+// allowedDevice tells if the policy lets the device be used, a device
+// whose id is not known yet is allowed when the policy needs it
+func (s *Server) allowedDevice(d transport.Device, id string) bool {
+	if s.Policy == nil || s.Policy.AllowDevice(d, id) {
+		return true
+	}
+	return id == "" && s.Policy.NeedsDeviceID()
+}
+
+func (s *Server) handleDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	found, err := s.Bus.Enumerate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	infos := []DeviceInfo{}
+	s.mu.Lock()
+	s.forget(found)
+	for _, d := range found {
+		info := DeviceInfo{Path: d.Path, VendorID: d.VendorID, ProductID: d.ProductID}
+		if dev, ok := s.devices[s.paths[d.Path]]; ok {
+			info.DeviceID, info.Label, info.Busy = dev.id, dev.label, len(dev.lock) > 0
+		}
+		if s.allowedDevice(d, info.DeviceID) {
+			infos = append(infos, info)
+		}
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// acquire waits for the device to be free, connecting it on first use.
+// The device has to be released. The bus is only used without the lock
+// held, so that a slow device does not hold up the others.
+func (s *Server) acquire(ctx context.Context, path string) (*device, error) {
+	found, err := s.Bus.Enumerate()
+	if err != nil {
+		return nil, err
+	}
+	var d *transport.Device
+	for i := range found {
+		if found[i].Path == path {
+			d = &found[i]
+		}
+	}
+	s.mu.Lock()
+	s.forget(found)
+	dev := s.devices[s.paths[path]]
+	s.mu.Unlock()
+	if d == nil {
+		return nil, ErrUnknownDevice
+	}
+	if dev == nil {
+		if dev, err = s.connect(*d); err != nil {
+			return nil, err
+		}
+	}
+
+	select {
+	case dev.lock <- struct{}{}:
+		return dev, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// connect connects a device and reads its id, the device already
+// connected with that id is kept
+func (s *Server) connect(d transport.Device) (*device, error) {
+	if !s.allowedDevice(d, "") {
+		return nil, ErrDeviceNotAllowed
+	}
+	t, err := s.Bus.Connect(d)
+	if err != nil {
+		return nil, err
+	}
+	dev := &device{lock: make(chan struct{}, 1), client: &tesoro.Client{}}
+	dev.client.SetTransport(t)
+	info, err := dev.client.DeviceInfo()
+	if err == nil && s.Policy != nil && !s.Policy.AllowDevice(d, info.DeviceID) {
+		err = ErrDeviceNotAllowed
+	}
+	if err != nil {
+		dev.client.CloseTransport()
+		return nil, err
+	}
+	dev.id, dev.label = info.DeviceID, info.Label
+
+	key := dev.id
+	if key == "" {
+		key = d.Path
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if known, ok := s.devices[key]; ok {
+		dev.client.CloseTransport()
+		dev = known
+	}
+	s.devices[key] = dev
+	s.paths[d.Path] = key
+	return dev, nil
+}
+
+// forget drops the path of every device when the devices found changed:
+// the bus numbers them in order, a device unplugged moves the ones after
+// it to another path. The devices nobody is using are closed, the next
+// request reads the id of the device at its path again. s.mu has to be
+// held.
+func (s *Server) forget(found []transport.Device) {
+	paths := make([]string, len(found))
+	for i, d := range found {
+		paths[i] = d.Path
+	}
+	if enumerated := strings.Join(paths, "\n"); enumerated != s.enumerated {
+		s.enumerated = enumerated
+		s.paths = map[string]string{}
+	}
+	used := map[string]bool{}
+	for _, key := range s.paths {
+		used[key] = true
+	}
+	for key, dev := range s.devices {
+		if !used[key] && len(dev.lock) == 0 {
+			dev.client.CloseTransport()
+			delete(s.devices, key)
+		}
+	}
+}
+
+func (s *Server) release(dev *device) {
+	dev.client.SetPrompter(nil)
+	<-dev.lock
+}
+
+// Close closes the devices
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, dev := range s.devices {
+		dev.client.CloseTransport()
+		delete(s.devices, id)
+	}
+	s.paths = map[string]string{}
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
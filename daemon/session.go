@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCancelled     = errors.New("cancelled by the caller")
+	ErrPromptTimeout = errors.New("no answer to the prompt")
+)
+
+// event is a line of the stream of a call
+type event struct {
+	Event   string      `json:"event"`
+	Session string      `json:"session,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Result  interface{} `json:"result,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+type answer struct {
+	Answer string `json:"answer"`
+	Cancel bool   `json:"cancel"`
+}
+
+// session is a call in progress, it is the Prompter of the client while
+// it holds the device
+type session struct {
+	id      string
+	ctx     context.Context
+	timeout time.Duration
+	answers chan answer
+
+	// mu guards the writes to the stream, the answers of a prompt do not
+	// reach the stream until the prompt is done
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	waiting bool
+	// unanswered is set when a prompt got no answer
+	unanswered bool
+}
+
+func (s *session) send(e event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.Session = s.id
+	json.NewEncoder(s.w).Encode(e)
+	if f, ok := s.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ask sends a prompt and waits for its answer
+func (s *session) ask(kind, msg string) (string, error) {
+	s.mu.Lock()
+	s.waiting = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.waiting = false
+		s.mu.Unlock()
+	}()
+
+	s.send(event{Event: kind, Message: msg})
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case a := <-s.answers:
+		if !a.Cancel {
+			return a.Answer, nil
+		}
+		err = ErrCancelled
+	case <-timer.C:
+		err = ErrPromptTimeout
+	case <-s.ctx.Done():
+		err = s.ctx.Err()
+	}
+	s.unanswered = true
+	return "", err
+}
+
+func (s *session) PinMatrix(msg string) (string, error) {
+	return s.ask("pin", msg)
+}
+
+func (s *session) Passphrase(msg string) (string, error) {
+	return s.ask("passphrase", msg)
+}
+
+func (s *session) Word(msg string) (string, error) {
+	return s.ask("word", msg)
+}
+
+func (s *session) ButtonRequest(msg string) {
+	s.send(event{Event: "button", Message: msg})
+}
+
+// answer hands the answer to the prompt waiting, if there is one
+func (s *session) answer(a answer) bool {
+	s.mu.Lock()
+	waiting := s.waiting
+	s.mu.Unlock()
+	if !waiting {
+		return false
+	}
+	select {
+	case s.answers <- a:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) handlePrompt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var a answer
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	sess, ok := s.sessions[strings.TrimPrefix(r.URL.Path, "/prompt/")]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	if !sess.answer(a) {
+		http.Error(w, "no prompt waiting", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
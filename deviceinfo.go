@@ -0,0 +1,218 @@
+package tesoro
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/pb/types"
+)
+
+var ErrUnsupported = errors.New("unsupported by firmware")
+
+// UnsupportedError is returned by Exchange, before sending anything, when
+// the features of the device tell it cannot do what is asked
+type UnsupportedError struct {
+	Feature string
+	Model   Model
+	Version Version
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s is unsupported by firmware %s of the %s", e.Feature, e.Version, e.Model)
+}
+
+// Is makes errors.Is(err, ErrUnsupported) true
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// Version is a firmware or bootloader version
+type Version struct {
+	Major, Minor, Patch uint32
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Less tells if v is older than o
+func (v Version) Less(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+// Model is the device model
+type Model int
+
+const (
+	ModelUnknown Model = iota
+	ModelOne
+	ModelT
+)
+
+func (m Model) String() string {
+	switch m {
+	case ModelOne:
+		return "TREZOR One"
+	case ModelT:
+		return "TREZOR Model T"
+	}
+	return "unknown model"
+}
+
+// ethereumOne is the first TREZOR One firmware with Ethereum
+var ethereumOne = Version{1, 4, 0}
+
+// DeviceInfo is what the features tell of a device
+type DeviceInfo struct {
+	Features       *messages.Features
+	Version        Version
+	Model          Model
+	DeviceID       string
+	Label          string
+	BootloaderMode bool
+	Initialized    bool
+	// Coins has the coins of the firmware by name and by shortcut
+	Coins map[string]*Coin
+}
+
+// NewDeviceInfo returns the information of the features. In bootloader
+// mode the version is the one of the bootloader.
+func NewDeviceInfo(features *messages.Features) *DeviceInfo {
+	d := &DeviceInfo{
+		Features:       features,
+		Version:        Version{features.GetMajorVersion(), features.GetMinorVersion(), features.GetPatchVersion()},
+		DeviceID:       features.GetDeviceId(),
+		Label:          features.GetLabel(),
+		BootloaderMode: features.GetBootloaderMode(),
+		Initialized:    features.GetInitialized(),
+		Coins:          map[string]*Coin{},
+	}
+	switch {
+	case features.GetModel() == "1":
+		d.Model = ModelOne
+	case features.GetModel() == "T":
+		d.Model = ModelT
+	case features.Model == nil && d.Version.Major == 1:
+		// the firmware older than the model field
+		d.Model = ModelOne
+	case features.Model == nil && d.Version.Major == 2:
+		d.Model = ModelT
+	}
+	for _, t := range features.GetCoins() {
+		coin := NewCoin(t)
+		d.Coins[coin.Name] = coin
+		if coin.Shortcut != "" {
+			d.Coins[coin.Shortcut] = coin
+		}
+	}
+	return d
+}
+
+// Coin finds a coin of the firmware by name or shortcut, in any case
+func (d *DeviceInfo) Coin(name string) (*Coin, bool) {
+	if coin, ok := d.Coins[name]; ok {
+		return coin, true
+	}
+	for key, coin := range d.Coins {
+		if strings.EqualFold(key, name) {
+			return coin, true
+		}
+	}
+	return nil, false
+}
+
+// SupportsCoin tells if the firmware knows the coin. Without a list of
+// coins in the features any coin is assumed to be known.
+func (d *DeviceInfo) SupportsCoin(name string) bool {
+	if len(d.Coins) == 0 {
+		return true
+	}
+	_, ok := d.Coin(name)
+	return ok
+}
+
+// SupportsSegwit tells if the firmware has segwit for the coin
+func (d *DeviceInfo) SupportsSegwit(coin string) bool {
+	c, ok := d.Coin(coin)
+	return ok && c.Segwit
+}
+
+// SupportsEthereum tells if the firmware has the Ethereum messages
+func (d *DeviceInfo) SupportsEthereum() bool {
+	if d.BootloaderMode {
+		return false
+	}
+	switch d.Model {
+	case ModelOne:
+		return !d.Version.Less(ethereumOne)
+	case ModelT:
+		return true
+	}
+	return false
+}
+
+// NeedsBackup tells if the seed was never written down
+func (d *DeviceInfo) NeedsBackup() bool {
+	return d.Features.GetNeedsBackup()
+}
+
+// DeviceInfo asks the device for its features
+func (c *Client) DeviceInfo() (*DeviceInfo, error) {
+	str, msgType, err := c.Exchange(c.Initialize())
+	if err != nil {
+		return nil, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_Features || c.info == nil {
+		return nil, fmt.Errorf("unexpected response from device: %s", str)
+	}
+	return c.info, nil
+}
+
+// check refuses the messages the features of the device tell it does not
+// support. Nothing is refused before the features are read.
+func (c *Client) check(msg []byte) error {
+	if c.info == nil || c.info.BootloaderMode || len(msg) < 8 {
+		return nil
+	}
+	msgType := binary.BigEndian.Uint16(msg[2:4])
+	decoded, err := Decode(msgType, msg[8:])
+	if err != nil {
+		return nil
+	}
+	unsupported := func(feature string) error {
+		return &UnsupportedError{Feature: feature, Model: c.info.Model, Version: c.info.Version}
+	}
+
+	info, _ := Message(messages.MessageType(msgType))
+	if strings.HasPrefix(info.Name, "Ethereum") && c.info.Model != ModelUnknown && !c.info.SupportsEthereum() {
+		return unsupported("Ethereum")
+	}
+	m, ok := decoded.(interface{ GetCoinName() string })
+	if !ok {
+		return nil
+	}
+	coin := m.GetCoinName()
+	if !c.info.SupportsCoin(coin) {
+		return unsupported("coin " + coin)
+	}
+	if m, ok := decoded.(interface {
+		GetScriptType() types.InputScriptType
+	}); ok {
+		switch m.GetScriptType() {
+		case types.InputScriptType_SPENDWITNESS, types.InputScriptType_SPENDP2SHWITNESS:
+			if len(c.info.Coins) > 0 && !c.info.SupportsSegwit(coin) {
+				return unsupported("segwit on " + coin)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/conejoninja/hid"
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/sshagent"
+	"github.com/conejoninja/tesoro/transport"
+)
+
+// Usage: sshagent -socket /tmp/tesoro.sock ssh://git@github.com ssh://me@host,ed25519
+// then export SSH_AUTH_SOCK=/tmp/tesoro.sock
+func main() {
+	socket := flag.String("socket", "/tmp/tesoro-agent.sock", "unix socket to listen on")
+	flag.Parse()
+
+	var identities []sshagent.Identity
+	for _, arg := range flag.Args() {
+		identity, err := sshagent.ParseIdentity(arg)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		identities = append(identities, identity)
+	}
+	if len(identities) == 0 {
+		fmt.Println("At least one ssh:// identity is required")
+		os.Exit(1)
+	}
+
+	var client tesoro.Client
+	numberDevices := 0
+
+	hid.UsbWalk(func(device hid.Device) {
+		info := device.Info()
+		if info.Vendor == transport.VendorOne && info.Product == transport.ProductOne && info.Interface == 0 {
+			numberDevices++
+			var t transport.TransportHID
+			t.SetDevice(device)
+			client.SetTransport(&t)
+			return
+		}
+
+	})
+	if numberDevices == 0 {
+		fmt.Println("No TREZOR devices found, make sure your device is connected")
+		return
+	}
+	defer client.CloseTransport()
+	client.SetPrompter(&stdinPrompter{bufio.NewReader(os.Stdin)})
+
+	os.Remove(*socket)
+	fmt.Printf("Listening on %s\nexport SSH_AUTH_SOCK=%s\n", *socket, *socket)
+	a := sshagent.New(&client, identities...)
+	a.SetErrorLog(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	if err := a.ListenAndServe(*socket); err != nil {
+		fmt.Println(err)
+	}
+}
+
+type stdinPrompter struct {
+	r *bufio.Reader
+}
+
+func (p *stdinPrompter) readLine(msg string) (string, error) {
+	fmt.Println(msg)
+	line, err := p.r.ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
+func (p *stdinPrompter) PinMatrix(msg string) (string, error) {
+	return p.readLine(msg)
+}
+
+func (p *stdinPrompter) Passphrase(msg string) (string, error) {
+	return p.readLine(msg)
+}
+
+func (p *stdinPrompter) Word(msg string) (string, error) {
+	return p.readLine(msg)
+}
+
+func (p *stdinPrompter) ButtonRequest(msg string) {
+	fmt.Println(msg)
+}
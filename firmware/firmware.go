@@ -0,0 +1,192 @@
+// Package firmware checks TREZOR One firmware images and installs them on
+// a device in bootloader mode.
+//
+// An image starts with a 256 bytes header:
+//
+//	magic      "TRZR"
+//	codelen    uint32, little endian, the length of the code after the header
+//	sigindex   3 bytes, 1 based indexes of the keys of the signatures, 0 unsigned
+//	flags      1 byte
+//	reserved   52 bytes
+//	signatures 3 x 64 bytes, r || s of the SHA-256 of the code
+//
+// The bootloader installs an image whatever its signatures say, but shows
+// a warning for the ones that are not signed by SatoshiLabs.
+package firmware
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/internal/secp256k1"
+)
+
+const (
+	Magic      = "TRZR"
+	HeaderSize = 256
+	signatures = 3
+)
+
+var (
+	ErrMagic     = errors.New("not a TREZOR One firmware")
+	ErrLength    = errors.New("the firmware length does not match its header")
+	ErrUnsigned  = errors.New("the firmware is not signed")
+	ErrSignature = errors.New("the firmware signatures do not verify against the SatoshiLabs keys")
+)
+
+// VendorKeys are the SatoshiLabs keys the TREZOR One bootloader knows, the
+// signature indexes of the header refer to them
+var VendorKeys = [][]byte{
+	mustHex("04d571b7f148c5e4232c3814f777d8faeaf1a84216c78d569b71041ffc768a5b2d810fc3bb134dd026b57e65005275aedef43e155f48fc11a32ec790a93312bd58"),
+	mustHex("0463279c0c0866e50c05c799d32bd6bab0188b6de06536d1109d2ed9ce76cb335c490e55aee10cc901215132e853097d5432eda06b792073bd7740c94ce4516cb1"),
+	mustHex("0443aedbb6f7e71c563f8ed2ef64ec9981482519e7ef4f4aa98b27854e8c49126d4956d300ab45fdc34cd26bc8710de0a31dbdf6de7435fd0b492be70ac75fde58"),
+	mustHex("04877c39fd7c62237e038235e9c075dab261630f78eeb8edb92487159fffedfdf6046c6f8b881fa407c4a4ce6c28de0b19c1f4e29f1fcbc5a58ffd1432a3e0938a"),
+	mustHex("047384c51ae81add0a523adbb186c91b906ffb64c2c765802bf26dbd13bdf12c319e80c2213a136c8ee03d7874fd22b70d68e7dee469decfbbb510ee9a460cda45"),
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// Header is the header of a firmware image
+type Header struct {
+	CodeLen    uint32
+	SigIndex   [signatures]uint8
+	Flags      uint8
+	Signatures [signatures][64]byte
+}
+
+// Firmware is a firmware image
+type Firmware struct {
+	Header
+	// Code is the firmware after the header
+	Code []byte
+	data []byte
+}
+
+// Parse parses a firmware image and checks its header
+func Parse(data []byte) (*Firmware, error) {
+	if len(data) < HeaderSize || string(data[:4]) != Magic {
+		return nil, ErrMagic
+	}
+	f := &Firmware{data: data, Code: data[HeaderSize:]}
+	f.CodeLen = binary.LittleEndian.Uint32(data[4:8])
+	copy(f.SigIndex[:], data[8:11])
+	f.Flags = data[11]
+	for i := range f.Signatures {
+		copy(f.Signatures[i][:], data[64+64*i:128+64*i])
+	}
+	if uint64(f.CodeLen) != uint64(len(f.Code)) {
+		return nil, ErrLength
+	}
+	return f, nil
+}
+
+// Bytes returns the image as it is uploaded to the device
+func (f *Firmware) Bytes() []byte {
+	return f.data
+}
+
+// Hash returns the SHA-256 of the code, the one the signatures sign
+func (f *Firmware) Hash() []byte {
+	hash := sha256.Sum256(f.Code)
+	return hash[:]
+}
+
+// Fingerprint is the hash in hex, the bootloader shows it before installing
+func (f *Firmware) Fingerprint() string {
+	return hex.EncodeToString(f.Hash())
+}
+
+// Signed tells if the header has signature indexes
+func (f *Firmware) Signed() bool {
+	for _, index := range f.SigIndex {
+		if index != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the three signatures were made by different keys of keys,
+// VendorKeys when nil, as the bootloader does
+func (f *Firmware) Verify(keys [][]byte) error {
+	if keys == nil {
+		keys = VendorKeys
+	}
+	if !f.Signed() {
+		return ErrUnsigned
+	}
+	hash := f.Hash()
+	for i, index := range f.SigIndex {
+		if index < 1 || int(index) > len(keys) {
+			return ErrSignature
+		}
+		for _, other := range f.SigIndex[:i] {
+			if other == index {
+				return ErrSignature
+			}
+		}
+		key, err := secp256k1.ParsePublicKey(keys[index-1])
+		if err != nil {
+			return err
+		}
+		if !secp256k1.Verify(key, hash, f.Signatures[i][:]) {
+			return ErrSignature
+		}
+	}
+	return nil
+}
+
+// Version is a firmware version
+type Version = tesoro.Version
+
+var versionRe = regexp.MustCompile(`(\d+)\.(\d+)\.(\d+)`)
+
+// ParseVersion parses a version such as 1.6.3 or v1.6.3
+func ParseVersion(s string) (Version, error) {
+	m := versionRe.FindStringSubmatch(s)
+	if m == nil || (m[0] != s && "v"+m[0] != s) {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+	return versionOf(m), nil
+}
+
+// VersionFromName finds the version in a firmware file name such as
+// trezor-1.6.3.bin. The header does not have one.
+func VersionFromName(filename string) (Version, bool) {
+	m := versionRe.FindStringSubmatch(filepath.Base(filename))
+	if m == nil {
+		return Version{}, false
+	}
+	return versionOf(m), true
+}
+
+func versionOf(m []string) Version {
+	var n [3]uint32
+	for i := range n {
+		v, _ := strconv.ParseUint(m[i+1], 10, 32)
+		n[i] = uint32(v)
+	}
+	return Version{Major: n[0], Minor: n[1], Patch: n[2]}
+}
+
+// Downgrade tells if installing next on the device goes back to an older
+// version. Only a device in normal mode tells its firmware version.
+func Downgrade(info *tesoro.DeviceInfo, next Version) bool {
+	if info.BootloaderMode {
+		return false
+	}
+	return next.Less(info.Version)
+}
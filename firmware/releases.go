@@ -0,0 +1,171 @@
+package firmware
+
+import (
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/conejoninja/tesoro"
+)
+
+// releasesJSON is the bundled table, update it from the SatoshiLabs
+// release notes and keep an entry out until it is checked against them
+//
+//go:embed releases.json
+var releasesJSON []byte
+
+// Releases is a table of the official releases
+type Releases struct {
+	Updated     string       `json:"updated"`
+	Bootloaders []Bootloader `json:"bootloaders"`
+	Firmware    []Release    `json:"firmware"`
+	Advisories  []Advisory   `json:"advisories"`
+}
+
+// Bootloader is an official bootloader, Hash is the SHA-256 the firmware
+// reports in the features
+type Bootloader struct {
+	Version string `json:"version"`
+	Hash    string `json:"hash"`
+}
+
+// Release is an official firmware, Revision is the git commit it reports
+type Release struct {
+	Version  string `json:"version"`
+	Revision string `json:"revision"`
+}
+
+// Advisory is a security issue of the firmware older than Fixed
+type Advisory struct {
+	ID      string `json:"id"`
+	Fixed   string `json:"fixed"`
+	Summary string `json:"summary"`
+	URL     string `json:"url,omitempty"`
+}
+
+// BundledReleases returns the table compiled in
+func BundledReleases() (*Releases, error) {
+	return ParseReleases(releasesJSON)
+}
+
+// LoadReleases reads a table from a file, to use a newer one than the
+// bundled
+func LoadReleases(filename string) (*Releases, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseReleases(data)
+}
+
+// ParseReleases parses a table and checks its versions and hashes
+func ParseReleases(data []byte) (*Releases, error) {
+	var r Releases
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid releases table: %v", err)
+	}
+	for _, b := range r.Bootloaders {
+		if h, err := hex.DecodeString(b.Hash); err != nil || len(h) != 32 {
+			return nil, fmt.Errorf("invalid hash of bootloader %s", b.Version)
+		}
+	}
+	for _, f := range r.Firmware {
+		if _, err := ParseVersion(f.Version); err != nil {
+			return nil, err
+		}
+		if _, err := hex.DecodeString(f.Revision); err != nil || f.Revision == "" {
+			return nil, fmt.Errorf("invalid revision of firmware %s", f.Version)
+		}
+	}
+	for _, a := range r.Advisories {
+		if _, err := ParseVersion(a.Fixed); err != nil {
+			return nil, fmt.Errorf("advisory %s: %v", a.ID, err)
+		}
+	}
+	return &r, nil
+}
+
+// Status is what the table says of a bootloader or firmware
+type Status int
+
+const (
+	// Unreported when the device does not tell
+	Unreported Status = iota
+	// Official when it is in the table
+	Official
+	// Unknown when it is not in the table, it was not released by
+	// SatoshiLabs, was modified or is newer than the table
+	Unknown
+	// Mismatch when the firmware version is in the table with another
+	// revision
+	Mismatch
+)
+
+func (s Status) String() string {
+	switch s {
+	case Official:
+		return "official"
+	case Unknown:
+		return "unknown"
+	case Mismatch:
+		return "mismatch"
+	}
+	return "not reported"
+}
+
+// Report is the check of a device against the table
+type Report struct {
+	Version           Version
+	Bootloader        Status
+	BootloaderVersion string
+	Firmware          Status
+	Advisories        []Advisory
+}
+
+// OK tells if nothing in the report needs attention
+func (r *Report) OK() bool {
+	return r.Bootloader == Official && r.Firmware == Official && len(r.Advisories) == 0
+}
+
+// Check compares a device in normal mode with the table
+func (r *Releases) Check(info *tesoro.DeviceInfo) *Report {
+	features := info.Features
+	report := &Report{Version: info.Version}
+
+	if hash := features.GetBootloaderHash(); len(hash) > 0 {
+		report.Bootloader = Unknown
+		for _, b := range r.Bootloaders {
+			if strings.EqualFold(b.Hash, hex.EncodeToString(hash)) {
+				report.Bootloader = Official
+				report.BootloaderVersion = b.Version
+				break
+			}
+		}
+	}
+
+	if revision := features.GetRevision(); len(revision) > 0 {
+		report.Firmware = Unknown
+		for _, f := range r.Firmware {
+			v, _ := ParseVersion(f.Version)
+			if v != report.Version {
+				continue
+			}
+			if strings.EqualFold(f.Revision, hex.EncodeToString(revision)) {
+				report.Firmware = Official
+				break
+			}
+			report.Firmware = Mismatch
+		}
+	}
+
+	for _, a := range r.Advisories {
+		fixed, _ := ParseVersion(a.Fixed)
+		if report.Version.Less(fixed) {
+			report.Advisories = append(report.Advisories, a)
+		}
+	}
+	return report
+}
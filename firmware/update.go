@@ -0,0 +1,42 @@
+package firmware
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+)
+
+var ErrNotBootloader = errors.New("the device is not in bootloader mode, disconnect it and connect it again while holding both buttons")
+
+// Update erases the firmware of a device in bootloader mode and installs
+// f. The device asks to confirm both steps, the upload shows the
+// fingerprint of f first. progress, when not nil, is told the bytes
+// uploaded.
+func Update(c *tesoro.Client, f *Firmware, progress func(written, total int)) error {
+	info, err := c.DeviceInfo()
+	if err != nil {
+		return err
+	}
+	if !info.BootloaderMode {
+		return ErrNotBootloader
+	}
+
+	if _, _, err = c.Exchange(c.FirmwareErase()); err != nil {
+		return fmt.Errorf("erasing the firmware: %v", err)
+	}
+	str, msgType := c.CallProgress(c.FirmwareUpload(f.Bytes()), progress)
+	if messages.MessageType(msgType) == messages.MessageType_MessageType_ButtonRequest {
+		str, msgType, err = c.Exchange(c.ButtonAck())
+	} else if messages.MessageType(msgType) == messages.MessageType_MessageType_Failure {
+		err = &tesoro.FailureError{Message: str}
+	}
+	if err != nil {
+		return fmt.Errorf("uploading the firmware: %v", err)
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_Success {
+		return fmt.Errorf("uploading the firmware: unexpected answer %s", str)
+	}
+	return nil
+}
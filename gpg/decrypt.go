@@ -0,0 +1,258 @@
+package gpg
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/zlib"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/conejoninja/tesoro/pb/messages"
+)
+
+var (
+	ErrNoRecipient = errors.New("message is not encrypted to this key")
+	ErrIntegrity   = errors.New("message integrity check failed")
+)
+
+// Decrypt decrypts an OpenPGP message (armored or binary) encrypted to the
+// key's ECDH subkey and returns the literal data. The shared secret is
+// computed on the device with GetECDHSessionKey.
+func (k *Key) Decrypt(message []byte) ([]byte, error) {
+	data, err := Dearmor(message)
+	if err != nil {
+		return nil, err
+	}
+
+	subkeyID := k.SubkeyFingerprint()[12:]
+	var sessionKey []byte
+	var symAlgo byte
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		tag, body, err := ReadPacket(r)
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagPKESK:
+			if sessionKey != nil || len(body) < 10 || body[0] != 3 || body[9] != algoECDH {
+				continue
+			}
+			if !bytes.Equal(body[1:9], subkeyID) && !bytes.Equal(body[1:9], make([]byte, 8)) {
+				continue
+			}
+			symAlgo, sessionKey, err = k.unwrapSessionKey(body[10:])
+			if err != nil {
+				return nil, err
+			}
+		case tagSEIPD:
+			if sessionKey == nil {
+				return nil, ErrNoRecipient
+			}
+			plain, err := decryptSEIPD(symAlgo, sessionKey, body)
+			if err != nil {
+				return nil, err
+			}
+			return literalData(plain)
+		case 9, 20:
+			return nil, fmt.Errorf("unsupported encrypted data packet %d", tag)
+		}
+		// marker and symmetric key packets are skipped
+	}
+	return nil, ErrNoRecipient
+}
+
+// unwrapSessionKey implements the ECDH decryption of RFC 6637 section 8.
+func (k *Key) unwrapSessionKey(body []byte) (byte, []byte, error) {
+	r := bytes.NewReader(body)
+	ephemeral, err := readMPI(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	l, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, errMalformed
+	}
+	wrapped := make([]byte, l)
+	if _, err := io.ReadFull(r, wrapped); err != nil {
+		return 0, nil, errMalformed
+	}
+
+	curve := k.Curve
+	if curve == CurveEd25519 {
+		curve = "curve25519"
+	}
+	str, msgType, err := k.client.Exchange(k.client.GetECDHSessionKey(k.uri(), 0, ephemeral, curve))
+	if err != nil {
+		return 0, nil, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_ECDHSessionKey {
+		return 0, nil, fmt.Errorf("unexpected response from device: %s", str)
+	}
+	shared := []byte(str)
+	if len(shared) < 33 {
+		return 0, nil, errors.New("invalid session key from device")
+	}
+	// x coordinate (NIST) or u coordinate (curve25519)
+	z := shared[1:33]
+
+	param := []byte{byte(len(k.subkey.oid))}
+	param = append(param, k.subkey.oid...)
+	param = append(param, algoECDH)
+	param = append(param, k.subkey.kdf...)
+	param = append(param, []byte("Anonymous Sender    ")...)
+	param = append(param, k.SubkeyFingerprint()...)
+
+	h := sha256.New()
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(z)
+	h.Write(param)
+	kek := h.Sum(nil)[:16]
+
+	m, err := AESKeyUnwrap(kek, wrapped)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// algorithm || key || checksum || PKCS5 padding
+	pad := int(m[len(m)-1])
+	if pad == 0 || pad > 8 || pad > len(m)-3 {
+		return 0, nil, errMalformed
+	}
+	m = m[:len(m)-pad]
+	key := m[1 : len(m)-2]
+	var sum uint16
+	for _, b := range key {
+		sum += uint16(b)
+	}
+	if sum != binary.BigEndian.Uint16(m[len(m)-2:]) {
+		return 0, nil, errors.New("session key checksum mismatch")
+	}
+	return m[0], key, nil
+}
+
+// AESKeyUnwrap implements RFC 3394.
+func AESKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, errMalformed
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	a := make([]byte, 8)
+	copy(a, wrapped[:8])
+	r := make([]byte, n*8)
+	copy(r, wrapped[8:])
+
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(b, binary.BigEndian.Uint64(a)^t)
+			copy(b[8:], r[(i-1)*8:i*8])
+			block.Decrypt(b, b)
+			copy(a, b[:8])
+			copy(r[(i-1)*8:i*8], b[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(a, []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}) != 1 {
+		return nil, errors.New("key unwrap failed")
+	}
+	return r, nil
+}
+
+// decryptSEIPD decrypts a symmetrically encrypted integrity protected data
+// packet and checks its modification detection code.
+func decryptSEIPD(symAlgo byte, key, body []byte) ([]byte, error) {
+	if len(body) < 1 || body[0] != 1 {
+		return nil, errors.New("unsupported encrypted data packet version")
+	}
+	switch symAlgo {
+	case cipherAES128, 8, cipherAES256:
+	default:
+		return nil, fmt.Errorf("unsupported symmetric algorithm %d", symAlgo)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := block.BlockSize()
+	ciphertext := body[1:]
+	if len(ciphertext) < bs+2+22 {
+		return nil, errMalformed
+	}
+	plain := make([]byte, len(ciphertext))
+	cipher.NewCFBDecrypter(block, make([]byte, bs)).XORKeyStream(plain, ciphertext)
+
+	if plain[bs-2] != plain[bs] || plain[bs-1] != plain[bs+1] {
+		return nil, ErrIntegrity
+	}
+	mdc := plain[len(plain)-22:]
+	if mdc[0] != 0xC0|tagMDC || mdc[1] != 20 {
+		return nil, ErrIntegrity
+	}
+	h := sha1.New()
+	h.Write(plain[:len(plain)-20])
+	if subtle.ConstantTimeCompare(h.Sum(nil), mdc[2:]) != 1 {
+		return nil, ErrIntegrity
+	}
+	return plain[bs+2 : len(plain)-22], nil
+}
+
+// literalData unpacks compressed and literal data packets.
+func literalData(data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		tag, body, err := ReadPacket(r)
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagCompressed:
+			if len(body) < 1 {
+				return nil, errMalformed
+			}
+			var dr io.Reader
+			switch body[0] {
+			case 0:
+				dr = bytes.NewReader(body[1:])
+			case 1:
+				dr = flate.NewReader(bytes.NewReader(body[1:]))
+			case 2:
+				if dr, err = zlib.NewReader(bytes.NewReader(body[1:])); err != nil {
+					return nil, err
+				}
+			case 3:
+				dr = bzip2.NewReader(bytes.NewReader(body[1:]))
+			default:
+				return nil, fmt.Errorf("unsupported compression algorithm %d", body[0])
+			}
+			inner, err := ioutil.ReadAll(dr)
+			if err != nil {
+				return nil, err
+			}
+			return literalData(inner)
+		case tagLiteral:
+			if len(body) < 2 || len(body) < 2+int(body[1])+4 {
+				return nil, errMalformed
+			}
+			return body[2+int(body[1])+4:], nil
+		}
+		// one-pass signatures, markers and signatures are skipped
+	}
+	return nil, errors.New("no literal data in message")
+}
@@ -0,0 +1,265 @@
+// Package gpg builds OpenPGP keys out of TREZOR identities. The primary key
+// signs through SignIdentity and the encryption subkey decrypts through
+// GetECDHSessionKey, so the device is the only holder of the secrets.
+package gpg
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+)
+
+const (
+	CurveNist256p1 = "nist256p1"
+	CurveEd25519   = "ed25519"
+)
+
+// Signature types (RFC 4880 section 5.2.1)
+const (
+	sigBinary        = 0x00
+	sigPositiveCert  = 0x13
+	sigSubkeyBinding = 0x18
+)
+
+// Key flags (RFC 4880 section 5.2.3.21)
+const (
+	flagCertify        = 0x01
+	flagSign           = 0x02
+	flagEncryptComms   = 0x04
+	flagEncryptStorage = 0x08
+)
+
+// Key is an OpenPGP key whose secret parts live on the device.
+type Key struct {
+	client  *tesoro.Client
+	UserID  string
+	Curve   string
+	Created time.Time
+
+	primary publicKey
+	subkey  publicKey
+}
+
+type publicKey struct {
+	algo  byte
+	oid   []byte
+	point []byte
+	// ECDH only: KDF hash and key wrap algorithm
+	kdf []byte
+}
+
+// NewKey reads the signing and ECDH public keys of the "gpg://"+userID
+// identity from the device. The creation time is part of the key
+// fingerprint, so the same time has to be used every time the key is built.
+func NewKey(client *tesoro.Client, userID, curve string, created time.Time) (*Key, error) {
+	k := &Key{client: client, UserID: userID, Curve: curve, Created: created.UTC().Truncate(time.Second)}
+
+	var ecdhCurve string
+	switch curve {
+	case CurveNist256p1:
+		k.primary.algo = algoECDSA
+		k.primary.oid = oidNist256p1
+		k.subkey.oid = oidNist256p1
+		ecdhCurve = CurveNist256p1
+	case CurveEd25519:
+		k.primary.algo = algoEdDSA
+		k.primary.oid = oidEd25519
+		k.subkey.oid = oidCurve25519
+		ecdhCurve = "curve25519"
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", curve)
+	}
+	k.subkey.algo = algoECDH
+	k.subkey.kdf = []byte{0x03, 0x01, hashSHA256, cipherAES128}
+
+	var err error
+	if k.primary.point, err = k.devicePublicKey(tesoro.IdentityPath(k.uri(), 0), curve); err != nil {
+		return nil, err
+	}
+	if k.subkey.point, err = k.devicePublicKey(tesoro.ECDHIdentityPath(k.uri(), 0), ecdhCurve); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *Key) uri() string {
+	return "gpg://" + k.UserID
+}
+
+// devicePublicKey returns the key as an OpenPGP point: uncompressed SEC1 for
+// NIST curves, 0x40 prefixed native form for 25519 curves.
+func (k *Key) devicePublicKey(path []uint32, curve string) ([]byte, error) {
+	str, msgType, err := k.client.Exchange(k.client.GetPublicKeyCurve(path, curve))
+	if err != nil {
+		return nil, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_PublicKey {
+		return nil, fmt.Errorf("unexpected response from device: %s", str)
+	}
+	var node messages.PublicKey
+	if err = json.Unmarshal([]byte(str), &node); err != nil {
+		return nil, err
+	}
+	raw := node.GetNode().GetPublicKey()
+	if len(raw) != 33 {
+		return nil, errors.New("invalid public key from device")
+	}
+
+	if curve == CurveNist256p1 {
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), raw)
+		if x == nil {
+			return nil, errors.New("invalid nist256p1 public key")
+		}
+		return elliptic.Marshal(elliptic.P256(), x, y), nil
+	}
+	return append([]byte{0x40}, raw[1:]...), nil
+}
+
+// body serializes the public key packet body (RFC 4880 section 5.5.2).
+func (pk *publicKey) body(created time.Time) []byte {
+	out := []byte{4}
+	out = append(out, uint32Bytes(uint32(created.Unix()))...)
+	out = append(out, pk.algo, byte(len(pk.oid)))
+	out = append(out, pk.oid...)
+	out = append(out, mpi(pk.point)...)
+	return append(out, pk.kdf...)
+}
+
+func (pk *publicKey) fingerprint(created time.Time) []byte {
+	h := sha1.New()
+	hashKey(h, pk.body(created))
+	return h.Sum(nil)
+}
+
+func hashKey(h hash.Hash, body []byte) {
+	h.Write([]byte{0x99})
+	h.Write(uint16Bytes(len(body)))
+	h.Write(body)
+}
+
+// Fingerprint of the primary key
+func (k *Key) Fingerprint() []byte {
+	return k.primary.fingerprint(k.Created)
+}
+
+// KeyID is the low 64 bits of the primary key fingerprint
+func (k *Key) KeyID() []byte {
+	return k.Fingerprint()[12:]
+}
+
+// SubkeyFingerprint of the ECDH encryption subkey
+func (k *Key) SubkeyFingerprint() []byte {
+	return k.subkey.fingerprint(k.Created)
+}
+
+// Export returns the public key block (primary key, user id, self
+// certification, encryption subkey and its binding signature). The device
+// is asked to confirm the two signatures.
+func (k *Key) Export(armor bool) ([]byte, error) {
+	primary := k.primary.body(k.Created)
+	subkey := k.subkey.body(k.Created)
+	uid := []byte(k.UserID)
+
+	h := sha256.New()
+	hashKey(h, primary)
+	h.Write([]byte{0xB4})
+	h.Write(uint32Bytes(uint32(len(uid))))
+	h.Write(uid)
+	cert, err := k.signature(sigPositiveCert, h, k.Created, subpacket(27, []byte{flagCertify | flagSign}),
+		subpacket(11, []byte{cipherAES256, cipherAES128}),
+		subpacket(21, []byte{hashSHA256}),
+		subpacket(22, []byte{2, 1, 0}),
+		subpacket(30, []byte{0x01}))
+	if err != nil {
+		return nil, err
+	}
+
+	h = sha256.New()
+	hashKey(h, primary)
+	hashKey(h, subkey)
+	binding, err := k.signature(sigSubkeyBinding, h, k.Created, subpacket(27, []byte{flagEncryptComms | flagEncryptStorage}))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(Packet(tagPublicKey, primary))
+	buf.Write(Packet(tagUserID, uid))
+	buf.Write(cert)
+	buf.Write(Packet(tagPublicSubkey, subkey))
+	buf.Write(binding)
+
+	if armor {
+		return Armor(ArmorPublicKey, buf.Bytes()), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// SignDetached returns a detached binary-document signature of data.
+func (k *Key) SignDetached(data io.Reader, armor bool) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, data); err != nil {
+		return nil, err
+	}
+	sig, err := k.signature(sigBinary, h, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if armor {
+		return Armor(ArmorSignature, sig), nil
+	}
+	return sig, nil
+}
+
+// signature finishes the v4 signature hash h over the hashed subpackets and
+// asks the device to sign the digest.
+func (k *Key) signature(sigType byte, h hash.Hash, created time.Time, extra ...[]byte) ([]byte, error) {
+	hashed := subpacket(2, uint32Bytes(uint32(created.Unix())))
+	hashed = append(hashed, subpacket(33, append([]byte{4}, k.Fingerprint()...))...)
+	for _, sp := range extra {
+		hashed = append(hashed, sp...)
+	}
+	unhashed := subpacket(16, k.KeyID())
+
+	trailer := []byte{4, sigType, k.primary.algo, hashSHA256}
+	trailer = append(trailer, uint16Bytes(len(hashed))...)
+	trailer = append(trailer, hashed...)
+	h.Write(trailer)
+	h.Write([]byte{4, 0xFF})
+	h.Write(uint32Bytes(uint32(len(trailer))))
+	digest := h.Sum(nil)
+
+	str, msgType, err := k.client.Exchange(k.client.SignIdentityCurve(k.uri(), digest, "", 0, k.Curve))
+	if err != nil {
+		return nil, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_SignedIdentity {
+		return nil, fmt.Errorf("unexpected response from device: %s", str)
+	}
+	var signed messages.SignedIdentity
+	if err = json.Unmarshal([]byte(str), &signed); err != nil {
+		return nil, err
+	}
+	sig := signed.GetSignature()
+	if len(sig) != 65 {
+		return nil, errors.New("invalid signature length")
+	}
+
+	body := append([]byte{}, trailer...)
+	body = append(body, uint16Bytes(len(unhashed))...)
+	body = append(body, unhashed...)
+	body = append(body, digest[0], digest[1])
+	body = append(body, mpi(sig[1:33])...)
+	body = append(body, mpi(sig[33:65])...)
+	return Packet(tagSignature, body), nil
+}
@@ -0,0 +1,264 @@
+package gpg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// OpenPGP packet tags (RFC 4880 section 4.3)
+const (
+	tagPKESK        = 1
+	tagSignature    = 2
+	tagCompressed   = 8
+	tagLiteral      = 11
+	tagPublicKey    = 6
+	tagUserID       = 13
+	tagPublicSubkey = 14
+	tagSEIPD        = 18
+	tagMDC          = 19
+)
+
+// Public key algorithms (RFC 6637, RFC 4880bis)
+const (
+	algoECDH  = 18
+	algoECDSA = 19
+	algoEdDSA = 22
+)
+
+const (
+	hashSHA256   = 8
+	cipherAES128 = 7
+	cipherAES256 = 9
+)
+
+var (
+	oidNist256p1  = []byte{0x2A, 0x86, 0x48, 0xCE, 0x3D, 0x03, 0x01, 0x07}
+	oidEd25519    = []byte{0x2B, 0x06, 0x01, 0x04, 0x01, 0xDA, 0x47, 0x0F, 0x01}
+	oidCurve25519 = []byte{0x2B, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01}
+)
+
+var errMalformed = errors.New("malformed OpenPGP data")
+
+// Packet serializes a packet with a new format header.
+func Packet(tag byte, body []byte) []byte {
+	out := []byte{0xC0 | tag}
+	l := len(body)
+	switch {
+	case l < 192:
+		out = append(out, byte(l))
+	case l < 8384:
+		l -= 192
+		out = append(out, byte(l>>8)+192, byte(l))
+	default:
+		out = append(out, 0xFF)
+		out = append(out, uint32Bytes(uint32(l))...)
+	}
+	return append(out, body...)
+}
+
+// ReadPacket reads the next packet, either old or new format, joining
+// partial body lengths. It returns io.EOF when there are no more packets.
+func ReadPacket(r *bytes.Reader) (byte, []byte, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if first&0x80 == 0 {
+		return 0, nil, errMalformed
+	}
+
+	if first&0x40 == 0 {
+		tag := (first >> 2) & 0x0F
+		var l int
+		switch first & 0x03 {
+		case 0:
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, errMalformed
+			}
+			l = int(b)
+		case 1:
+			var b [2]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return 0, nil, errMalformed
+			}
+			l = int(binary.BigEndian.Uint16(b[:]))
+		case 2:
+			var b [4]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return 0, nil, errMalformed
+			}
+			l = int(binary.BigEndian.Uint32(b[:]))
+		default:
+			l = r.Len()
+		}
+		body := make([]byte, l)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return 0, nil, errMalformed
+		}
+		return tag, body, nil
+	}
+
+	tag := first & 0x3F
+	var body []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, errMalformed
+		}
+		partial := false
+		var l int
+		switch {
+		case b < 192:
+			l = int(b)
+		case b < 224:
+			b2, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, errMalformed
+			}
+			l = (int(b)-192)<<8 + int(b2) + 192
+		case b == 255:
+			var lb [4]byte
+			if _, err := io.ReadFull(r, lb[:]); err != nil {
+				return 0, nil, errMalformed
+			}
+			l = int(binary.BigEndian.Uint32(lb[:]))
+		default:
+			l = 1 << (b & 0x1F)
+			partial = true
+		}
+		chunk := make([]byte, l)
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return 0, nil, errMalformed
+		}
+		body = append(body, chunk...)
+		if !partial {
+			return tag, body, nil
+		}
+	}
+}
+
+// mpi encodes an OpenPGP multiprecision integer.
+func mpi(b []byte) []byte {
+	n := new(big.Int).SetBytes(b)
+	out := []byte{byte(n.BitLen() >> 8), byte(n.BitLen())}
+	return append(out, n.Bytes()...)
+}
+
+// readMPI reads a multiprecision integer, returning its bytes.
+func readMPI(r *bytes.Reader) ([]byte, error) {
+	var lb [2]byte
+	if _, err := io.ReadFull(r, lb[:]); err != nil {
+		return nil, errMalformed
+	}
+	bits := int(binary.BigEndian.Uint16(lb[:]))
+	b := make([]byte, (bits+7)/8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errMalformed
+	}
+	return b, nil
+}
+
+// subpacket encodes a signature subpacket.
+func subpacket(typ byte, data []byte) []byte {
+	return append([]byte{byte(len(data) + 1), typ}, data...)
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func uint16Bytes(v int) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
+
+// Armor types
+const (
+	ArmorPublicKey = "PGP PUBLIC KEY BLOCK"
+	ArmorSignature = "PGP SIGNATURE"
+	ArmorMessage   = "PGP MESSAGE"
+)
+
+// Armor encodes data with ASCII armor (RFC 4880 section 6).
+func Armor(blockType string, data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("-----BEGIN " + blockType + "-----\n\n")
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 64 {
+		buf.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	buf.WriteString(encoded + "\n")
+	crc := crc24(data)
+	buf.WriteString("=" + base64.StdEncoding.EncodeToString([]byte{byte(crc >> 16), byte(crc >> 8), byte(crc)}) + "\n")
+	buf.WriteString("-----END " + blockType + "-----\n")
+	return buf.Bytes()
+}
+
+// Dearmor decodes ASCII armored data. Binary input is returned untouched.
+func Dearmor(data []byte) ([]byte, error) {
+	text := string(data)
+	start := strings.Index(text, "-----BEGIN ")
+	if start < 0 {
+		return data, nil
+	}
+	lines := strings.Split(strings.Replace(text[start:], "\r\n", "\n", -1), "\n")
+
+	var encoded, checksum string
+	inBody := false
+	for _, line := range lines[1:] {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-----END ") {
+			break
+		}
+		if !inBody {
+			// armor headers end with an empty line
+			if line == "" {
+				inBody = true
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "=") {
+			checksum = line[1:]
+			continue
+		}
+		encoded += line
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != "" {
+		crc, err := base64.StdEncoding.DecodeString(checksum)
+		if err != nil || len(crc) != 3 {
+			return nil, errMalformed
+		}
+		expected := crc24(decoded)
+		if crc[0] != byte(expected>>16) || crc[1] != byte(expected>>8) || crc[2] != byte(expected) {
+			return nil, errors.New("armor checksum mismatch")
+		}
+	}
+	return decoded, nil
+}
+
+func crc24(data []byte) uint32 {
+	crc := uint32(0xB704CE)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= 0x1864CFB
+			}
+		}
+	}
+	return crc & 0xFFFFFF
+}
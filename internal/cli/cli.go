@@ -0,0 +1,124 @@
+// Package cli has what the command line tools share: finding the device,
+// asking for the PIN and passphrase on the terminal and opening the vault.
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/conejoninja/hid"
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/tpm"
+	"github.com/conejoninja/tesoro/transport"
+)
+
+var ErrNoDevice = errors.New("no TREZOR devices found, make sure your device is connected")
+
+// Open returns a client for the first device connected, prompting on the
+// terminal
+func Open() (*tesoro.Client, error) {
+	clients, err := OpenAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range clients[1:] {
+		client.CloseTransport()
+	}
+	return clients[0], nil
+}
+
+// OpenAll returns a client for every device connected
+func OpenAll() ([]*tesoro.Client, error) {
+	var clients []*tesoro.Client
+	prompter := NewPrompter()
+	hid.UsbWalk(func(device hid.Device) {
+		info := device.Info()
+		if info.Vendor == transport.VendorOne && info.Product == transport.ProductOne && info.Interface == 0 {
+			var t transport.TransportHID
+			t.SetDevice(device)
+			client := &tesoro.Client{}
+			client.SetTransport(&t)
+			client.SetPrompter(prompter)
+			clients = append(clients, client)
+		}
+	})
+	if len(clients) == 0 {
+		return nil, ErrNoDevice
+	}
+	return clients, nil
+}
+
+// Prompter asks on the controlling terminal and writes to stderr, leaving
+// stdin and stdout to the protocol the tools speak
+type Prompter struct {
+	r *bufio.Reader
+}
+
+func NewPrompter() *Prompter {
+	in := os.Stdin
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		in = tty
+	}
+	return &Prompter{r: bufio.NewReader(in)}
+}
+
+func (p *Prompter) ReadLine(msg string) (string, error) {
+	fmt.Fprintln(os.Stderr, msg)
+	line, err := p.r.ReadString('\n')
+	return strings.TrimSpace(line), err
+}
+
+func (p *Prompter) PinMatrix(msg string) (string, error) {
+	return p.ReadLine(msg)
+}
+
+func (p *Prompter) Passphrase(msg string) (string, error) {
+	return p.ReadLine(msg)
+}
+
+func (p *Prompter) Word(msg string) (string, error) {
+	return p.ReadLine(msg)
+}
+
+func (p *Prompter) ButtonRequest(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+}
+
+// VaultEnv holds the default vault location
+const VaultEnv = "TESORO_VAULT"
+
+// Backend returns where the vault is stored: a directory, a WebDAV
+// collection URL with the credentials in it, or dropbox:<token>[/folder].
+// An empty location is taken from $TESORO_VAULT, or the current directory.
+func Backend(location string) (tpm.Backend, error) {
+	if location == "" {
+		location = os.Getenv(VaultEnv)
+	}
+	switch {
+	case location == "":
+		return tpm.NewLocal("."), nil
+	case strings.HasPrefix(location, "dropbox:"):
+		token := strings.TrimPrefix(location, "dropbox:")
+		folder := ""
+		if i := strings.Index(token, "/"); i >= 0 {
+			token, folder = token[:i], token[i+1:]
+		}
+		d := tpm.NewDropbox(token)
+		d.Folder = folder
+		return d, nil
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, err
+		}
+		user := u.User.Username()
+		password, _ := u.User.Password()
+		u.User = nil
+		return tpm.NewWebDAV(u.String(), user, password), nil
+	}
+	return tpm.NewLocal(location), nil
+}
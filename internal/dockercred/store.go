@@ -0,0 +1,166 @@
+// Package dockercred keeps the docker registry credentials of
+// docker-credential-tesoro, each secret encrypted by the device.
+package dockercred
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+)
+
+// SecretPath is the BIP32 path whose key encrypts the registry secrets,
+// used for nothing else
+const SecretPath = "m/10018'/0'"
+
+var ErrNotFound = errors.New("credentials not found in native keychain")
+
+// Store keeps the registry credentials, each secret encrypted by the
+// device with CipherKeyValue under its own IV
+type Store struct {
+	Version     int                   `json:"version"`
+	Path        string                `json:"path"`
+	Credentials map[string]Credential `json:"credentials"`
+
+	filename string
+}
+
+type Credential struct {
+	Username string `json:"username"`
+	IV       string `json:"iv"`
+	Secret   string `json:"secret"`
+}
+
+// Load reads the store kept in filename, a missing file is an empty store
+func Load(filename string) (*Store, error) {
+	s := &Store{Version: 1, Path: SecretPath, Credentials: map[string]Credential{}, filename: filename}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if s.Credentials == nil {
+		s.Credentials = map[string]Credential{}
+	}
+	return s, nil
+}
+
+// Save writes the file next to the old one and renames it over
+func (s *Store) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(s.filename), 0700); err != nil {
+		return err
+	}
+	tmp := s.filename + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.filename)
+}
+
+// cipherKey is shown on the device when a secret is decrypted, and has to
+// be the same to encrypt it
+func cipherKey(serverURL, username string) string {
+	return "Docker login to " + serverURL + " as " + username + "?"
+}
+
+// Put encrypts the secret on the device and keeps it for serverURL
+func (s *Store) Put(client *tesoro.Client, serverURL, username, secret string) error {
+	iv, err := tesoro.GenerateRandomBytes(16)
+	if err != nil {
+		return err
+	}
+	// the device takes whole 16 bytes blocks and the client fills the last
+	// one with zeros, which unpad could not tell from the secret. PKCS#7
+	// always adds 1 to 16 bytes that say their own length, a whole block
+	// when the secret is already a multiple of 16.
+	value := pad([]byte(secret))
+	ciphered, err := cipherKeyValue(client, true, cipherKey(serverURL, username), value, iv)
+	if err != nil {
+		return err
+	}
+	s.Credentials[serverURL] = Credential{Username: username, IV: hex.EncodeToString(iv), Secret: hex.EncodeToString(ciphered)}
+	return nil
+}
+
+// Get decrypts the secret, asking for confirmation on the device
+func (s *Store) Get(client *tesoro.Client, serverURL string) (string, string, error) {
+	cred, ok := s.Credentials[serverURL]
+	if !ok {
+		return "", "", ErrNotFound
+	}
+	iv, err := hex.DecodeString(cred.IV)
+	if err != nil {
+		return "", "", err
+	}
+	plain, err := cipherKeyValue(client, false, cipherKey(serverURL, cred.Username), []byte(cred.Secret), iv)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := unpad(plain)
+	if err != nil {
+		return "", "", err
+	}
+	return cred.Username, string(secret), nil
+}
+
+// Erase forgets the credentials of serverURL
+func (s *Store) Erase(serverURL string) error {
+	if _, ok := s.Credentials[serverURL]; !ok {
+		return ErrNotFound
+	}
+	delete(s.Credentials, serverURL)
+	return nil
+}
+
+// List returns the username of every server
+func (s *Store) List() map[string]string {
+	list := map[string]string{}
+	for serverURL, cred := range s.Credentials {
+		list[serverURL] = cred.Username
+	}
+	return list
+}
+
+func cipherKeyValue(client *tesoro.Client, encrypt bool, key string, value, iv []byte) ([]byte, error) {
+	str, msgType, err := client.Exchange(client.CipherKeyValue(encrypt, key, value, tesoro.StringToBIP32Path(SecretPath), iv, false, true))
+	if err != nil {
+		return nil, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_CipheredKeyValue {
+		return nil, fmt.Errorf("unexpected response from device: %s", str)
+	}
+	return []byte(str), nil
+}
+
+// pad appends PKCS#7 padding up to a multiple of 16 bytes
+func pad(data []byte) []byte {
+	n := 16 - len(data)%16
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%16 != 0 {
+		return nil, errors.New("invalid secret length")
+	}
+	n := int(data[len(data)-1])
+	if n == 0 || n > 16 || !bytes.Equal(data[len(data)-n:], bytes.Repeat([]byte{byte(n)}, n)) {
+		return nil, errors.New("invalid secret padding, wrong device or passphrase?")
+	}
+	return data[:len(data)-n], nil
+}
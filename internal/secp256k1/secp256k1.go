@@ -0,0 +1,39 @@
+// Package secp256k1 verifies ECDSA signatures on the secp256k1 curve, the
+// one the device and SatoshiLabs use for signatures. The standard library
+// only has the NIST curves, the curve arithmetic is the one of
+// github.com/decred/dcrd/dcrec/secp256k1. Nothing here signs.
+package secp256k1
+
+import (
+	"errors"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+var ErrInvalidKey = errors.New("invalid secp256k1 public key")
+
+// PublicKey is a point of the curve
+type PublicKey = secp256k1.PublicKey
+
+// ParsePublicKey parses a compressed (33 bytes) or uncompressed (65 bytes)
+// public key
+func ParsePublicKey(data []byte) (*PublicKey, error) {
+	key, err := secp256k1.ParsePubKey(data)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// Verify checks the 64 bytes r || s signature of a hash
+func Verify(key *PublicKey, hash, sig []byte) bool {
+	if key == nil || len(sig) != 64 {
+		return false
+	}
+	var r, s secp256k1.ModNScalar
+	if r.SetByteSlice(sig[:32]) || s.SetByteSlice(sig[32:]) || r.IsZero() || s.IsZero() {
+		return false
+	}
+	return ecdsa.NewSignature(&r, &s).Verify(hash, key)
+}
@@ -0,0 +1,190 @@
+// Package policy loads a signed config.Configuration and applies it: which
+// URLs may call, which devices may be used and until when.
+//
+// The signed blob is a 64 bytes secp256k1 signature, r || s, of the
+// SHA-256 of the serialized Configuration, followed by it. The signature
+// has to verify against one of the pinned public keys.
+package policy
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/conejoninja/tesoro/internal/secp256k1"
+	"github.com/conejoninja/tesoro/pb/config"
+	"github.com/conejoninja/tesoro/transport"
+	"github.com/golang/protobuf/proto"
+)
+
+const signatureSize = 64
+
+var (
+	ErrNoKeys     = errors.New("no pinned keys to verify the configuration")
+	ErrSignature  = errors.New("the configuration signature does not verify against the pinned keys")
+	ErrExpired    = errors.New("the configuration has expired")
+	ErrFormatBlob = errors.New("signed configuration too short")
+)
+
+// Policy is a verified configuration
+type Policy struct {
+	Config    *config.Configuration
+	whitelist []*regexp.Regexp
+	blacklist []*regexp.Regexp
+}
+
+// Load verifies the signed blob against the pinned keys, in compressed or
+// uncompressed form, and checks it is still valid at now
+func Load(blob []byte, keys [][]byte, now time.Time) (*Policy, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	if len(blob) <= signatureSize {
+		return nil, ErrFormatBlob
+	}
+	sig, data := blob[:signatureSize], blob[signatureSize:]
+	hash := sha256.Sum256(data)
+	verified := false
+	for _, k := range keys {
+		key, err := secp256k1.ParsePublicKey(k)
+		if err != nil {
+			return nil, err
+		}
+		if secp256k1.Verify(key, hash[:], sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	var conf config.Configuration
+	if err := proto.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	p, err := New(&conf)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.Check(now); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// LoadFile loads a signed configuration from a file
+func LoadFile(filename string, keys [][]byte, now time.Time) (*Policy, error) {
+	blob, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Load(blob, keys, now)
+}
+
+// New returns the policy of a configuration, without any signature
+func New(conf *config.Configuration) (*Policy, error) {
+	p := &Policy{Config: conf}
+	var err error
+	if p.whitelist, err = compile(conf.WhitelistUrls); err != nil {
+		return nil, err
+	}
+	if p.blacklist, err = compile(conf.BlacklistUrls); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// compile anchors the expressions, they have to match the whole URL
+func compile(exprs []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL expression %q: %v", expr, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// ValidUntil returns when the configuration expires, zero if it does not
+func (p *Policy) ValidUntil() time.Time {
+	if p.Config.ValidUntil == nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(p.Config.GetValidUntil()), 0)
+}
+
+// Check returns ErrExpired once the configuration is no longer valid
+func (p *Policy) Check(now time.Time) error {
+	if until := p.ValidUntil(); !until.IsZero() && now.After(until) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// AllowURL tells if a caller URL may use the devices: it must match a
+// whitelist expression and none of the blacklist
+func (p *Policy) AllowURL(url string) bool {
+	if p.Blocked(url) {
+		return false
+	}
+	for _, re := range p.whitelist {
+		if re.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocked tells if a URL matches the blacklist
+func (p *Policy) Blocked(url string) bool {
+	for _, re := range p.blacklist {
+		if re.MatchString(url) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowDevice tells if the device is one of the known devices. The fields
+// set in a descriptor have to match, the serial number is the device id
+// from its features and an empty id does not match one. Without known
+// devices any device is allowed.
+func (p *Policy) AllowDevice(device transport.Device, deviceID string) bool {
+	if len(p.Config.KnownDevices) == 0 {
+		return true
+	}
+	for _, known := range p.Config.KnownDevices {
+		if known.VendorId != nil && known.GetVendorId() != uint32(device.VendorID) {
+			continue
+		}
+		if known.ProductId != nil && known.GetProductId() != uint32(device.ProductID) {
+			continue
+		}
+		if known.Path != nil && known.GetPath() != device.Path {
+			continue
+		}
+		if known.SerialNumber != nil && !strings.EqualFold(known.GetSerialNumber(), deviceID) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// NeedsDeviceID tells if the known devices are told apart by their id,
+// which is only known after asking the device for its features
+func (p *Policy) NeedsDeviceID() bool {
+	for _, known := range p.Config.KnownDevices {
+		if known.SerialNumber != nil {
+			return true
+		}
+	}
+	return false
+}
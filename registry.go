@@ -0,0 +1,111 @@
+package tesoro
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/conejoninja/tesoro/codec"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/pb/types"
+	"github.com/golang/protobuf/proto"
+)
+
+var ErrUnknownMessage = errors.New("unknown message type")
+
+// MessageInfo describes a message of the wire protocol. In messages are
+// sent to the device, Out messages are sent by it.
+type MessageInfo struct {
+	Type messages.MessageType
+	Name string
+	In   bool
+	Out  bool
+	New  func() proto.Message
+}
+
+// registry has every message marked wire_in or wire_out in the MessageType
+// enum, built once from the compiled descriptors. The DebugLink messages
+// only travel on the debug link and are left out.
+var registry = buildRegistry()
+
+func buildRegistry() map[messages.MessageType]*MessageInfo {
+	set, err := codec.Descriptors()
+	if err != nil {
+		panic(err)
+	}
+	reg := map[messages.MessageType]*MessageInfo{}
+	for _, file := range set.GetFile() {
+		for _, enum := range file.GetEnumType() {
+			if enum.GetName() != "MessageType" {
+				continue
+			}
+			for _, value := range enum.GetValue() {
+				in := wireOption(value.GetOptions(), types.E_WireIn)
+				out := wireOption(value.GetOptions(), types.E_WireOut)
+				if !in && !out {
+					continue
+				}
+				name := strings.TrimPrefix(value.GetName(), "MessageType_")
+				t := proto.MessageType(name)
+				if t == nil {
+					panic(fmt.Sprintf("message %s is not compiled", name))
+				}
+				reg[messages.MessageType(value.GetNumber())] = &MessageInfo{
+					Type: messages.MessageType(value.GetNumber()),
+					Name: name,
+					In:   in,
+					Out:  out,
+					New:  constructor(t),
+				}
+			}
+		}
+	}
+	return reg
+}
+
+func wireOption(options proto.Message, ext *proto.ExtensionDesc) bool {
+	if options == nil || reflect.ValueOf(options).IsNil() || !proto.HasExtension(options, ext) {
+		return false
+	}
+	v, err := proto.GetExtension(options, ext)
+	if err != nil {
+		return false
+	}
+	b, ok := v.(*bool)
+	return ok && *b
+}
+
+// constructor returns a new message of t, a pointer to a message struct
+func constructor(t reflect.Type) func() proto.Message {
+	return func() proto.Message {
+		return reflect.New(t.Elem()).Interface().(proto.Message)
+	}
+}
+
+// Message returns the description of a message type
+func Message(msgType messages.MessageType) (*MessageInfo, bool) {
+	info, ok := registry[msgType]
+	return info, ok
+}
+
+// NewMessage returns an empty message of a type
+func NewMessage(msgType messages.MessageType) (proto.Message, error) {
+	info, ok := registry[msgType]
+	if !ok {
+		return nil, ErrUnknownMessage
+	}
+	return info.New(), nil
+}
+
+// Decode unmarshals a message into its concrete type
+func Decode(msgType uint16, marshalled []byte) (proto.Message, error) {
+	msg, err := NewMessage(messages.MessageType(msgType))
+	if err != nil {
+		return nil, err
+	}
+	if err = proto.Unmarshal(marshalled, msg); err != nil {
+		return nil, fmt.Errorf("unmarshalling %s: %v", registry[messages.MessageType(msgType)].Name, err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,50 @@
+package shell
+
+import (
+	"fmt"
+
+	"github.com/conejoninja/tesoro"
+)
+
+// coin resolves a coin by name or ticker, the one chosen with the coin
+// command when name is empty
+func (s *Shell) coin(name string) (*tesoro.Coin, error) {
+	if name == "" {
+		name = s.coinName
+	}
+	if name == "" {
+		name = tesoro.DefaultCoin
+	}
+	return s.client.Coin(name)
+}
+
+// accountPath is the first BIP44 account of the coin, the one of Bitcoin
+// for a coin without SLIP-44 index
+func accountPath(coin *tesoro.Coin) string {
+	path, err := coin.AccountPath(0)
+	if err != nil {
+		return "m/44'/0'/0'"
+	}
+	return tesoro.BIP32Path(path)
+}
+
+// setCoin prints the coin of getaddress, encryptmessage and getpublickey,
+// or chooses another by name or ticker:
+//
+//	coin ltc
+func (s *Shell) setCoin(args []string) {
+	if len(args) > 0 && args[0] != "" {
+		coin, err := s.coin(args[0])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		s.coinName = coin.Name
+	}
+	coin, err := s.coin("")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("%s (%s), default path %s\n", coin.Name, coin.Shortcut, accountPath(coin))
+}
@@ -0,0 +1,35 @@
+package shell
+
+import (
+	"fmt"
+
+	"github.com/conejoninja/tesoro/firmware"
+)
+
+// firmwareUpload installs a firmware on a device in bootloader mode,
+// warning when it is not signed by SatoshiLabs
+func (s *Shell) firmwareUpload(filename string) {
+	fw, err := readFile(filename)
+	if err != nil {
+		fmt.Println("Error reading firmware:", err)
+		return
+	}
+	f, err := firmware.Parse(fw)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err = f.Verify(nil); err != nil {
+		fmt.Println("Warning:", err)
+	}
+	fmt.Println("Fingerprint:", f.Fingerprint())
+	err = firmware.Update(s.client, f, func(written, total int) {
+		fmt.Printf("\rUploading %3d%%", written*100/total)
+	})
+	fmt.Println()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("Firmware installed")
+}
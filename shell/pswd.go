@@ -0,0 +1,677 @@
+package shell
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+func (s *Shell) openVault() (*tpm.Vault, error) {
+	if s.backend == nil {
+		s.backend = tpm.NewLocal(".")
+	}
+	return tpm.Open(s.client, s.backend)
+}
+
+// saveVault saves the vault, merged with the changes made meanwhile to the
+// file by someone else
+func saveVault(v *tpm.Vault) bool {
+	conflicts, err := v.Sync()
+	for _, c := range conflicts {
+		if c.Copy != "" {
+			fmt.Printf("Entry #%s was also changed by someone else, your version was saved as #%s\n", c.ID, c.Copy)
+		} else {
+			fmt.Printf("Entry #%s was deleted on one side and changed on the other, it was kept\n", c.ID)
+		}
+	}
+	if err == tpm.ErrConflict {
+		fmt.Println("The password file keeps being modified, nothing was saved. Try again.")
+		return false
+	}
+	if err != nil {
+		fmt.Println("Error saving password manager:", err)
+		return false
+	}
+	return true
+}
+
+// pswdBackend selects where the vault is stored:
+//
+//	pswdbackend local <dir>
+//	pswdbackend webdav <url> [user] [password]
+//	pswdbackend dropbox <token> [folder]
+func (s *Shell) pswdBackend(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Missing parameters")
+		return
+	}
+	switch strings.ToLower(args[0]) {
+	case "local":
+		s.backend = tpm.NewLocal(args[1])
+	case "webdav":
+		var user, password string
+		if len(args) >= 3 {
+			user = args[2]
+		}
+		if len(args) >= 4 {
+			password = args[3]
+		}
+		s.backend = tpm.NewWebDAV(args[1], user, password)
+	case "dropbox":
+		dropbox := tpm.NewDropbox(args[1])
+		if len(args) >= 3 {
+			dropbox.Folder = args[2]
+		}
+		s.backend = dropbox
+	default:
+		fmt.Println("Unknown backend, use local, webdav or dropbox")
+		return
+	}
+	fmt.Println("Password manager backend:", args[0])
+}
+
+// selectEntry prints the vault and reads the id of an entry
+func (s *Shell) selectEntry(v *tpm.Vault) (string, error) {
+	printRecords(v, v.List())
+	fmt.Println("")
+	fmt.Println("Select entry number to decrypt: ")
+	line, err := prompt.Readline()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func (s *Shell) pswdManager() {
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	id, err := s.selectEntry(v)
+	if err != nil {
+		fmt.Println("ERR", err)
+		return
+	}
+
+	item, err := v.Get(id)
+	if err == tpm.ErrNotFound {
+		fmt.Println("Selected entry does not exists")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error decrypting entry:", err)
+		return
+	}
+	fmt.Println("Password:", item.Password)
+	fmt.Println("Safe note:", item.SafeNote)
+}
+
+func (s *Shell) pswdExample() {
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+
+	rndByte, _ := tesoro.GenerateRandomBytes(3)
+	rnd := hex.EncodeToString(rndByte)
+	id, err := v.Add(tpm.Item{
+		Title:    "Some Service " + rnd,
+		Username: "MyUsername" + rnd,
+		Note:     "My normal note " + rnd,
+		Tags:     []int{1},
+		Password: "MySecretPassword" + rnd,
+		SafeNote: "My Safe Note is safe " + rnd,
+	})
+	if err != nil {
+		fmt.Println("Error adding entry:", err)
+		return
+	}
+	if saveVault(v) {
+		fmt.Printf("Added entry #%s\n", id)
+	}
+}
+
+func (s *Shell) pswdRemove() {
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	id, err := s.selectEntry(v)
+	if err != nil {
+		fmt.Println("ERR", err)
+		return
+	}
+
+	if err = v.Delete(id); err != nil {
+		fmt.Println("Selected entry does not exists")
+		return
+	}
+	if saveVault(v) {
+		fmt.Printf("Deleted entry #%s\n", id)
+	}
+}
+
+// pswdImport shows what would be imported from the file of another password
+// manager and, once confirmed, adds the entries:
+//
+//	pswdimport keepass|bitwarden|csv <file>
+func (s *Shell) pswdImport(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Missing parameters")
+		return
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		fmt.Println("Error reading file:", err)
+		return
+	}
+	accounts, err := tpm.Parse(tpm.Format(strings.ToLower(args[0])), f)
+	f.Close()
+	if err != nil {
+		fmt.Println("Error parsing file:", err)
+		return
+	}
+
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	preview := v.Preview(accounts)
+	fmt.Print(preview)
+	if len(preview.New) == 0 {
+		return
+	}
+	line, err := s.readLine(fmt.Sprintf("Import %d entries? Each one is confirmed on the device [y/N]", len(preview.New)))
+	if err != nil || strings.ToLower(strings.TrimSpace(line)) != "y" {
+		fmt.Println("Nothing imported")
+		return
+	}
+
+	ids, err := v.Import(preview)
+	if err != nil {
+		fmt.Println("Error importing:", err)
+		if len(ids) == 0 {
+			return
+		}
+	}
+	if saveVault(v) {
+		fmt.Printf("Imported %d entries\n", len(ids))
+	}
+}
+
+// pswdExport decrypts every entry and writes them for another password
+// manager:
+//
+//	pswdexport keepass|bitwarden|csv <file>
+func (s *Shell) pswdExport(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Missing parameters")
+		return
+	}
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	accounts, err := v.Export()
+	if err != nil {
+		fmt.Println("Error decrypting entries:", err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = tpm.Write(tpm.Format(strings.ToLower(args[0])), &buf, accounts); err != nil {
+		fmt.Println("Error exporting:", err)
+		return
+	}
+	if err = ioutil.WriteFile(args[1], buf.Bytes(), 0600); err != nil {
+		fmt.Println("Error writing file:", err)
+		return
+	}
+	fmt.Printf("Exported %d entries to %s. The file is NOT encrypted, delete it once imported.\n", len(accounts), args[1])
+}
+
+// entropy asks the device for n random bytes for the password generator
+func (s *Shell) entropy(n int) ([]byte, error) {
+	str, msgType, err := s.client.Exchange(s.client.GetEntropy(uint32(n)))
+	if err != nil {
+		return nil, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_Entropy {
+		return nil, fmt.Errorf("unexpected response from device: %s", str)
+	}
+	return hex.DecodeString(str)
+}
+
+func (s *Shell) passwordGenerator() *tpm.Generator {
+	if s.generator == nil {
+		s.generator = tpm.NewGenerator()
+		s.generator.Entropy = s.entropy
+	}
+	return s.generator
+}
+
+// pswdGen configures the password generator, or prints a password with no
+// parameters:
+//
+//	pswdgen chars <length> [lower,upper,digits,symbols]
+//	pswdgen words <count> <word list file> [separator]
+func (s *Shell) pswdGen(args []string) {
+	g := s.passwordGenerator()
+	if len(args) == 0 {
+		password, err := g.Generate()
+		if err != nil {
+			fmt.Println("Error generating password:", err)
+			return
+		}
+		fmt.Println(password)
+		return
+	}
+	if len(args) < 2 {
+		fmt.Println("Missing parameters")
+		return
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n <= 0 {
+		fmt.Println("Not valid length")
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "chars":
+		classes := "lower,upper,digits"
+		if len(args) >= 3 {
+			classes = strings.ToLower(args[2])
+		}
+		g.Length = n
+		g.Words = 0
+		g.Lower = strings.Contains(classes, "lower")
+		g.Upper = strings.Contains(classes, "upper")
+		g.Digits = strings.Contains(classes, "digit")
+		g.Symbols = strings.Contains(classes, "symbol")
+		fmt.Printf("Passwords of %d characters (%s)\n", n, classes)
+	case "words":
+		if len(args) < 3 {
+			fmt.Println("Missing word list")
+			return
+		}
+		f, err := os.Open(args[2])
+		if err != nil {
+			fmt.Println("Error reading word list:", err)
+			return
+		}
+		words, err := tpm.ParseWordList(f)
+		f.Close()
+		if err != nil {
+			fmt.Println("Error reading word list:", err)
+			return
+		}
+		g.Words = n
+		g.WordList = words
+		if len(args) >= 4 {
+			g.Separator = args[3]
+		}
+		fmt.Printf("Passphrases of %d words from a list of %d\n", n, len(words))
+	default:
+		fmt.Println("Unknown generator, use chars or words")
+	}
+}
+
+// editItem prompts for every field of the item. Leaving a field empty
+// keeps its value, "-" clears the optional ones and "?" generates a new
+// password.
+func (s *Shell) editItem(v *tpm.Vault, item *tpm.Item) error {
+	field := func(name string, value *string, optional bool) error {
+		line, err := s.readLine(fmt.Sprintf("%s [%s]:", name, *value))
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "-" && optional:
+			*value = ""
+		case line != "":
+			*value = line
+		}
+		return nil
+	}
+
+	if err := field("Item/URL", &item.Title, false); err != nil {
+		return err
+	}
+	if err := field("Username", &item.Username, false); err != nil {
+		return err
+	}
+	if err := field("Title", &item.Note, true); err != nil {
+		return err
+	}
+
+	tags := tagNames(v, item.Tags)
+	if err := field("Tags, separated by commas", &tags, true); err != nil {
+		return err
+	}
+	item.Tags = []int{}
+	for _, name := range strings.Split(tags, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			item.Tags = append(item.Tags, v.AddTag(name))
+		}
+	}
+
+	msg := "Password, empty to generate one:"
+	if item.Password != "" {
+		msg = "Password, empty to keep it, ? to generate one:"
+	}
+	password, err := prompt.ReadPassword(msg)
+	if err != nil {
+		return err
+	}
+	switch {
+	case string(password) == "?" || (len(password) == 0 && item.Password == ""):
+		if item.Password, err = s.passwordGenerator().Generate(); err != nil {
+			return err
+		}
+		fmt.Println("Generated password:", item.Password)
+	case len(password) > 0:
+		item.Password = string(password)
+	}
+
+	safeNote := item.SafeNote
+	if err = field("Safe note", &safeNote, true); err != nil {
+		return err
+	}
+	item.SafeNote = safeNote
+	return nil
+}
+
+func tagNames(v *tpm.Vault, ids []int) string {
+	var names []string
+	for _, id := range ids {
+		if tag, ok := v.Storage.Tags[strconv.Itoa(id)]; ok {
+			names = append(names, tag.Title)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func (s *Shell) pswdAdd() {
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	var item tpm.Item
+	if err = s.editItem(v, &item); err != nil {
+		fmt.Println("ERR", err)
+		return
+	}
+	if item.Title == "" {
+		fmt.Println("An entry needs an item/url")
+		return
+	}
+
+	id, err := v.Add(item)
+	if err != nil {
+		fmt.Println("Error adding entry:", err)
+		return
+	}
+	if saveVault(v) {
+		fmt.Printf("Added entry #%s\n", id)
+	}
+}
+
+func (s *Shell) pswdEdit() {
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	id, err := s.selectEntry(v)
+	if err != nil {
+		fmt.Println("ERR", err)
+		return
+	}
+
+	item, err := v.Get(id)
+	if err == tpm.ErrNotFound {
+		fmt.Println("Selected entry does not exists")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error decrypting entry:", err)
+		return
+	}
+	if err = s.editItem(v, &item); err != nil {
+		fmt.Println("ERR", err)
+		return
+	}
+
+	if err = v.Update(id, item); err != nil {
+		fmt.Println("Error updating entry:", err)
+		return
+	}
+	if saveVault(v) {
+		fmt.Printf("Updated entry #%s\n", id)
+	}
+}
+
+// pswdSearch lists the entries matching a text, in a tag given as #name:
+//
+//	pswdsearch [text] [#tag]
+func (s *Shell) pswdSearch(args []string) {
+	var words []string
+	var tag string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "#") {
+			tag = arg[1:]
+		} else if arg != "" {
+			words = append(words, arg)
+		}
+	}
+
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	records, err := v.Search(strings.Join(words, " "), tag)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	printRecords(v, records)
+	fmt.Printf("%d entries found\n", len(records))
+}
+
+// pswdTag lists the tags or changes them:
+//
+//	pswdtag add <title>
+//	pswdtag rename <title> <new title>
+//	pswdtag delete <title>
+func (s *Shell) pswdTag(args []string) {
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	if len(args) == 0 {
+		tags, counts := v.Tags()
+		for i, tag := range tags {
+			fmt.Printf("%s (%d)\n", tag.Title, counts[i])
+		}
+		return
+	}
+	if len(args) < 2 {
+		fmt.Println("Missing parameters")
+		return
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "add":
+		title := strings.Join(args[1:], " ")
+		if _, ok := v.TagID(title); ok {
+			fmt.Println(tpm.ErrTagExists)
+			return
+		}
+		v.AddTag(title)
+	case "rename":
+		if len(args) < 3 {
+			fmt.Println("Missing parameters")
+			return
+		}
+		err = v.RenameTag(args[1], strings.Join(args[2:], " "))
+	case "delete":
+		err = v.DeleteTag(strings.Join(args[1:], " "))
+	default:
+		fmt.Println("Unknown tag command, use add, rename or delete")
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if saveVault(v) {
+		fmt.Println("Tags updated")
+	}
+}
+
+// pswdOrder sets how entries are listed: pswdorder date|title
+func (s *Shell) pswdOrder(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Missing parameters")
+		return
+	}
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	if err = v.SetOrder(strings.ToLower(args[0])); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if saveVault(v) {
+		fmt.Println("Entries ordered by", strings.ToLower(args[0]))
+	}
+}
+
+// pswdAudit reports weak, reused and breached passwords without printing
+// any of them:
+//
+//	pswdaudit [offline breach list file or directory]
+func (s *Shell) pswdAudit(args []string) {
+	opts := tpm.DefaultAuditOptions
+	if len(args) >= 1 && args[0] != "" {
+		breaches, err := tpm.OpenBreaches(args[0])
+		if err != nil {
+			fmt.Println("Error opening breach list:", err)
+			return
+		}
+		opts.Breaches = breaches
+	}
+
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	line, err := s.readLine(fmt.Sprintf("Audit %d entries? The device asks to confirm each one [y/N]", len(v.Storage.Entries)))
+	if err != nil || strings.ToLower(strings.TrimSpace(line)) != "y" {
+		return
+	}
+
+	findings, err := v.Audit(opts)
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+	if err != nil {
+		fmt.Println("Audit stopped:", err)
+		return
+	}
+	fmt.Printf("%d findings in %d entries\n", len(findings), len(v.Storage.Entries))
+}
+
+// pswdOTP prints the one-time code kept in the safe note of an entry
+func (s *Shell) pswdOTP() {
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	id, err := s.selectEntry(v)
+	if err != nil {
+		fmt.Println("ERR", err)
+		return
+	}
+
+	code, err := v.OTP(id, time.Now())
+	if err == tpm.ErrNotFound {
+		fmt.Println("Selected entry does not exists")
+		return
+	}
+	if err != nil {
+		fmt.Println("Error getting code:", err)
+		return
+	}
+	if !code.HOTP {
+		fmt.Printf("Code: %s (valid for %d seconds)\n", code.Code, int(code.Remaining.Seconds()))
+		return
+	}
+	// the counter is stored before the code is used
+	if saveVault(v) {
+		fmt.Printf("Code: %s (counter %d)\n", code.Code, code.Counter)
+	}
+}
+
+// pswdRestore lists the previous versions of the vault and brings one back
+func (s *Shell) pswdRestore() {
+	v, err := s.openVault()
+	if err != nil {
+		fmt.Println("Error opening password manager:", err)
+		return
+	}
+	versions, err := v.Versions()
+	if err != nil {
+		fmt.Println("Error reading previous versions:", err)
+		return
+	}
+	if len(versions) == 0 {
+		fmt.Println("There are no previous versions")
+		return
+	}
+	for _, version := range versions {
+		fmt.Printf("%d) %s, %d bytes\n", version.Slot, version.Modified.Format("2006-01-02 15:04:05"), version.Size)
+	}
+
+	line, err := s.readLine("Select version to restore:")
+	if err != nil {
+		fmt.Println("ERR", err)
+		return
+	}
+	slot, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		fmt.Println("Not valid version")
+		return
+	}
+	if err = v.Restore(slot); err != nil {
+		fmt.Println("Error restoring version:", err)
+		return
+	}
+	if err = v.Save(); err != nil {
+		fmt.Println("Error saving password manager:", err)
+		return
+	}
+	fmt.Printf("Restored version %d with %d entries\n", slot, len(v.Storage.Entries))
+}
@@ -0,0 +1,131 @@
+package shell
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/conejoninja/tesoro/codec"
+	"github.com/conejoninja/tesoro/policy"
+)
+
+func (s *Shell) messageCodec() (*codec.Codec, error) {
+	if s.codec == nil {
+		c, err := codec.Compiled()
+		if err != nil {
+			return nil, err
+		}
+		s.codec = c
+	}
+	return s.codec, nil
+}
+
+// raw sends any message written in JSON and prints the response, the
+// prompts are printed and not answered:
+//
+//	raw GetAddress {"address_n":[2147483692,2147483648,2147483648,0,0],"show_display":true}
+//	raw ButtonAck {}
+func (s *Shell) raw(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Missing parameters")
+		return
+	}
+	c, err := s.messageCodec()
+	if err != nil {
+		fmt.Println("Error loading the messages:", err)
+		return
+	}
+	msgType, ok := c.Type(args[0])
+	if !ok {
+		fmt.Println("Unknown message", args[0])
+		return
+	}
+	body := strings.Join(args[1:], " ")
+	if strings.TrimSpace(body) == "" {
+		body = "{}"
+	}
+	marshalled, err := c.Marshal(args[0], []byte(body))
+	if err != nil {
+		fmt.Println("Error encoding the message:", err)
+		return
+	}
+
+	marshalled, msgType = s.client.RawCall(s.client.Raw(msgType, marshalled))
+	name, ok := c.Name(msgType)
+	if !ok {
+		fmt.Println("Message type", msgType, hex.EncodeToString(marshalled))
+		return
+	}
+	js, err := c.Unmarshal(name, marshalled)
+	if err != nil {
+		fmt.Println(name, "Error decoding the message:", err)
+		return
+	}
+	fmt.Println(name, string(js))
+}
+
+// rawLoad replaces the messages known to raw with the ones of a
+// FileDescriptorSet, as written by protoc --descriptor_set_out, to use
+// messages of a newer firmware. With the public keys in hex it signs with,
+// the file is a signed configuration and its WireProtocol is used once
+// verified:
+//
+//	rawload messages.pb
+//	rawload signed.bin 04a1b2...
+func (s *Shell) rawLoad(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Missing parameters")
+		return
+	}
+	var c *codec.Codec
+	if len(args) > 1 {
+		keys := make([][]byte, len(args)-1)
+		for i, key := range args[1:] {
+			var err error
+			if keys[i], err = hex.DecodeString(key); err != nil {
+				fmt.Println("Invalid key", key)
+				return
+			}
+		}
+		p, err := policy.LoadFile(args[0], keys, time.Now())
+		if err != nil {
+			fmt.Println("Error loading the configuration:", err)
+			return
+		}
+		if c, err = codec.FromConfiguration(p.Config); err != nil {
+			fmt.Println("Error loading the messages:", err)
+			return
+		}
+	} else {
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			fmt.Println("Error reading file:", err)
+			return
+		}
+		if c, err = codec.Load(data); err != nil {
+			fmt.Println("Error loading the messages:", err)
+			return
+		}
+	}
+	s.codec = c
+	fmt.Println(len(c.Messages()), "messages loaded")
+}
+
+// trace prints every message exchanged with the device: trace on|off
+func (s *Shell) trace(args []string) {
+	if len(args) < 1 {
+		fmt.Println("Missing parameters")
+		return
+	}
+	switch strings.ToLower(args[0]) {
+	case "on":
+		s.client.SetTracer(slog.New(slog.NewTextHandler(prompt.Stderr(), &slog.HandlerOptions{Level: slog.LevelDebug})))
+	case "off":
+		s.client.SetTracer(nil)
+	default:
+		fmt.Println("Use trace on or trace off")
+	}
+}
@@ -2,24 +2,32 @@ package shell
 
 import (
 	"bufio"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chzyer/readline"
 	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/codec"
 	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/tpm"
+	"github.com/conejoninja/tesoro/u2f"
 )
 
 type Shell struct {
-	client *tesoro.Client
+	client    *tesoro.Client
+	backend   tpm.Backend
+	generator *tpm.Generator
+	codec     *codec.Codec
+	coinName  string
 }
 
 var prompt *readline.Instance
@@ -56,10 +64,32 @@ func (s *Shell) call(msg []byte) (string, uint16) {
 	return str, msgType
 }
 
+func (s *Shell) readLine(msg string) (string, error) {
+	fmt.Println(msg)
+	return prompt.Readline()
+}
+
+func (s *Shell) PinMatrix(msg string) (string, error) {
+	return s.readLine(msg)
+}
+
+func (s *Shell) Passphrase(msg string) (string, error) {
+	return s.readLine(msg)
+}
+
+func (s *Shell) Word(msg string) (string, error) {
+	return s.readLine(msg)
+}
+
+func (s *Shell) ButtonRequest(msg string) {
+	fmt.Println(msg)
+}
+
 func NewShell(client *tesoro.Client) {
 
 	var s Shell
 	s.client = client
+	client.SetPrompter(&s)
 
 	var str string
 	var msgType uint16
@@ -128,9 +158,18 @@ func NewShell(client *tesoro.Client) {
 		case "getaddress":
 			var path string
 			showDisplay := false
-			coinName := "Bitcoin"
+			coinName := ""
+			if len(args) >= 4 {
+				coinName = args[3]
+			}
+			coin, err := s.coin(coinName)
+			if err != nil {
+				fmt.Println(err)
+				str = ""
+				break
+			}
 			if len(args) < 2 {
-				path = "m/44'/0'/0'"
+				path = accountPath(coin)
 			} else {
 				path = args[1]
 			}
@@ -139,11 +178,8 @@ func NewShell(client *tesoro.Client) {
 					showDisplay = true
 				}
 			}
-			if len(args) >= 4 {
-				coinName = args[3]
-			}
 
-			str, msgType = s.call(s.client.GetAddress(tesoro.StringToBIP32Path(path), showDisplay, coinName))
+			str, msgType = s.call(s.client.GetAddress(tesoro.StringToBIP32Path(path), showDisplay, coin.Name))
 			break
 		case "ethgetaddress":
 			var path string
@@ -165,9 +201,18 @@ func NewShell(client *tesoro.Client) {
 			if len(args) < 3 {
 				fmt.Println("Missing parameters")
 			} else {
-				path := "m/44'/0'/0'"
 				displayOnly := false
-				coinName := "Bitcoin"
+				coinName := ""
+				if len(args) >= 6 {
+					coinName = args[5]
+				}
+				coin, err := s.coin(coinName)
+				if err != nil {
+					fmt.Println(err)
+					str = ""
+					break
+				}
+				path := accountPath(coin)
 				pubkey, errHex := hex.DecodeString(args[1])
 				if errHex == nil {
 					message := args[2]
@@ -179,10 +224,7 @@ func NewShell(client *tesoro.Client) {
 					if len(args) >= 5 {
 						path = args[4]
 					}
-					if len(args) >= 6 {
-						coinName = args[5]
-					}
-					str, msgType = s.call(s.client.EncryptMessage(string(pubkey), message, displayOnly, path, coinName))
+					str, msgType = s.call(s.client.EncryptMessage(string(pubkey), message, displayOnly, path, coin.Name))
 					var encrypted messages.EncryptedMessage
 					err := json.Unmarshal([]byte(str), &encrypted)
 					if err == nil {
@@ -354,6 +396,53 @@ func NewShell(client *tesoro.Client) {
 				}
 			}
 			break
+		case "u2fbackup":
+			if len(args) < 2 {
+				fmt.Println("Missing parameters")
+			} else {
+				str = u2fBackup(args[1])
+			}
+			break
+		case "u2fsync":
+			if len(args) < 2 {
+				fmt.Println("Missing parameters")
+			} else {
+				margin := 1000
+				if len(args) >= 3 {
+					margin, err = strconv.Atoi(args[2])
+				}
+				contentByte, errFile := readFile(args[1])
+				if errFile != nil {
+					fmt.Println("Error reading backup:", errFile)
+				} else if err != nil || margin < 0 {
+					fmt.Println("Not valid margin")
+				} else {
+					counter, errCounter := u2f.ParseCounterBackup(contentByte)
+					if errCounter != nil {
+						fmt.Println(errCounter)
+					} else if uint64(margin) > math.MaxUint32-uint64(counter) {
+						fmt.Println("Not valid margin, the counter would overflow")
+					} else {
+						fmt.Printf("Setting U2F counter to %d\n", counter+uint32(margin))
+						str, msgType = s.call(s.client.SetU2FCounter(counter + uint32(margin)))
+					}
+				}
+			}
+			break
+		case "u2fregister":
+			if len(args) < 2 {
+				fmt.Println("Missing parameters")
+			} else {
+				str = u2fRegister(args[1])
+			}
+			break
+		case "u2fauthenticate":
+			if len(args) < 3 {
+				fmt.Println("Missing parameters")
+			} else {
+				str = u2fAuthenticate(args[1], args[2])
+			}
+			break
 		case "getecdhsessionkey":
 		case "getecdh":
 			if len(args) < 4 {
@@ -371,51 +460,24 @@ func NewShell(client *tesoro.Client) {
 				}
 			}
 			break
-		case "fu":
-		case "firmwareupload":
+		case "fu", "firmwareupload":
 			if len(args) < 2 {
 				fmt.Println("Missing parameters")
 			} else {
-				fw, err := readFile(args[1])
-				if err != nil {
-					fmt.Println("Error reading firmware:", err)
-				} else {
-					if string(fw[:4]) != "TRZR" {
-						fmt.Println("Not a TREZOR firmware")
-					} else {
-						var features messages.Features
-						str, msgType = s.call(s.client.Initialize())
-						if msgType != 17 {
-							fmt.Println("Error initializing the device")
-						} else {
-							err := json.Unmarshal([]byte(str), &features)
-							if err == nil {
-								if features.GetBootloaderMode() != true {
-									fmt.Println("Device must be in bootloader mode")
-								} else {
-									str, msgType = s.call(s.client.FirmwareErase())
-									if msgType != 2 {
-										fmt.Println("Error erasing previous firmware")
-									} else {
-										h := sha256.New()
-										h.Write([]byte(fw[256:]))
-										hash := h.Sum(nil)
-										fingerPrint := hex.EncodeToString(hash)
-										fmt.Println("Fingerprint:", fingerPrint)
-										str, msgType = s.call(s.client.FirmwareUpload(fw))
-									}
-								}
-							}
-						}
-					}
-
-				}
+				s.firmwareUpload(args[1])
 			}
+			str = ""
 			break
 		case "getpublickey":
 			var path string
+			coin, err := s.coin("")
+			if err != nil {
+				fmt.Println(err)
+				str = ""
+				break
+			}
 			if len(args) < 2 {
-				path = "m/44'/0'/0'"
+				path = accountPath(coin)
 			} else {
 				path = args[1]
 			}
@@ -426,8 +488,8 @@ func NewShell(client *tesoro.Client) {
 				str, msgType = s.call(s.client.GetPublicKey(tesoro.StringToBIP32Path(path)))
 				var node messages.PublicKey
 				err := json.Unmarshal([]byte(str), &node)
-				if err == nil {
-					str = node.GetXpub()
+				if err == nil && node.GetNode() != nil {
+					str = coin.Xpub(node.GetNode())
 				}
 			}
 			break
@@ -505,143 +567,81 @@ func NewShell(client *tesoro.Client) {
 				}
 			}
 			break
-		case "pswdmanager":
-		case "pm":
-			// GET MASTER KEY
-			str, msgType = s.call(s.client.GetMasterKey())
-			if msgType == 48 {
-				masterKey := hex.EncodeToString([]byte(str))
-				filename, _, encKey := tesoro.GetFileEncKey(masterKey)
-
-				// OPEN FILE
-				contentByte, err := readFile("./" + filename)
-				content := string(contentByte)
-				if err == nil {
-					// DECRYPT STORAGE
-					data, err := tesoro.DecryptStorage(content, encKey)
-					printStorage(data)
-
-					// Read entry to decrypt
-					line, err := rl.Readline()
-					if err != nil {
-						fmt.Println("ERR", err)
-						break
-					}
-					args = strings.Split(line, " ")
-					if _, ok := data.Entries[args[0]]; ok {
-						str, msgType = s.call(s.client.GetEntryNonce(data.Entries[args[0]].Title, data.Entries[args[0]].Username, data.Entries[args[0]].Nonce))
-						pswd, _ := tesoro.DecryptEntry(string(data.Entries[args[0]].Password.Data), str)
-						note, _ := tesoro.DecryptEntry(string(data.Entries[args[0]].SafeNote.Data), str)
-						if len(pswd) > 2 {
-							fmt.Println("Password:", pswd[1:len(pswd)-1])
-						} else {
-							fmt.Println("Password:")
-						}
-						if len(note) > 2 {
-							fmt.Println("Safe note:", note[1:len(note)-1])
-						} else {
-							fmt.Println("Safe note:")
-						}
-					} else {
-						fmt.Println("Selected entry does not exists")
-					}
-					str = ""
-				} else {
-					str = "Error opening file " + filename
-				}
-
-			}
+		case "pswdmanager", "pm":
+			s.pswdManager()
+			str = ""
 			break
-		case "pswdexample": // Insert random entry as an example
-		case "pe":
-			// GET MASTER KEY
-			str, msgType = s.call(s.client.GetMasterKey())
-			if msgType == 48 {
-				masterKey := hex.EncodeToString([]byte(str))
-				filename, _, encKey := tesoro.GetFileEncKey(masterKey)
-
-				// OPEN FILE
-				contentByte, err := readFile("./" + filename)
-				content := string(contentByte)
-				if err == nil {
-					// DECRYPT STORAGE
-					data, _ := tesoro.DecryptStorage(content, encKey)
-
-					var entry tesoro.Entry
-
-					rndByte, _ := tesoro.GenerateRandomBytes(3)
-					rnd := hex.EncodeToString(rndByte)
-
-					entry.Title = "Some Service " + rnd
-					entry.Username = "MyUsername" + rnd
-					entry.Note = "My normal note " + rnd
-					nonceByte, _ := tesoro.GenerateRandomBytes(32)
-					nonce := string(nonceByte)
-					entry.Tags = []int{1}
-					var eNonce string
-					eNonce, msgType = s.call(s.client.SetEntryNonce(entry.Title, entry.Username, nonce))
-					entry.Nonce = hex.EncodeToString([]byte(eNonce))
-					entry.Password = tesoro.EncryptedData{Type: "Buffer", Data: tesoro.EncryptEntry("\"MySecretPassword"+rnd+"\"", nonce)}
-					entry.SafeNote = tesoro.EncryptedData{Type: "Buffer", Data: tesoro.EncryptEntry("\"My Safe Note is safe "+rnd+"\"", nonce)}
-
-					max := 0
-					for k, _ := range data.Entries {
-						i, e := strconv.Atoi(k)
-						if e == nil && i > max {
-							max = i
-						}
-					}
-
-					lastEntry := strconv.Itoa(max + 1)
-
-					data.Entries[lastEntry] = entry
-					fmt.Printf("Added entry #%s\n", lastEntry)
-					efile := tesoro.EncryptStorage(data, encKey)
-					ioutil.WriteFile("./"+filename, efile, 0644)
-					str = ""
-				} else {
-					str = "Error opening file " + filename
-				}
-
-			}
+		case "pswdexample", "pe": // Insert random entry as an example
+			s.pswdExample()
+			str = ""
 			break
-		case "pswdremove": // Remove entry from the list
-		case "pr":
-			// GET MASTER KEY
-			str, msgType = s.call(s.client.GetMasterKey())
-			if msgType == 48 {
-				masterKey := hex.EncodeToString([]byte(str))
-				filename, _, encKey := tesoro.GetFileEncKey(masterKey)
-
-				// OPEN FILE
-				contentByte, err := readFile("./" + filename)
-				content := string(contentByte)
-				if err == nil {
-					// DECRYPT STORAGE
-					data, err := tesoro.DecryptStorage(content, encKey)
-					printStorage(data)
-
-					// Read entry to decrypt
-					line, err := rl.Readline()
-					if err != nil {
-						fmt.Println("ERR", err)
-						break
-					}
-					args = strings.Split(line, " ")
-					if _, ok := data.Entries[args[0]]; ok {
-						delete(data.Entries, args[0])
-						efile := tesoro.EncryptStorage(data, encKey)
-						ioutil.WriteFile("./"+filename, efile, 0644)
-						fmt.Printf("Deleted entry #%s\n", args[0])
-					} else {
-						fmt.Println("Selected entry does not exists")
-					}
-					str = ""
-				} else {
-					str = "Error opening file " + filename
-				}
-
-			}
+		case "pswdadd", "pa":
+			s.pswdAdd()
+			str = ""
+			break
+		case "pswdedit":
+			s.pswdEdit()
+			str = ""
+			break
+		case "pswdgen": // Configure the password generator, or print a password
+			s.pswdGen(args[1:])
+			str = ""
+			break
+		case "pswdsearch", "ps": // Search entries, optionally in a tag: ps [text] [#tag]
+			s.pswdSearch(args[1:])
+			str = ""
+			break
+		case "pswdtag":
+			s.pswdTag(args[1:])
+			str = ""
+			break
+		case "pswdorder": // List entries by date or title
+			s.pswdOrder(args[1:])
+			str = ""
+			break
+		case "pswdotp": // One-time code from the safe note of an entry
+			s.pswdOTP()
+			str = ""
+			break
+		case "pswdrestore": // Bring back a previous version of the vault
+			s.pswdRestore()
+			str = ""
+			break
+		case "pswdaudit": // Report weak, reused and breached passwords
+			s.pswdAudit(args[1:])
+			str = ""
+			break
+		case "pswdremove", "pr": // Remove entry from the list
+			s.pswdRemove()
+			str = ""
+			break
+		case "pswdbackend": // Where the vault file is stored
+			s.pswdBackend(args[1:])
+			str = ""
+			break
+		case "pswdimport": // From KeePass, Bitwarden or CSV
+			s.pswdImport(args[1:])
+			str = ""
+			break
+		case "pswdexport":
+			s.pswdExport(args[1:])
+			str = ""
+			break
+		case "raw": // Any message in JSON: raw <MessageName> <json>
+			s.raw(args[1:])
+			str = ""
+			break
+		case "rawload": // Messages of a newer firmware, from a FileDescriptorSet or a signed configuration
+			s.rawLoad(args[1:])
+			str = ""
+			break
+		case "trace": // Print the messages exchanged with the device: trace on|off
+			s.trace(args[1:])
+			str = ""
+			break
+		case "coin": // Coin of getaddress, encryptmessage and getpublickey: coin [name|ticker]
+			s.setCoin(args[1:])
+			str = ""
 			break
 		default:
 			fmt.Println("Unknown command")
@@ -655,33 +655,96 @@ func NewShell(client *tesoro.Client) {
 	}
 }
 
-func printStorage(s tesoro.Storage) {
+func printRecords(v *tpm.Vault, records []tpm.Record) {
 	fmt.Println("Password Entries")
 	fmt.Println("================")
 	fmt.Println("")
 
-	for id, e := range s.Entries {
-		printEntry(id, e)
+	for _, r := range records {
+		printEntry(v, r)
 	}
-
-	fmt.Println("")
-	fmt.Println("Select entry number to decrypt: ")
-
 }
 
-func printEntry(id string, e tesoro.Entry) {
-	fmt.Printf("Entry id: #%s\n", id)
-	for i := 0; i < (11 + len(id)); i++ {
+func printEntry(v *tpm.Vault, r tpm.Record) {
+	fmt.Printf("Entry id: #%s\n", r.ID)
+	for i := 0; i < (11 + len(r.ID)); i++ {
 		fmt.Print("-")
 	}
 	fmt.Println("")
-	fmt.Println("* title : ", e.Note)
-	fmt.Println("* item/url : ", e.Title)
-	fmt.Println("* username : ", e.Username)
-	fmt.Println("* tags : ", e.Tags)
+	fmt.Println("* title : ", r.Title())
+	fmt.Println("* item/url : ", r.URL())
+	fmt.Println("* username : ", r.Entry.Username)
+	fmt.Println("* tags : ", tagNames(v, r.Entry.Tags))
 	fmt.Println("")
 }
 
+func u2fBackup(filename string) string {
+	dev, err := u2f.Open()
+	if err != nil {
+		return err.Error()
+	}
+	defer dev.Close()
+
+	fmt.Println("Confirm twice on TREZOR device to read the U2F counter")
+	counter, err := dev.ReadCounter()
+	if err != nil {
+		return err.Error()
+	}
+	backup, _ := json.Marshal(u2f.CounterBackup{Counter: &counter, Date: time.Now()})
+	if err = ioutil.WriteFile(filename, backup, 0600); err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("U2F counter %d saved to %s", counter, filename)
+}
+
+func u2fRegister(appID string) string {
+	dev, err := u2f.Open()
+	if err != nil {
+		return err.Error()
+	}
+	defer dev.Close()
+
+	challenge, _ := tesoro.GenerateRandomBytes(32)
+	fmt.Println("Confirm action on TREZOR device")
+	reg, err := dev.Register(u2f.AppParam(appID), challenge)
+	if err != nil {
+		return err.Error()
+	}
+	attestation := "valid"
+	cert, err := reg.Verify()
+	if err != nil {
+		attestation = err.Error()
+	}
+	subject := ""
+	if cert != nil {
+		subject = cert.Subject.String()
+	}
+	fmt.Println("Public key:", hex.EncodeToString(reg.PublicKey))
+	fmt.Println("Key handle:", hex.EncodeToString(reg.KeyHandle))
+	fmt.Println("Attestation certificate:", subject)
+	return "Attestation signature: " + attestation
+}
+
+func u2fAuthenticate(appID, keyHandle string) string {
+	handle, err := hex.DecodeString(keyHandle)
+	if err != nil {
+		return "Key handle has to be hexadecimal"
+	}
+	dev, err := u2f.Open()
+	if err != nil {
+		return err.Error()
+	}
+	defer dev.Close()
+
+	challenge, _ := tesoro.GenerateRandomBytes(32)
+	fmt.Println("Confirm action on TREZOR device")
+	auth, err := dev.Authenticate(u2f.AppParam(appID), challenge, handle)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("Counter: %d\nSignature: %s", auth.Counter, hex.EncodeToString(auth.Signature))
+}
+
 func readFile(filename string) ([]byte, error) {
 	var empty []byte
 
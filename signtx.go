@@ -0,0 +1,108 @@
+package tesoro
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/pb/types"
+)
+
+// SignTransaction signs tx, answering the device's TxRequest messages
+// until it is finished. prev has the transactions spent by the inputs,
+// by their hash in hex, the device asks for them to check the amounts.
+// Nothing is sent when an output address is of another coin or the fee is
+// over the maximum of the coin. A coin neither the features nor the
+// fallback table know is sent as given, without those checks.
+// It returns the signature of every input and the serialized transaction.
+func (c *Client) SignTransaction(coinName string, tx types.TransactionType, prev map[string]types.TransactionType) ([][]byte, []byte, error) {
+	if coin, err := c.Coin(coinName); err == nil {
+		if err = coin.checkTransaction(tx, prev); err != nil {
+			return nil, nil, err
+		}
+	}
+	signatures := make([][]byte, len(tx.Inputs))
+	var serialized []byte
+
+	str, msgType, err := c.Exchange(c.SignTx(uint32(len(tx.Outputs)), uint32(len(tx.Inputs)), coinName, tx.GetVersion(), tx.GetLockTime()))
+	for {
+		if err != nil {
+			return nil, nil, err
+		}
+		if messages.MessageType(msgType) != messages.MessageType_MessageType_TxRequest {
+			return nil, nil, fmt.Errorf("unexpected response from device: %s", str)
+		}
+		var req messages.TxRequest
+		if err = json.Unmarshal([]byte(str), &req); err != nil {
+			return nil, nil, err
+		}
+		if s := req.GetSerialized(); s != nil {
+			if s.SignatureIndex != nil {
+				if int(s.GetSignatureIndex()) >= len(signatures) {
+					return nil, nil, errors.New("signature for an unknown input")
+				}
+				signatures[s.GetSignatureIndex()] = s.GetSignature()
+			}
+			serialized = append(serialized, s.GetSerializedTx()...)
+		}
+		if req.GetRequestType() == types.RequestType_TXFINISHED {
+			return signatures, serialized, nil
+		}
+
+		details := req.GetDetails()
+		current, isPrev := tx, false
+		if len(details.GetTxHash()) > 0 {
+			hash := hex.EncodeToString(details.GetTxHash())
+			var ok bool
+			if current, ok = prev[hash]; !ok {
+				return nil, nil, fmt.Errorf("the device asked for transaction %s, which was not given", hash)
+			}
+			isPrev = true
+		}
+		index := int(details.GetRequestIndex())
+
+		var ack types.TransactionType
+		switch req.GetRequestType() {
+		case types.RequestType_TXMETA:
+			ack.Version = current.Version
+			ack.LockTime = current.LockTime
+			inputs, outputs := uint32(len(current.Inputs)), uint32(len(current.Outputs))
+			if isPrev {
+				outputs = uint32(len(current.BinOutputs))
+			}
+			ack.InputsCnt, ack.OutputsCnt = &inputs, &outputs
+			if len(current.ExtraData) > 0 {
+				extra := uint32(len(current.ExtraData))
+				ack.ExtraDataLen = &extra
+			}
+		case types.RequestType_TXINPUT:
+			if index >= len(current.Inputs) {
+				return nil, nil, fmt.Errorf("the device asked for input %d of %d", index, len(current.Inputs))
+			}
+			ack.Inputs = []*types.TxInputType{current.Inputs[index]}
+		case types.RequestType_TXOUTPUT:
+			if isPrev {
+				if index >= len(current.BinOutputs) {
+					return nil, nil, fmt.Errorf("the device asked for output %d of %d", index, len(current.BinOutputs))
+				}
+				ack.BinOutputs = []*types.TxOutputBinType{current.BinOutputs[index]}
+			} else {
+				if index >= len(current.Outputs) {
+					return nil, nil, fmt.Errorf("the device asked for output %d of %d", index, len(current.Outputs))
+				}
+				ack.Outputs = []*types.TxOutputType{current.Outputs[index]}
+			}
+		case types.RequestType_TXEXTRADATA:
+			offset, length := int(details.GetExtraDataOffset()), int(details.GetExtraDataLen())
+			if offset+length > len(current.ExtraData) {
+				return nil, nil, errors.New("the device asked for extra data out of range")
+			}
+			ack.ExtraData = current.ExtraData[offset : offset+length]
+		default:
+			return nil, nil, fmt.Errorf("unknown request type %s", req.GetRequestType())
+		}
+		str, msgType, err = c.Exchange(c.TxAck(ack))
+	}
+}
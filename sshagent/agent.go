@@ -0,0 +1,321 @@
+// Package sshagent implements an ssh-agent whose keys live on the TREZOR
+// device. Public keys are derived from SLIP-0013 identities and every sign
+// request is answered by SignIdentity, so the private keys never leave the
+// device.
+package sshagent
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	CurveNist256p1 = "nist256p1"
+	CurveEd25519   = "ed25519"
+)
+
+var (
+	ErrNotSupported = errors.New("operation not supported by the device agent")
+	ErrUnknownKey   = errors.New("key not handled by this agent")
+	ErrLocked       = errors.New("agent is locked")
+)
+
+// Identity is an ssh:// URI the device will sign for, like
+// ssh://git@github.com, with the curve and index used to derive its key.
+type Identity struct {
+	URI   string
+	Curve string
+	Index uint32
+}
+
+type key struct {
+	identity Identity
+	pub      ssh.PublicKey
+}
+
+// Agent implements agent.Agent on top of a tesoro.Client.
+type Agent struct {
+	client     *tesoro.Client
+	identities []Identity
+	errorLog   *slog.Logger
+
+	mu         sync.Mutex
+	keys       []key
+	passphrase []byte
+	locked     bool
+}
+
+// New returns an agent for the given identities. The public keys are read
+// from the device the first time they are needed.
+func New(client *tesoro.Client, identities ...Identity) *Agent {
+	return &Agent{client: client, identities: identities}
+}
+
+// SetErrorLog logs the errors of the connections served, which are
+// otherwise dropped as they have no caller to return to. A nil logger
+// stops the log.
+func (a *Agent) SetErrorLog(logger *slog.Logger) {
+	a.errorLog = logger
+}
+
+// ParseIdentity reads an identity in the form "ssh://user@host[,curve[,index]]".
+// The curve defaults to nist256p1.
+func ParseIdentity(str string) (Identity, error) {
+	parts := strings.Split(str, ",")
+	identity := Identity{URI: parts[0], Curve: CurveNist256p1}
+	if !strings.HasPrefix(identity.URI, "ssh://") {
+		return identity, fmt.Errorf("not an ssh:// identity: %q", identity.URI)
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		identity.Curve = parts[1]
+	}
+	if identity.Curve != CurveNist256p1 && identity.Curve != CurveEd25519 {
+		return identity, fmt.Errorf("unsupported curve %q", identity.Curve)
+	}
+	if len(parts) > 2 {
+		if _, err := fmt.Sscan(parts[2], &identity.Index); err != nil {
+			return identity, fmt.Errorf("invalid index %q", parts[2])
+		}
+	}
+	return identity, nil
+}
+
+// ListenAndServe serves the agent protocol on a unix socket, the path that
+// should be exported as SSH_AUTH_SOCK.
+func (a *Agent) ListenAndServe(socket string) error {
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	return a.Serve(l)
+}
+
+// Serve serves the agent protocol on every connection accepted by l, until
+// Accept fails
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if err := agent.ServeAgent(a, conn); err != nil && err != io.EOF && a.errorLog != nil {
+				a.errorLog.Error("ssh-agent connection", "err", err)
+			}
+		}()
+	}
+}
+
+func (a *Agent) List() ([]*agent.Key, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.locked {
+		return []*agent.Key{}, nil
+	}
+	if err := a.loadKeys(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*agent.Key, len(a.keys))
+	for i, k := range a.keys {
+		keys[i] = &agent.Key{
+			Format:  k.pub.Type(),
+			Blob:    k.pub.Marshal(),
+			Comment: k.identity.URI,
+		}
+	}
+	return keys, nil
+}
+
+func (a *Agent) Sign(pub ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.locked {
+		return nil, ErrLocked
+	}
+	if err := a.loadKeys(); err != nil {
+		return nil, err
+	}
+
+	blob := pub.Marshal()
+	for _, k := range a.keys {
+		if string(k.pub.Marshal()) == string(blob) {
+			return a.sign(k, data)
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.locked {
+		return nil, ErrLocked
+	}
+	if err := a.loadKeys(); err != nil {
+		return nil, err
+	}
+
+	signers := make([]ssh.Signer, len(a.keys))
+	for i, k := range a.keys {
+		signers[i] = &signer{agent: a, key: k}
+	}
+	return signers, nil
+}
+
+func (a *Agent) Add(key agent.AddedKey) error {
+	return ErrNotSupported
+}
+
+func (a *Agent) Remove(key ssh.PublicKey) error {
+	return ErrNotSupported
+}
+
+func (a *Agent) RemoveAll() error {
+	return ErrNotSupported
+}
+
+func (a *Agent) Lock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.locked {
+		return ErrLocked
+	}
+	a.locked = true
+	a.passphrase = append([]byte{}, passphrase...)
+	return nil
+}
+
+func (a *Agent) Unlock(passphrase []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.locked || string(passphrase) != string(a.passphrase) {
+		return errors.New("incorrect passphrase")
+	}
+	a.locked = false
+	a.passphrase = nil
+	return nil
+}
+
+// loadKeys asks the device for the public key of every identity. It must be
+// called with a.mu held.
+func (a *Agent) loadKeys() error {
+	if len(a.keys) == len(a.identities) {
+		return nil
+	}
+
+	keys := make([]key, 0, len(a.identities))
+	for _, identity := range a.identities {
+		pub, err := a.publicKey(identity)
+		if err != nil {
+			return fmt.Errorf("%s: %v", identity.URI, err)
+		}
+		keys = append(keys, key{identity: identity, pub: pub})
+	}
+	a.keys = keys
+	return nil
+}
+
+func (a *Agent) publicKey(identity Identity) (ssh.PublicKey, error) {
+	path := tesoro.IdentityPath(identity.URI, identity.Index)
+	str, msgType, err := a.client.Exchange(a.client.GetPublicKeyCurve(path, identity.Curve))
+	if err != nil {
+		return nil, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_PublicKey {
+		return nil, fmt.Errorf("unexpected response from device: %s", str)
+	}
+
+	var node messages.PublicKey
+	if err = json.Unmarshal([]byte(str), &node); err != nil {
+		return nil, err
+	}
+	raw := node.GetNode().GetPublicKey()
+
+	switch identity.Curve {
+	case CurveEd25519:
+		if len(raw) != 33 {
+			return nil, errors.New("invalid ed25519 public key")
+		}
+		return ssh.NewPublicKey(ed25519.PublicKey(raw[1:]))
+	case CurveNist256p1:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), raw)
+		if x == nil {
+			return nil, errors.New("invalid nist256p1 public key")
+		}
+		return ssh.NewPublicKey(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y})
+	}
+	return nil, fmt.Errorf("unsupported curve %q", identity.Curve)
+}
+
+func (a *Agent) sign(k key, data []byte) (*ssh.Signature, error) {
+	msg := a.client.SignIdentityCurve(k.identity.URI, data, "", k.identity.Index, k.identity.Curve)
+	str, msgType, err := a.client.Exchange(msg)
+	if err != nil {
+		return nil, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_SignedIdentity {
+		return nil, fmt.Errorf("unexpected response from device: %s", str)
+	}
+
+	var signed messages.SignedIdentity
+	if err = json.Unmarshal([]byte(str), &signed); err != nil {
+		return nil, err
+	}
+	sig := signed.GetSignature()
+	if len(sig) != 65 {
+		return nil, errors.New("invalid signature length")
+	}
+
+	// The device prefixes the 64 byte signature with a recovery/format byte
+	if k.identity.Curve == CurveEd25519 {
+		return &ssh.Signature{Format: k.pub.Type(), Blob: sig[1:]}, nil
+	}
+
+	r := new(big.Int).SetBytes(sig[1:33])
+	s := new(big.Int).SetBytes(sig[33:])
+	return &ssh.Signature{
+		Format: k.pub.Type(),
+		Blob: ssh.Marshal(struct {
+			R *big.Int
+			S *big.Int
+		}{r, s}),
+	}, nil
+}
+
+type signer struct {
+	agent *Agent
+	key   key
+}
+
+func (s *signer) PublicKey() ssh.PublicKey {
+	return s.key.pub
+}
+
+func (s *signer) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.agent.mu.Lock()
+	defer s.agent.mu.Unlock()
+	return s.agent.sign(s.key, data)
+}
@@ -15,11 +15,10 @@ import (
 	"image"
 	_ "image/png"
 	"io"
-	"log"
+	"log/slog"
 	"math"
 	"net/url"
 	"os"
-	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -34,9 +33,34 @@ import (
 const hardkey uint32 = 2147483648
 
 type Client struct {
-	t transport.Transport
+	t      transport.Transport
+	p      Prompter
+	tracer *slog.Logger
+	// info is kept from the last features read
+	info *DeviceInfo
 }
 
+// Prompter answers the requests the device makes in the middle of a call
+// (PIN matrix, passphrase, recovery words and button confirmations).
+type Prompter interface {
+	PinMatrix(msg string) (string, error)
+	Passphrase(msg string) (string, error)
+	Word(msg string) (string, error)
+	ButtonRequest(msg string)
+}
+
+// FailureError is returned by Exchange when the device answers with a
+// Failure message.
+type FailureError struct {
+	Message string
+}
+
+func (e *FailureError) Error() string {
+	return "device failure: " + e.Message
+}
+
+var ErrNoPrompter = errors.New("device requested user input but no prompter is set")
+
 type Storage struct {
 	Version string           `json:"version"`
 	Config  Config           `json:"config"`
@@ -82,6 +106,10 @@ func (c *Client) CloseTransport() {
 	c.t.Close()
 }
 
+func (c *Client) SetPrompter(p Prompter) {
+	c.p = p
+}
+
 func (c *Client) Header(msgType messages.MessageType, msg []byte) []byte {
 
 	typebuf := make([]byte, 2)
@@ -215,6 +243,7 @@ func (c *Client) WordAck(str string) []byte {
 func (c *Client) GetAddress(addressN []uint32, showDisplay bool, coinName string) []byte {
 	var m messages.GetAddress
 	m.AddressN = addressN
+	coinName = c.coinName(coinName)
 	m.CoinName = &coinName
 	m.ShowDisplay = &showDisplay
 	marshalled, err := proto.Marshal(&m)
@@ -230,8 +259,15 @@ func (c *Client) GetAddress(addressN []uint32, showDisplay bool, coinName string
 }
 
 func (c *Client) GetPublicKey(address []uint32) []byte {
+	return c.GetPublicKeyCurve(address, "")
+}
+
+func (c *Client) GetPublicKeyCurve(address []uint32, ecdsaCurveName string) []byte {
 	var m messages.GetPublicKey
 	m.AddressN = address
+	if ecdsaCurveName != "" {
+		m.EcdsaCurveName = &ecdsaCurveName
+	}
 	marshalled, err := proto.Marshal(&m)
 
 	if err != nil {
@@ -245,8 +281,17 @@ func (c *Client) GetPublicKey(address []uint32) []byte {
 }
 
 func (c *Client) SignMessage(message []byte) []byte {
+	return c.SignMessagePath(nil, message, "")
+}
+
+func (c *Client) SignMessagePath(addressN []uint32, message []byte, coinName string) []byte {
 	var m messages.SignMessage
+	m.AddressN = addressN
 	m.Message = norm.NFC.Bytes(message)
+	if coinName != "" {
+		coinName = c.coinName(coinName)
+		m.CoinName = &coinName
+	}
 	marshalled, err := proto.Marshal(&m)
 
 	if err != nil {
@@ -260,12 +305,19 @@ func (c *Client) SignMessage(message []byte) []byte {
 }
 
 func (c *Client) SignIdentity(uri string, challengeHidden []byte, challengeVisual string, index uint32) []byte {
+	return c.SignIdentityCurve(uri, challengeHidden, challengeVisual, index, "")
+}
+
+func (c *Client) SignIdentityCurve(uri string, challengeHidden []byte, challengeVisual string, index uint32, ecdsaCurveName string) []byte {
 	var m messages.SignIdentity
 	identity := URIToIdentity(uri)
 	identity.Index = &index
 	m.Identity = &identity
 	m.ChallengeHidden = challengeHidden
 	m.ChallengeVisual = &challengeVisual
+	if ecdsaCurveName != "" {
+		m.EcdsaCurveName = &ecdsaCurveName
+	}
 	marshalled, err := proto.Marshal(&m)
 
 	if err != nil {
@@ -374,6 +426,7 @@ func (c *Client) EncryptMessage(pubkey, message string, displayOnly bool, path,
 	m.Message = []byte(message)
 	m.DisplayOnly = &displayOnly
 	m.AddressN = StringToBIP32Path(path)
+	coinName = c.coinName(coinName)
 	m.CoinName = &coinName
 	marshalled, err := proto.Marshal(&m)
 
@@ -470,6 +523,7 @@ func (c *Client) EstimateTxSize(outputsCount, inputsCount uint32, coinName strin
 	var m messages.EstimateTxSize
 	m.OutputsCount = &outputsCount
 	m.InputsCount = &inputsCount
+	coinName = c.coinName(coinName)
 	m.CoinName = &coinName
 	marshalled, err := proto.Marshal(&m)
 
@@ -534,6 +588,20 @@ func (c *Client) SetEntryNonce(title, username, nonce string) []byte {
 	)
 }
 
+func (c *Client) Cancel() []byte {
+	var m messages.Cancel
+	marshalled, err := proto.Marshal(&m)
+
+	if err != nil {
+		fmt.Println("ERROR Marshalling")
+	}
+
+	magicHeader := append([]byte{35, 35}, c.Header(messages.MessageType_MessageType_Cancel, marshalled)...)
+	msg := append(magicHeader, marshalled...)
+
+	return msg
+}
+
 func (c *Client) ClearSession() []byte {
 	var m messages.ClearSession
 	marshalled, err := proto.Marshal(&m)
@@ -615,6 +683,7 @@ func (c *Client) SignTx(outputsCount, inputsCount uint32, coinName string, versi
 	var m messages.SignTx
 	m.OutputsCount = &outputsCount
 	m.InputsCount = &inputsCount
+	coinName = c.coinName(coinName)
 	m.CoinName = &coinName
 	if version != 0 {
 		m.Version = &version
@@ -698,11 +767,145 @@ func (c *Client) EthereumGetAddress(addressN []uint32, showDisplay bool) []byte
 	return msg
 }
 
+// Call writes msg and returns the answer of the device. A message the
+// features read tell the device does not support is answered with a
+// Failure, the text of the *UnsupportedError, without sending it.
 func (c *Client) Call(msg []byte) (string, uint16) {
-	c.t.Write(msg)
+	if err := c.check(msg); err != nil {
+		return err.Error(), uint16(messages.MessageType_MessageType_Failure)
+	}
+	return c.call(msg)
+}
+
+// call is Call without the check of msg
+func (c *Client) call(msg []byte) (string, uint16) {
+	if err := c.write(msg); err != nil {
+		return writeFailure(err)
+	}
+	return c.ReadUntil()
+}
+
+// writeFailure is what Call returns when the message could not be written,
+// a Failure
+func writeFailure(err error) (string, uint16) {
+	return "Error writing: " + err.Error(), uint16(messages.MessageType_MessageType_Failure)
+}
+
+// CallProgress works like Call for long messages, such as a firmware. The
+// message is written a few reports at a time and progress is told the
+// bytes written after each of them, until a write fails. Only the type and
+// length of the message are traced.
+func (c *Client) CallProgress(msg []byte, progress func(written, total int)) (string, uint16) {
+	if err := c.check(msg); err != nil {
+		return err.Error(), uint16(messages.MessageType_MessageType_Failure)
+	}
+	if c.tracer != nil {
+		c.traceLength("out", msg)
+	}
+	step := 64 * transport.ReportPayload
+	for written := 0; written < len(msg); {
+		n := len(msg) - written
+		if n > step {
+			n = step
+		}
+		if err := c.writeTransport(msg[written : written+n]); err != nil {
+			return writeFailure(err)
+		}
+		written += n
+		if progress != nil {
+			progress(written, len(msg))
+		}
+	}
 	return c.ReadUntil()
 }
 
+// Exchange works like Call, but keeps answering PIN, passphrase, word and
+// button requests through the client's Prompter until the device sends a
+// final response. A Failure response is returned as a *FailureError, and
+// a message the features read tell the device does not support as an
+// *UnsupportedError, without sending it.
+func (c *Client) Exchange(msg []byte) (string, uint16, error) {
+	if err := c.check(msg); err != nil {
+		return "", 0, err
+	}
+	str, msgType := c.call(msg)
+	for {
+		var answer string
+		var err error
+		switch messages.MessageType(msgType) {
+		case messages.MessageType_MessageType_Failure:
+			return str, msgType, &FailureError{Message: str}
+		case messages.MessageType_MessageType_ButtonRequest:
+			if c.p != nil {
+				c.p.ButtonRequest(str)
+			}
+			str, msgType = c.Call(c.ButtonAck())
+			continue
+		case messages.MessageType_MessageType_PinMatrixRequest:
+			if c.p == nil {
+				return str, msgType, ErrNoPrompter
+			}
+			if answer, err = c.p.PinMatrix(str); err != nil {
+				return str, msgType, err
+			}
+			str, msgType = c.Call(c.PinMatrixAck(answer))
+			continue
+		case messages.MessageType_MessageType_PassphraseRequest:
+			if c.p == nil {
+				return str, msgType, ErrNoPrompter
+			}
+			if answer, err = c.p.Passphrase(str); err != nil {
+				return str, msgType, err
+			}
+			str, msgType = c.Call(c.PassphraseAck(answer))
+			continue
+		case messages.MessageType_MessageType_WordRequest:
+			if c.p == nil {
+				return str, msgType, ErrNoPrompter
+			}
+			if answer, err = c.p.Word(str); err != nil {
+				return str, msgType, err
+			}
+			str, msgType = c.Call(c.WordAck(answer))
+			continue
+		}
+		return str, msgType, nil
+	}
+}
+
+// Raw builds a message from its type and marshalled body, for the
+// messages without a builder here
+func (c *Client) Raw(msgType uint16, marshalled []byte) []byte {
+	magicHeader := append([]byte{35, 35}, c.Header(messages.MessageType(msgType), marshalled)...)
+	msg := append(magicHeader, marshalled...)
+
+	return msg
+}
+
+// RawCall works like Call, but returns the marshalled response as it
+// came. Prompts are not answered.
+func (c *Client) RawCall(msg []byte) ([]byte, uint16) {
+	if err := c.check(msg); err != nil {
+		return rawFailure(err.Error())
+	}
+	if err := c.write(msg); err != nil {
+		str, _ := writeFailure(err)
+		return rawFailure(str)
+	}
+	for {
+		marshalled, msgType, _, _ := c.read()
+		if msgType != 999 { //timeout
+			return marshalled, msgType
+		}
+	}
+}
+
+// rawFailure is a Failure as RawCall returns it
+func rawFailure(str string) ([]byte, uint16) {
+	marshalled, _ := proto.Marshal(&messages.Failure{Message: &str})
+	return marshalled, uint16(messages.MessageType_MessageType_Failure)
+}
+
 func (c *Client) ReadUntil() (string, uint16) {
 	var str string
 	var msgType uint16
@@ -717,208 +920,68 @@ func (c *Client) ReadUntil() (string, uint16) {
 }
 
 func (c *Client) Read() (string, uint16) {
-	marshalled, msgType, _, err := c.t.Read()
+	marshalled, msgType, _, err := c.read()
 	if err != nil {
 		return "Error reading", 999
 	}
 
-	str := "Uncaught message type " + strconv.Itoa(int(msgType))
-	switch messages.MessageType(msgType) {
-	case messages.MessageType_MessageType_Success:
-		var msg messages.Success
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (2)"
-		} else {
-			str = msg.GetMessage()
-		}
-		break
-	case messages.MessageType_MessageType_Failure:
-		var msg messages.Failure
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (3)"
-		} else {
-			str = msg.GetMessage()
-		}
-		break
-	case messages.MessageType_MessageType_Entropy:
-		var msg messages.Entropy
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (10)"
-		} else {
-			str = hex.EncodeToString(msg.GetEntropy())
-		}
-		break
-	case messages.MessageType_MessageType_PublicKey:
-		var msg messages.PublicKey
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (12)"
-		} else {
-			smJSON, _ := json.Marshal(&msg)
-			str = string(smJSON)
-		}
-		break
-	case messages.MessageType_MessageType_Features:
-		var msg messages.Features
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (17)"
-		} else {
-			ftsJSON, _ := json.Marshal(&msg)
-			str = string(ftsJSON)
-		}
-		break
-	case messages.MessageType_MessageType_PinMatrixRequest:
-		var msg messages.PinMatrixRequest
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (18)"
-		} else {
-			msgSubType := msg.GetType()
-			if msgSubType == 1 {
-				str = "Please enter current PIN:"
-			} else if msgSubType == 2 {
-				str = "Please enter new PIN:"
-			} else {
-				str = "Please re-enter new PIN:"
-			}
-		}
-		break
-	case messages.MessageType_MessageType_TxRequest:
-		var msg messages.TxRequest
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (21)"
-		} else {
-			var txreq TxRequest
-			txreq.Details = msg.GetDetails()
-			txreq.Type = msg.GetRequestType()
-			smJSON, _ := json.Marshal(&msg)
-			str = string(smJSON)
-		}
-		break
-	case messages.MessageType_MessageType_ButtonRequest:
-		var msg messages.ButtonRequest
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (26)"
-		} else {
-			str = "Confirm action on TREZOR device"
-		}
-		break
-	case messages.MessageType_MessageType_Address:
-		var msg messages.Address
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (30)"
-		} else {
-			str = msg.GetAddress()
-		}
-		break
-	case messages.MessageType_MessageType_EntropyRequest:
+	msg, err := Decode(msgType, marshalled)
+	if err == ErrUnknownMessage {
+		return "Uncaught message type " + strconv.Itoa(int(msgType)), msgType
+	}
+	if err != nil {
+		return "Error " + err.Error(), msgType
+	}
+	if features, ok := msg.(*messages.Features); ok {
+		c.info = NewDeviceInfo(features)
+	}
+	if _, ok := msg.(*messages.EntropyRequest); ok {
 		externalEntropy, _ := GenerateRandomBytes(32)
-		str, msgType = c.Call(c.EntropyAck(externalEntropy))
-		break
-	case messages.MessageType_MessageType_MessageSignature:
-		var msg messages.MessageSignature
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (40)"
-		} else {
-			smJSON, _ := json.Marshal(&msg)
-			str = string(smJSON)
-		}
-		break
-	case messages.MessageType_MessageType_PassphraseRequest:
-		var msg messages.PassphraseRequest
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (41)"
-		} else {
-			str = "Enter your passphrase"
-		}
-		break
-	case messages.MessageType_MessageType_TxSize:
-		var msg messages.TxSize
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (44)"
-		} else {
-			str = strconv.Itoa(int(msg.GetTxSize()))
-		}
-		break
-	case messages.MessageType_MessageType_WordRequest:
-		var msg messages.WordRequest
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (46)"
-		} else {
-			str = "Enter the word"
-		}
-		break
-	case messages.MessageType_MessageType_CipheredKeyValue:
-		var msg messages.CipheredKeyValue
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (48)"
-		} else {
-			str = string(msg.GetValue())
-		}
-		break
-	case messages.MessageType_MessageType_EncryptedMessage:
-		var msg messages.EncryptedMessage
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (50)"
-		} else {
-			smJSON, _ := json.Marshal(&msg)
-			str = string(smJSON)
-		}
-		break
-	case messages.MessageType_MessageType_DecryptedMessage:
-		var msg messages.DecryptedMessage
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (52)"
-		} else {
-			str = string(msg.GetMessage())
-		}
-		break
-	case messages.MessageType_MessageType_SignedIdentity:
-		var msg messages.SignedIdentity
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (54)"
-		} else {
-			smJSON, _ := json.Marshal(&msg)
-			str = string(smJSON)
-		}
-		break
-	case messages.MessageType_MessageType_EthereumAddress:
-		var msg messages.EthereumAddress
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (57)"
-		} else {
-			str = hex.EncodeToString(msg.GetAddress())
+		return c.Call(c.EntropyAck(externalEntropy))
+	}
+	return MessageString(msg), msgType
+}
+
+// MessageString is the text Read returns for a message: the meaningful
+// field of the simple responses, a prompt for the requests and JSON for
+// the rest
+func MessageString(msg proto.Message) string {
+	switch msg := msg.(type) {
+	case *messages.Success:
+		return msg.GetMessage()
+	case *messages.Failure:
+		return msg.GetMessage()
+	case *messages.Entropy:
+		return hex.EncodeToString(msg.GetEntropy())
+	case *messages.PinMatrixRequest:
+		msgSubType := msg.GetType()
+		if msgSubType == 1 {
+			return "Please enter current PIN:"
+		} else if msgSubType == 2 {
+			return "Please enter new PIN:"
 		}
-		break
-	case messages.MessageType_MessageType_ECDHSessionKey:
-		var msg messages.ECDHSessionKey
-		err = proto.Unmarshal(marshalled, &msg)
-		if err != nil {
-			str = "Error unmarshalling (62)"
-		} else {
-			str = string(msg.SessionKey)
-		}
-		break
-	default:
-		break
-	}
-	return str, msgType
+		return "Please re-enter new PIN:"
+	case *messages.ButtonRequest:
+		return "Confirm action on TREZOR device"
+	case *messages.Address:
+		return msg.GetAddress()
+	case *messages.PassphraseRequest:
+		return "Enter your passphrase"
+	case *messages.TxSize:
+		return strconv.Itoa(int(msg.GetTxSize()))
+	case *messages.WordRequest:
+		return "Enter the word"
+	case *messages.CipheredKeyValue:
+		return string(msg.GetValue())
+	case *messages.DecryptedMessage:
+		return string(msg.GetMessage())
+	case *messages.EthereumAddress:
+		return hex.EncodeToString(msg.GetAddress())
+	case *messages.ECDHSessionKey:
+		return string(msg.GetSessionKey())
+	}
+	msgJSON, _ := json.Marshal(msg)
+	return string(msgJSON)
 }
 
 func BIP32Path(keys []uint32) string {
@@ -926,15 +989,52 @@ func BIP32Path(keys []uint32) string {
 	for _, key := range keys {
 		path += "/"
 		if key < hardkey {
-			path += string(key)
+			path += strconv.FormatUint(uint64(key), 10)
 		} else {
-
-			path += string(key-hardkey) + "'"
+			path += strconv.FormatUint(uint64(key-hardkey), 10) + "'"
 		}
 	}
 	return path
 }
 
+// IdentityPath derives the SLIP-0013 BIP32 path for an identity URI and index,
+// the path the device uses internally for SignIdentity.
+func IdentityPath(uri string, index uint32) []uint32 {
+	return identityPath(13, uri, index)
+}
+
+// ECDHIdentityPath derives the path the device uses for GetECDHSessionKey
+// (SLIP-0017).
+func ECDHIdentityPath(uri string, index uint32) []uint32 {
+	return identityPath(17, uri, index)
+}
+
+func identityPath(purpose uint32, uri string, index uint32) []uint32 {
+	identity := URIToIdentity(uri)
+	canonical := ""
+	if identity.GetProto() != "" {
+		canonical = identity.GetProto() + "://"
+	}
+	if identity.GetUser() != "" {
+		canonical += identity.GetUser() + "@"
+	}
+	canonical += identity.GetHost()
+	if identity.GetPort() != "" {
+		canonical += ":" + identity.GetPort()
+	}
+	canonical += identity.GetPath()
+
+	indexBuf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(indexBuf, index)
+	hash := sha256.Sum256(append(indexBuf, []byte(canonical)...))
+
+	path := []uint32{hardened(purpose)}
+	for i := 0; i < 16; i += 4 {
+		path = append(path, hardened(binary.LittleEndian.Uint32(hash[i:i+4])&^hardkey))
+	}
+	return path
+}
+
 func StringToBIP32Path(str string) []uint32 {
 
 	if !ValidBIP32(str) {
@@ -1018,6 +1118,20 @@ func PNGToString(filename string) ([]byte, error) {
 
 func URIToIdentity(uri string) types.IdentityType {
 	var identity types.IdentityType
+
+	// GPG identities are user ids ("Name <email>"), not URLs
+	if strings.HasPrefix(uri, "gpg://") {
+		proto := "gpg"
+		host := uri[len("gpg://"):]
+		empty := ""
+		identity.Proto = &proto
+		identity.User = &empty
+		identity.Host = &host
+		identity.Port = &empty
+		identity.Path = &empty
+		return identity
+	}
+
 	u, err := url.Parse(uri)
 	if err != nil {
 		return identity
@@ -1107,21 +1221,31 @@ func GetFileEncKey(masterKey string) (string, string, string) {
 	return filename, fileKey, encKey
 }
 
+var (
+	ErrDecrypting = errors.New("Error decrypting")
+	ErrEncrypting = errors.New("Error encrypting")
+)
+
 func DecryptStorage(content, key string) (Storage, error) {
+	var pc Storage
+	if len(content) < 28 {
+		return pc, ErrDecrypting
+	}
 	cipherKey, _ := hex.DecodeString(key)
 	plainText, err := AES256GCMDecrypt([]byte(content[28:]+content[12:28]), cipherKey, []byte(content[:12]), []byte(content[12:28]))
 
 	if err != nil {
-		log.Panic("Error decrypting")
+		return pc, ErrDecrypting
 	}
 
-	var pc Storage
-	fmt.Println(string(plainText))
 	err = json.Unmarshal(plainText, &pc)
 	return pc, err
 }
 
 func DecryptEntry(content, key string) (string, error) {
+	if len(content) < 28 {
+		return "", ErrDecrypting
+	}
 	cipherKey := []byte(key)
 	value, err := AES256GCMDecrypt([]byte(content[28:]+content[12:28]), cipherKey, []byte(content[:12]), []byte(content[12:28]))
 	return string(value), err
@@ -1134,34 +1258,54 @@ func EncryptEntry(content, key string) []byte {
 	return []byte(string(nonce) + cipheredText[l-16:] + cipheredText[:l-16])
 }
 
-func EncryptStorage(s Storage, key string) []byte {
-	cipherKey, _ := hex.DecodeString(key)
+// EncryptStorage encrypts the storage with the hex encoded key, as
+// DecryptStorage reads it
+func EncryptStorage(s Storage, key string) ([]byte, error) {
+	cipherKey, err := hex.DecodeString(key)
+	if err != nil || len(cipherKey) != 32 {
+		return nil, ErrEncrypting
+	}
 	content, err := json.Marshal(s)
 	if err != nil {
-		log.Panic("Error encrypting")
+		return nil, fmt.Errorf("%w: %v", ErrEncrypting, err)
 	}
 
 	ciphered, nonce := AES256GCMMEncrypt(content, cipherKey)
 	cipheredText := string(ciphered)
 	l := len(ciphered)
-	return []byte(string(nonce) + cipheredText[l-16:] + cipheredText[:l-16])
+	return []byte(string(nonce) + cipheredText[l-16:] + cipheredText[:l-16]), nil
 }
 
-// TODO : Work on this
+// Equal reports whether both entries hold the same data. Empty and
+// missing values are the same, and tags are compared in any order.
 func (e *Entry) Equal(entry Entry) bool {
-	if e.Title == entry.Title &&
+	return e.Title == entry.Title &&
 		e.Username == entry.Username &&
 		e.Nonce == entry.Nonce &&
 		e.Note == entry.Note &&
-		reflect.DeepEqual(e.Password.Data, entry.Password.Data) &&
-		e.Password.Type == entry.Password.Type &&
-		reflect.DeepEqual(e.SafeNote.Data, entry.SafeNote.Data) &&
-		e.SafeNote.Type == entry.SafeNote.Type &&
-		reflect.DeepEqual(e.Tags, entry.Tags) {
-		return true
+		e.Password.Equal(entry.Password) &&
+		e.SafeNote.Equal(entry.SafeNote) &&
+		sameTags(e.Tags, entry.Tags)
+}
+
+func sameTags(a, b []int) bool {
+	count := map[int]int{}
+	for _, t := range a {
+		count[t]++
+	}
+	for _, t := range b {
+		count[t]--
 	}
+	for _, c := range count {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
 
-	return false
+func (e EncryptedData) Equal(data EncryptedData) bool {
+	return e.Type == data.Type && string(e.Data) == string(data.Data)
 }
 
 // TPM uses []int instead of []byte
@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/codec"
+	"github.com/conejoninja/tesoro/pb/config"
+	protobuf "github.com/conejoninja/tesoro/pb/google/protobuf"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/pb/types"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestCodec(t *testing.T) {
+	c, err := codec.Compiled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := c.Type("GetAddress"); !ok || n != uint16(messages.MessageType_MessageType_GetAddress) {
+		t.Errorf("GetAddress is type %d, %v", n, ok)
+	}
+	if name, ok := c.Name(uint16(messages.MessageType_MessageType_Features)); !ok || name != "Features" {
+		t.Errorf("Features type is %s, %v", name, ok)
+	}
+
+	features := &messages.Features{
+		Vendor:         proto.String("bitcointrezor.com"),
+		MajorVersion:   proto.Uint32(1),
+		BootloaderMode: proto.Bool(false),
+		Coins: []*types.CoinType{
+			{CoinName: proto.String("Bitcoin"), CoinShortcut: proto.String("BTC"), MaxfeeKb: proto.Uint64(2000000)},
+			{CoinName: proto.String("Testnet"), AddressType: proto.Uint32(111), Segwit: proto.Bool(true)},
+		},
+		BootloaderHash: []byte{0xde, 0xad, 0xbe, 0xef},
+	}
+	wire, err := proto.Marshal(features)
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := c.Unmarshal("Features", wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"vendor":"bitcointrezor.com","major_version":1,"bootloader_mode":false,"coins":[{"coin_name":"Bitcoin","coin_shortcut":"BTC","maxfee_kb":2000000},{"coin_name":"Testnet","address_type":111,"segwit":true}],"bootloader_hash":"deadbeef"}`
+	if string(js) != expected {
+		t.Errorf("Features decoded as %s", js)
+	}
+	back, err := c.Marshal("Features", js)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(back, wire) {
+		t.Errorf("Features encoded as %x, expected %x", back, wire)
+	}
+
+	// enums by name or number, repeated fields packed or not
+	data, err := c.Marshal("GetAddress", []byte(`{"address_n":[2147483692,0],"coin_name":"Testnet","script_type":"SPENDP2SHWITNESS"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var address messages.GetAddress
+	if err = proto.Unmarshal(data, &address); err != nil {
+		t.Fatal(err)
+	}
+	if len(address.AddressN) != 2 || address.AddressN[0] != 2147483692 || address.GetCoinName() != "Testnet" || address.GetScriptType() != types.InputScriptType_SPENDP2SHWITNESS {
+		t.Errorf("GetAddress decoded as %v", address)
+	}
+	if _, err = c.Marshal("GetAddress", []byte(`{"script_type":3}`)); err != nil {
+		t.Error(err)
+	}
+	if _, err = c.Marshal("GetAddress", []byte(`{"coin":"Bitcoin"}`)); err == nil {
+		t.Error("Unknown field encoded")
+	}
+	packed := []byte{0x0a, 0x02, 0x01, 0x02}
+	if js, err = c.Unmarshal("GetAddress", packed); err != nil || string(js) != `{"address_n":[1,2]}` {
+		t.Errorf("Packed address_n decoded as %s, %v", js, err)
+	}
+}
+
+// descriptorFile is a file of a package with the messages, nested ones
+// after a dot, and a MessageType enum when types are given
+func descriptorFile(pkg string, names []string, types ...string) *protobuf.FileDescriptorProto {
+	file := &protobuf.FileDescriptorProto{Package: proto.String(pkg)}
+	for _, name := range names {
+		if i := strings.Index(name, "."); i > 0 {
+			outer := &protobuf.DescriptorProto{Name: proto.String(name[:i])}
+			outer.NestedType = []*protobuf.DescriptorProto{{Name: proto.String(name[i+1:])}}
+			file.MessageType = append(file.MessageType, outer)
+			continue
+		}
+		file.MessageType = append(file.MessageType, &protobuf.DescriptorProto{Name: proto.String(name)})
+	}
+	if len(types) > 0 {
+		enum := &protobuf.EnumDescriptorProto{Name: proto.String("MessageType")}
+		for i, name := range types {
+			enum.Value = append(enum.Value, &protobuf.EnumValueDescriptorProto{Name: proto.String("MessageType_" + name), Number: proto.Int32(int32(i + 1))})
+		}
+		file.EnumType = []*protobuf.EnumDescriptorProto{enum}
+	}
+	return file
+}
+
+func TestCodecNames(t *testing.T) {
+	// Ping in two packages, the one of the enum is the message type; Note
+	// only nested elsewhere; Gone has no message
+	set := &protobuf.FileDescriptorSet{File: []*protobuf.FileDescriptorProto{
+		descriptorFile("hw.a", []string{"Ping", "Note"}, "Ping", "Note", "Pong", "Gone"),
+		descriptorFile("hw.b", []string{"Ping", "Pong", "Wrapper.Note"}),
+	}}
+	for i := 0; i < 20; i++ {
+		c, err := codec.New(set)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for msgType, expected := range map[uint16]string{1: "hw.a.Ping", 2: "hw.a.Note", 3: "hw.b.Pong"} {
+			if name, ok := c.Name(msgType); !ok || name != expected {
+				t.Fatalf("Type %d is %s, expected %s", msgType, name, expected)
+			}
+		}
+		if _, ok := c.Name(4); ok {
+			t.Error("Message without descriptor named")
+		}
+		if _, ok := c.Type("Ping"); ok {
+			t.Error("Ambiguous Ping has a type")
+		}
+		if n, ok := c.Type("hw.a.Ping"); !ok || n != 1 {
+			t.Errorf("hw.a.Ping is type %d, %v", n, ok)
+		}
+		if n, ok := c.Type("Note"); !ok || n != 2 {
+			t.Errorf("Note is type %d, %v", n, ok)
+		}
+		if _, err = c.Marshal("Ping", []byte(`{}`)); err == nil || !strings.Contains(err.Error(), "hw.a.Ping, hw.b.Ping") {
+			t.Errorf("Ambiguous Ping encoded, %v", err)
+		}
+	}
+
+	// the message of a type only in other packages, twice
+	set.File[0] = descriptorFile("hw.a", nil, "Ping")
+	set.File = append(set.File, descriptorFile("hw.d", []string{"Ping"}))
+	if _, err := codec.New(set); err == nil {
+		t.Error("Ambiguous message type loaded")
+	}
+	set.File = append(set.File, descriptorFile("hw.c", nil, "Ping"))
+	if _, err := codec.New(set); err == nil {
+		t.Error("Two MessageType enums loaded")
+	}
+
+	descriptors, err := codec.Descriptors()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := codec.FromConfiguration(&config.Configuration{WireProtocol: descriptors})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, ok := c.Type("GetAddress"); !ok || n != uint16(messages.MessageType_MessageType_GetAddress) {
+		t.Errorf("GetAddress of the configuration is type %d, %v", n, ok)
+	}
+	if _, err = codec.FromConfiguration(&config.Configuration{}); err == nil {
+		t.Error("Configuration without wire protocol loaded")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	for _, msgType := range []messages.MessageType{
+		messages.MessageType_MessageType_Features,
+		messages.MessageType_MessageType_EthereumTxRequest,
+		messages.MessageType_MessageType_NEMAddress,
+		messages.MessageType_MessageType_CosiCommitment,
+	} {
+		info, ok := tesoro.Message(msgType)
+		if !ok || !info.Out || info.In {
+			t.Errorf("%s registered as %v", msgType, info)
+		}
+	}
+	if _, ok := tesoro.Message(messages.MessageType_MessageType_DebugLinkState); ok {
+		t.Error("DebugLinkState registered")
+	}
+
+	wire, _ := proto.Marshal(&messages.NEMAddress{Address: proto.String("TALICE")})
+	msg, err := tesoro.Decode(uint16(messages.MessageType_MessageType_NEMAddress), wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address, ok := msg.(*messages.NEMAddress); !ok || address.GetAddress() != "TALICE" {
+		t.Errorf("NEMAddress decoded as %T %v", msg, msg)
+	}
+	if str := tesoro.MessageString(msg); str != `{"address":"TALICE"}` {
+		t.Errorf("NEMAddress read as %s", str)
+	}
+	if _, err = tesoro.Decode(1000, nil); err != tesoro.ErrUnknownMessage {
+		t.Errorf("Unknown type decoded with %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/pb/types"
+	"github.com/conejoninja/tesoro/tests/common"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestCoin(t *testing.T) {
+	bitcoin, err := tesoro.LookupCoin("btc")
+	if err != nil || bitcoin.Name != "Bitcoin" {
+		t.Fatalf("btc found as %v, %v", bitcoin, err)
+	}
+	litecoin, err := tesoro.LookupCoin("Litecoin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tesoro.LookupCoin("Nocoin"); !errors.Is(err, tesoro.ErrUnknownCoin) {
+		t.Errorf("Nocoin found, %v", err)
+	}
+
+	path, err := litecoin.Path(1, 0, 7)
+	if err != nil || tesoro.BIP32Path(path) != "m/44'/2'/1'/0/7" {
+		t.Errorf("Litecoin path %v, %v", path, err)
+	}
+
+	hash, _ := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+	address := bitcoin.Address(hash, false)
+	if address != "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH" {
+		t.Errorf("Address %s", address)
+	}
+	if decoded, p2sh, err := bitcoin.DecodeAddress(bitcoin.Address(hash, true)); err != nil || !p2sh || hex.EncodeToString(decoded) != hex.EncodeToString(hash) {
+		t.Errorf("P2SH address decoded as %x, %v, %v", decoded, p2sh, err)
+	}
+	if _, _, err = litecoin.DecodeAddress(address); err == nil {
+		t.Error("Bitcoin address decoded as Litecoin")
+	}
+
+	chainCode, _ := hex.DecodeString("873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508")
+	publicKey, _ := hex.DecodeString("0339a36013301597daef41fbe593a02cc513d0b55527ec2df1050e2e8ff49c85c2")
+	node := &types.HDNodeType{Depth: proto.Uint32(0), Fingerprint: proto.Uint32(0), ChildNum: proto.Uint32(0), ChainCode: chainCode, PublicKey: publicKey}
+	if xpub := bitcoin.Xpub(node); xpub != "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8" {
+		t.Errorf("Xpub %s", xpub)
+	}
+
+	// the coins of the features win over the fallback table
+	info := tesoro.NewDeviceInfo(&messages.Features{Coins: []*types.CoinType{
+		{CoinName: proto.String("Litecoin"), CoinShortcut: proto.String("LTC"), AddressType: proto.Uint32(48), MaxfeeKb: proto.Uint64(1000)},
+	}})
+	if coin, ok := info.Coin("ltc"); !ok || coin.MaxFeeKb != 1000 || coin.BIP44 != 2 || coin.XpubMagic != 0x0488b21e {
+		t.Errorf("ltc of the features read as %+v", coin)
+	}
+}
+
+func TestCoinSignTransaction(t *testing.T) {
+	transport := &common.Transport{}
+	var c tesoro.Client
+	c.SetTransport(transport)
+	hash, _ := hex.DecodeString("751e76e8199196d454941c45d1b3a323f1433bd6")
+	litecoin, _ := tesoro.LookupCoin("LTC")
+	prevHash, _ := hex.DecodeString("d5f65ee80147b4bcc70b75e4bbf2d7382021b871bd8867ef8fa525ef50864882")
+	prev := map[string]types.TransactionType{hex.EncodeToString(prevHash): {
+		BinOutputs: []*types.TxOutputBinType{{Amount: proto.Uint64(100000000), ScriptPubkey: []byte{0}}},
+	}}
+	tx := func(address string, amount uint64) types.TransactionType {
+		return types.TransactionType{
+			Inputs:  []*types.TxInputType{{AddressN: []uint32{0}, PrevHash: prevHash, PrevIndex: proto.Uint32(0)}},
+			Outputs: []*types.TxOutputType{{Address: proto.String(address), Amount: proto.Uint64(amount), ScriptType: types.OutputScriptType_PAYTOADDRESS.Enum()}},
+		}
+	}
+
+	if _, _, err := c.SignTransaction("btc", tx(litecoin.Address(hash, false), 99990000), prev); err == nil {
+		t.Error("Litecoin output signed on Bitcoin")
+	}
+	// 0.5 BTC of fee for 192 bytes
+	if _, _, err := c.SignTransaction("btc", tx("1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH", 50000000), prev); !errors.Is(err, tesoro.ErrFeeTooHigh) {
+		t.Errorf("High fee returned %v", err)
+	}
+	if _, _, err := c.SignTransaction("btc", tx("1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH", 100000001), prev); err == nil {
+		t.Error("Outputs over the inputs signed")
+	}
+	if len(transport.Sent) != 0 {
+		t.Error("Refused transactions were sent")
+	}
+
+	// a coin the client does not know goes to the device as given, without
+	// the checks
+	var coinName string
+	transport.Answer = func(msgType messages.MessageType, msg proto.Message) proto.Message {
+		if signTx, ok := msg.(*messages.SignTx); ok {
+			coinName = signTx.GetCoinName()
+		}
+		return &messages.TxRequest{RequestType: types.RequestType_TXFINISHED.Enum()}
+	}
+	if _, _, err := c.SignTransaction("Namecoin", tx("NAmecoinAddressTheClientCannotCheck", 100000000), prev); err != nil {
+		t.Errorf("Unknown coin returned %v", err)
+	}
+	if coinName != "Namecoin" {
+		t.Errorf("Unknown coin sent as %q", coinName)
+	}
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"crypto/sha256"
+
+	"github.com/conejoninja/tesoro/pb/config"
+	protobuf "github.com/conejoninja/tesoro/pb/google/protobuf"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"github.com/golang/protobuf/proto"
+)
+
+// Sign signs a hash with a secp256k1 private key, the nonce as in RFC 6979.
+// It returns r || s with a low s.
+func Sign(key, hash []byte) []byte {
+	sig := ecdsa.Sign(secp256k1.PrivKeyFromBytes(key), hash)
+	r, s := sig.R(), sig.S()
+	out := make([]byte, 64)
+	r.PutBytesUnchecked(out[:32])
+	s.PutBytesUnchecked(out[32:])
+	return out
+}
+
+// PublicKey is the uncompressed public key of a secp256k1 private key
+func PublicKey(key []byte) []byte {
+	return secp256k1.PrivKeyFromBytes(key).PubKey().SerializeUncompressed()
+}
+
+// SignConfig serializes a configuration and signs it as policy.Load
+// expects
+func SignConfig(conf *config.Configuration, key []byte) ([]byte, error) {
+	if conf.WireProtocol == nil {
+		// required by the message, even empty
+		conf.WireProtocol = &protobuf.FileDescriptorSet{}
+	}
+	data, err := proto.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(data)
+	return append(Sign(key, hash[:]), data...), nil
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/golang/protobuf/proto"
+)
+
+// Transport is a fake device. Initialize and GetFeatures are answered with
+// Features, any other message with what Answer returns or, when it is nil
+// or returns nil, Success "ok". A message written in several chunks is
+// answered once it is complete. With FailAfter the writes fail once that
+// many bytes were written.
+type Transport struct {
+	Features *messages.Features
+	Answer   func(msgType messages.MessageType, msg proto.Message) proto.Message
+
+	// Sent has the type of every message written, Written their bytes
+	Sent      []messages.MessageType
+	Written   int
+	FailAfter int
+
+	frame   []byte
+	answers []proto.Message
+}
+
+func (t *Transport) Write(msg []byte) {
+	t.WriteErr(msg)
+}
+
+func (t *Transport) WriteErr(msg []byte) error {
+	if t.FailAfter > 0 && t.Written+len(msg) > t.FailAfter {
+		return errors.New("device disconnected")
+	}
+	t.Written += len(msg)
+	t.frame = append(t.frame, msg...)
+	for len(t.frame) >= 8 {
+		if t.frame[0] != '#' || t.frame[1] != '#' {
+			t.frame = nil
+			return nil
+		}
+		length := int(binary.BigEndian.Uint32(t.frame[4:8]))
+		if len(t.frame) < 8+length {
+			return nil
+		}
+		msgType := binary.BigEndian.Uint16(t.frame[2:4])
+		body := t.frame[8 : 8+length]
+		t.frame = t.frame[8+length:]
+		t.Sent = append(t.Sent, messages.MessageType(msgType))
+		t.answers = append(t.answers, t.answer(messages.MessageType(msgType), body))
+	}
+	return nil
+}
+
+func (t *Transport) answer(msgType messages.MessageType, body []byte) proto.Message {
+	msg, err := tesoro.Decode(uint16(msgType), body)
+	if err != nil {
+		return &messages.Failure{Message: proto.String(err.Error())}
+	}
+	if t.Answer != nil {
+		if answer := t.Answer(msgType, msg); answer != nil {
+			return answer
+		}
+	}
+	switch msgType {
+	case messages.MessageType_MessageType_Initialize, messages.MessageType_MessageType_GetFeatures:
+		if t.Features == nil {
+			return &messages.Features{}
+		}
+		return t.Features
+	}
+	return &messages.Success{Message: proto.String("ok")}
+}
+
+// Read returns the next answer, or the timeout type 999 when there is none
+func (t *Transport) Read() ([]byte, uint16, int, error) {
+	if len(t.answers) == 0 {
+		return nil, 999, 0, nil
+	}
+	answer := t.answers[0]
+	t.answers = t.answers[1:]
+	marshalled, err := proto.Marshal(answer)
+	if err != nil {
+		return nil, 999, 0, err
+	}
+	msgType := messages.MessageType_value["MessageType_"+proto.MessageName(answer)]
+	return marshalled, uint16(msgType), len(marshalled), nil
+}
+
+func (t *Transport) Close() {}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/conejoninja/hid"
+)
+
+// U2FDevice is a fake U2F HID interface. It joins the packets written into
+// requests, answers INIT with the channel CID and any other command on
+// that channel with what Answer returns, split in packets as the device
+// does.
+type U2FDevice struct {
+	CID    uint32
+	Answer func(cmd byte, data []byte) []byte
+	// Delay is how many reads time out before each answer, Other sends a
+	// packet of another channel before it
+	Delay int
+	Other bool
+
+	// Packets has every packet written
+	Packets [][]byte
+
+	cid     uint32
+	cmd     byte
+	length  int
+	request []byte
+	reads   [][]byte
+}
+
+func (d *U2FDevice) Write(data []byte, ms time.Duration) (int, error) {
+	d.Packets = append(d.Packets, append([]byte{}, data...))
+	if len(data) < 7 {
+		return 0, errors.New("short packet")
+	}
+	if data[4]&0x80 != 0 {
+		d.cid, d.cmd = binary.BigEndian.Uint32(data), data[4]
+		d.length = int(binary.BigEndian.Uint16(data[5:7]))
+		d.request = append([]byte{}, data[7:]...)
+	} else {
+		d.request = append(d.request, data[5:]...)
+	}
+	if len(d.request) < d.length {
+		return len(data), nil
+	}
+	request := d.request[:d.length]
+	cid, answer := d.CID, []byte(nil)
+	if d.cmd == 0x86 {
+		cid = d.cid
+		answer = make([]byte, 17)
+		copy(answer, request)
+		binary.BigEndian.PutUint32(answer[8:], d.CID)
+		copy(answer[12:], []byte{2, 1, 6, 3, 0})
+	} else if d.Answer != nil {
+		answer = d.Answer(d.cmd, request)
+	}
+	for i := 0; i < d.Delay; i++ {
+		d.reads = append(d.reads, nil)
+	}
+	if d.Other {
+		d.reads = append(d.reads, U2FPackets(cid+1, d.cmd, []byte{0x6A, 0x80})...)
+	}
+	d.reads = append(d.reads, U2FPackets(cid, d.cmd, answer)...)
+	return len(data), nil
+}
+
+// U2FPackets frames a message in 64 bytes packets
+func U2FPackets(cid uint32, cmd byte, data []byte) [][]byte {
+	packet := make([]byte, 64)
+	binary.BigEndian.PutUint32(packet, cid)
+	packet[4] = cmd
+	binary.BigEndian.PutUint16(packet[5:], uint16(len(data)))
+	n := copy(packet[7:], data)
+	packets := [][]byte{packet}
+	for seq := byte(0); n < len(data); seq++ {
+		packet = make([]byte, 64)
+		binary.BigEndian.PutUint32(packet, cid)
+		packet[4] = seq
+		n += copy(packet[5:], data[n:])
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+// timeoutError is the error of a read with nothing to read, as the one of
+// the hid package
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+func (d *U2FDevice) Read(size int, ms time.Duration) ([]byte, error) {
+	if len(d.reads) == 0 {
+		time.Sleep(ms)
+		return nil, timeoutError{}
+	}
+	packet := d.reads[0]
+	d.reads = d.reads[1:]
+	if packet == nil {
+		return nil, timeoutError{}
+	}
+	return packet, nil
+}
+
+func (d *U2FDevice) Open() error                   { return nil }
+func (d *U2FDevice) Close()                        {}
+func (d *U2FDevice) Info() hid.Info                { return hid.Info{Interface: 1} }
+func (d *U2FDevice) HIDReport() ([]byte, error)    { return nil, nil }
+func (d *U2FDevice) SetReport(int, []byte) error   { return nil }
+func (d *U2FDevice) GetReport(int) ([]byte, error) { return nil, nil }
+func (d *U2FDevice) GetEndpoints() (int, int)      { return 0, 0 }
+func (d *U2FDevice) SetEndpoint(int)               {}
+func (d *U2FDevice) SetEpIn(int)                   {}
+func (d *U2FDevice) SetEpOut(int)                  {}
+func (d *U2FDevice) SetInfo(hid.Info)              {}
+func (d *U2FDevice) SetFD(uintptr)                 {}
+func (d *U2FDevice) SetPacketSize(uint16)          {}
+func (d *U2FDevice) SetInputPS(uint16)             {}
+func (d *U2FDevice) SetOutputPS(uint16)            {}
+func (d *U2FDevice) SetPath(string)                {}
+func (d *U2FDevice) Ctrl(rtype, req, val, index int, data []byte, t int) (int, error) {
+	return 0, nil
+}
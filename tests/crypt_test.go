@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/conejoninja/tesoro/crypt"
+)
+
+func encryptChunks(t *testing.T, key, plain []byte) []byte {
+	h, err := crypt.NewHeader(crypt.WrappedKey{Path: crypt.DefaultPath, Label: "Decrypt test?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w, err := crypt.NewWriter(&buf, key, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// odd sized writes across the chunk boundaries
+	for i := 0; i < len(plain); i += 1000 {
+		end := i + 1000
+		if end > len(plain) {
+			end = len(plain)
+		}
+		if _, err = w.Write(plain[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decryptChunks(key, data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+	h, err := crypt.ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := crypt.NewReader(r, key, h)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func TestCryptChunks(t *testing.T) {
+	key, _ := crypt.NewKey()
+	for _, size := range []int{0, 1, crypt.DefaultChunkSize, 2*crypt.DefaultChunkSize + 7} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		data := encryptChunks(t, key, plain)
+		if got, err := decryptChunks(key, data); err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("Round trip of %d bytes failed: %v", size, err)
+		}
+	}
+
+	plain := make([]byte, 2*crypt.DefaultChunkSize+7)
+	data := encryptChunks(t, key, plain)
+	header := len(data) - len(plain) - 3*16
+	chunk := crypt.DefaultChunkSize + 16
+
+	tampered := append([]byte{}, data...)
+	tampered[header+10] ^= 1
+	if _, err := decryptChunks(key, tampered); err != crypt.ErrTampered {
+		t.Errorf("Modified chunk returned %v", err)
+	}
+	// dropping the last chunk leaves a chunk not sealed as the last one
+	if _, err := decryptChunks(key, data[:header+2*chunk]); err != crypt.ErrTruncated {
+		t.Errorf("Truncated file returned %v", err)
+	}
+	swapped := append([]byte{}, data[:header]...)
+	swapped = append(swapped, data[header+chunk:header+2*chunk]...)
+	swapped = append(swapped, data[header:header+chunk]...)
+	swapped = append(swapped, data[header+2*chunk:]...)
+	if _, err := decryptChunks(key, swapped); err != crypt.ErrTampered {
+		t.Errorf("Reordered chunks returned %v", err)
+	}
+	other, _ := crypt.NewKey()
+	if _, err := decryptChunks(other, data); err != crypt.ErrTampered {
+		t.Errorf("Wrong key returned %v", err)
+	}
+	if _, err := decryptChunks(key, []byte("not encrypted")); err != crypt.ErrFormat {
+		t.Errorf("Plain file returned %v", err)
+	}
+}
+
+func TestCryptRecipients(t *testing.T) {
+	h, _ := crypt.NewHeader(crypt.WrappedKey{DeviceID: "A"}, crypt.WrappedKey{DeviceID: "B"})
+	h.AddRecipient(crypt.WrappedKey{DeviceID: "B", Label: "again"})
+	h.AddRecipient(crypt.WrappedKey{DeviceID: "C"})
+	if len(h.Keys) != 3 || h.Keys[1].Label != "again" {
+		t.Errorf("Unexpected recipients %+v", h.Keys)
+	}
+	if err := h.RemoveRecipient("A"); err != nil {
+		t.Error(err)
+	}
+	if err := h.RemoveRecipient("A"); err != crypt.ErrNoRecipient {
+		t.Errorf("Removing a missing recipient returned %v", err)
+	}
+
+	// the data does not change with the recipients
+	key, _ := crypt.NewKey()
+	var buf, rewrapped bytes.Buffer
+	w, _ := crypt.NewWriter(&buf, key, h)
+	w.Write([]byte("backup"))
+	w.Close()
+	h.RemoveRecipient("B")
+	if err := crypt.Rewrap(&rewrapped, bytes.NewReader(buf.Bytes()), h); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := decryptChunks(key, rewrapped.Bytes()); err != nil || string(got) != "backup" {
+		t.Errorf("Rewrapped file returned %q, %v", got, err)
+	}
+}
+
+func TestCryptExtractTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tesoro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"../evil", "a/../../evil", "/etc/evil"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: 1, Typeflag: tar.TypeReg})
+		tw.Write([]byte("x"))
+		tw.Close()
+		if _, err = crypt.ExtractTar(&buf, filepath.Join(dir, "out")); err == nil {
+			t.Errorf("%s was extracted", name)
+		}
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "link", Linkname: "../../etc/passwd", Typeflag: tar.TypeSymlink})
+	tw.Close()
+	if _, err = crypt.ExtractTar(&buf, dir); err == nil {
+		t.Error("Link outside of the directory was extracted")
+	}
+
+	// each link stays inside, chained they lead out of it
+	out := filepath.Join(dir, "a", "b", "out")
+	if err = os.MkdirAll(filepath.Join(out, "a"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	tw = tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "a/l1", Linkname: "..", Typeflag: tar.TypeSymlink})
+	tw.WriteHeader(&tar.Header{Name: "a/l1/l2", Linkname: "..", Typeflag: tar.TypeSymlink})
+	tw.WriteHeader(&tar.Header{Name: "a/l1/l2/x", Mode: 0600, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("x"))
+	tw.Close()
+	if _, err = crypt.ExtractTar(&buf, out); err == nil {
+		t.Error("File extracted through chained links")
+	}
+	if _, err = os.Lstat(filepath.Join(dir, "a", "b", "x")); err == nil {
+		t.Error("File written outside of the directory")
+	}
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/conejoninja/tesoro/daemon"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/tests/common"
+	"github.com/conejoninja/tesoro/transport"
+	"github.com/golang/protobuf/proto"
+)
+
+// emptyBus has no devices
+type emptyBus struct{}
+
+func (emptyBus) Enumerate() ([]transport.Device, error) {
+	return nil, nil
+}
+
+func (emptyBus) Connect(device transport.Device) (transport.Transport, error) {
+	return nil, transport.ErrDeviceNotFound
+}
+
+func TestDaemonGuard(t *testing.T) {
+	ts := httptest.NewServer(daemon.New(emptyBus{}, []string{"https://wallet.example"}).Handler())
+	defer ts.Close()
+
+	for _, c := range []struct {
+		origin, host string
+		status       int
+	}{
+		{"", "", http.StatusOK},
+		{"https://wallet.example", "", http.StatusOK},
+		{"https://evil.example", "", http.StatusForbidden},
+		{"", "evil.example", http.StatusForbidden},
+		{"", "localhost:1234", http.StatusOK},
+	} {
+		req, _ := http.NewRequest("GET", ts.URL+"/devices", nil)
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		if c.host != "" {
+			req.Host = c.host
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Errorf("Origin %q host %q returned %d, expected %d", c.origin, c.host, resp.StatusCode, c.status)
+		}
+	}
+
+	resp, err := http.Post(ts.URL+"/call/features", "application/json", strings.NewReader(`{"device":"hid:0"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Unknown device returned %d", resp.StatusCode)
+	}
+}
+
+// idBus numbers its devices hid:0, hid:1... in the order of ids, as the
+// HID bus does. Connect waits for connecting when it is not nil.
+type idBus struct {
+	mu         sync.Mutex
+	ids        []string
+	connecting chan struct{}
+}
+
+func (b *idBus) Enumerate() ([]transport.Device, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var found []transport.Device
+	for i := range b.ids {
+		found = append(found, transport.Device{Path: fmt.Sprintf("hid:%d", i)})
+	}
+	return found, nil
+}
+
+func (b *idBus) Connect(device transport.Device) (transport.Transport, error) {
+	if b.connecting != nil {
+		<-b.connecting
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, id := range b.ids {
+		if fmt.Sprintf("hid:%d", i) == device.Path {
+			return &common.Transport{Features: &messages.Features{DeviceId: proto.String(id)}}, nil
+		}
+	}
+	return nil, transport.ErrDeviceNotFound
+}
+
+func postFeatures(t *testing.T, url, path string) string {
+	resp, err := http.Post(url+"/call/features", "application/json", strings.NewReader(`{"device":"`+path+`"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestDaemonDevices(t *testing.T) {
+	bus := &idBus{ids: []string{"AAAA", "BBBB"}}
+	s := daemon.New(bus, nil)
+	defer s.Close()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	if body := postFeatures(t, ts.URL, "hid:0"); !strings.Contains(body, `"device_id":"AAAA"`) {
+		t.Errorf("hid:0 answered %s", body)
+	}
+	if body := postFeatures(t, ts.URL, "hid:1"); !strings.Contains(body, `"device_id":"BBBB"`) {
+		t.Errorf("hid:1 answered %s", body)
+	}
+	// the first device is unplugged, the second one is hid:0 now
+	bus.mu.Lock()
+	bus.ids = bus.ids[1:]
+	bus.mu.Unlock()
+	if body := postFeatures(t, ts.URL, "hid:0"); !strings.Contains(body, `"device_id":"BBBB"`) {
+		t.Errorf("hid:0 answered %s after the first device was unplugged", body)
+	}
+
+	// a device slow to connect holds up no other request
+	bus.connecting = make(chan struct{})
+	bus.mu.Lock()
+	bus.ids = append(bus.ids, "CCCC")
+	bus.mu.Unlock()
+	done := make(chan string)
+	go func() {
+		done <- postFeatures(t, ts.URL, "hid:1")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	listed := make(chan error)
+	go func() {
+		resp, err := http.Get(ts.URL + "/devices")
+		if err == nil {
+			resp.Body.Close()
+		}
+		listed <- err
+	}()
+	select {
+	case err := <-listed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Devices not listed while a device connects")
+	}
+	close(bus.connecting)
+	if body := <-done; !strings.Contains(body, `"device_id":"CCCC"`) {
+		t.Errorf("hid:1 answered %s", body)
+	}
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/pb/types"
+	"github.com/conejoninja/tesoro/tests/common"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestDeviceInfo(t *testing.T) {
+	features := &messages.Features{
+		MajorVersion: proto.Uint32(1),
+		MinorVersion: proto.Uint32(3),
+		PatchVersion: proto.Uint32(6),
+		DeviceId:     proto.String("ID"),
+		NeedsBackup:  proto.Bool(true),
+		Coins: []*types.CoinType{
+			{CoinName: proto.String("Bitcoin"), CoinShortcut: proto.String("BTC"), Segwit: proto.Bool(true)},
+			{CoinName: proto.String("Dogecoin"), CoinShortcut: proto.String("DOGE")},
+		},
+	}
+	info := tesoro.NewDeviceInfo(features)
+	if info.Model != tesoro.ModelOne || info.Version != (tesoro.Version{Major: 1, Minor: 3, Patch: 6}) || info.DeviceID != "ID" || !info.NeedsBackup() {
+		t.Errorf("Features read as %+v", info)
+	}
+	if coin, ok := info.Coin("doge"); !ok || coin.Name != "Dogecoin" {
+		t.Errorf("DOGE found as %v", coin)
+	}
+	if !info.SupportsSegwit("BTC") || info.SupportsSegwit("Dogecoin") || info.SupportsCoin("Litecoin") {
+		t.Error("Wrong coin capabilities")
+	}
+	if info.SupportsEthereum() {
+		t.Error("Ethereum supported by 1.3.6")
+	}
+	if !tesoro.NewDeviceInfo(&messages.Features{Model: proto.String("T")}).SupportsEthereum() {
+		t.Error("Ethereum not supported by the Model T")
+	}
+
+	transport := &common.Transport{Features: features}
+	var c tesoro.Client
+	c.SetTransport(transport)
+	// nothing is refused before the features are read
+	if _, _, err := c.Exchange(c.GetAddress([]uint32{0}, false, "Litecoin")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := c.DeviceInfo(); err != nil || info.DeviceID != "ID" {
+		t.Fatalf("DeviceInfo %v, %v", info, err)
+	}
+	sent := len(transport.Sent)
+	for _, msg := range [][]byte{
+		c.GetAddress([]uint32{0}, false, "Litecoin"),
+		c.EthereumGetAddress([]uint32{0}, false),
+		c.SignTx(1, 1, "Litecoin", 1, 0),
+	} {
+		_, _, err := c.Exchange(msg)
+		var unsupported *tesoro.UnsupportedError
+		if !errors.Is(err, tesoro.ErrUnsupported) || !errors.As(err, &unsupported) {
+			t.Errorf("Exchange returned %v", err)
+		}
+	}
+	// Call, used by the shell, refuses them with a Failure
+	if str, msgType := c.Call(c.EthereumGetAddress([]uint32{0}, false)); messages.MessageType(msgType) != messages.MessageType_MessageType_Failure || !strings.Contains(str, "unsupported") {
+		t.Errorf("Call returned %s, %d", str, msgType)
+	}
+	if _, msgType := c.RawCall(c.GetAddress([]uint32{0}, false, "Litecoin")); messages.MessageType(msgType) != messages.MessageType_MessageType_Failure {
+		t.Errorf("RawCall returned %d", msgType)
+	}
+	if len(transport.Sent) != sent {
+		t.Error("Unsupported messages were sent")
+	}
+	if _, _, err := c.Exchange(c.GetAddress([]uint32{0}, false, "doge")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"path/filepath"
+	"testing"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/internal/dockercred"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/tests/common"
+	"github.com/golang/protobuf/proto"
+)
+
+// cipherDevice answers CipherKeyValue with the value XORed with a stream
+// of its key and IV, so decrypting undoes encrypting, and like the device
+// refuses values that are not whole 16 bytes blocks
+type cipherDevice struct {
+	lengths []int
+}
+
+func (d *cipherDevice) answer(msgType messages.MessageType, msg proto.Message) proto.Message {
+	m, ok := msg.(*messages.CipherKeyValue)
+	if !ok {
+		return nil
+	}
+	d.lengths = append(d.lengths, len(m.Value))
+	if len(m.Value)%16 != 0 {
+		return &messages.Failure{Message: proto.String("Value length must be a multiple of 16")}
+	}
+	out := make([]byte, len(m.Value))
+	for i := range out {
+		if i%32 == 0 {
+			sum := sha256.Sum256(append(append([]byte(m.GetKey()), m.Iv...), byte(i/32)))
+			copy(out[i:], sum[:])
+		}
+		out[i] ^= m.Value[i]
+	}
+	return &messages.CipheredKeyValue{Value: out}
+}
+
+func TestDockerCredentials(t *testing.T) {
+	device := &cipherDevice{}
+	var c tesoro.Client
+	c.SetTransport(&common.Transport{Answer: device.answer})
+
+	filename := filepath.Join(t.TempDir(), "docker", "tesoro-credentials.json")
+	s, err := dockercred.Load(filename)
+	if err != nil || len(s.Credentials) != 0 {
+		t.Fatalf("Load of a missing file returned %v, %v", s, err)
+	}
+
+	secrets := []struct {
+		serverURL, secret string
+		length            int
+	}{
+		{"https://index.docker.io/v1/", "hunter2", 16},
+		// a whole block gets a whole block of padding
+		{"registry.example.com", "0123456789abcdef", 32},
+		// a trailing zero is kept, zero padding alone would lose it
+		{"ghcr.io", "ghp_0123456789abcdef0123456789ab\x00", 48},
+	}
+	for i, cred := range secrets {
+		if err = s.Put(&c, cred.serverURL, "me", cred.secret); err != nil {
+			t.Fatalf("Put %s: %v", cred.serverURL, err)
+		}
+		if device.lengths[i] != cred.length {
+			t.Errorf("Put %s sent %d bytes to the device", cred.serverURL, device.lengths[i])
+		}
+	}
+	if err = s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err = dockercred.Load(filename); err != nil {
+		t.Fatal(err)
+	}
+	for _, cred := range secrets {
+		username, secret, err := s.Get(&c, cred.serverURL)
+		if err != nil || username != "me" || secret != cred.secret {
+			t.Errorf("Get %s returned %q, %q, %v", cred.serverURL, username, secret, err)
+		}
+	}
+	if list := s.List(); len(list) != 3 || list["ghcr.io"] != "me" {
+		t.Errorf("List returned %v", list)
+	}
+
+	if err = s.Erase("ghcr.io"); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Erase("ghcr.io"); err != dockercred.ErrNotFound {
+		t.Errorf("Erase of a missing server returned %v", err)
+	}
+	if _, _, err = s.Get(&c, "ghcr.io"); err != dockercred.ErrNotFound {
+		t.Errorf("Get of an erased server returned %v", err)
+	}
+	if err = s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = dockercred.Load(filename); err != nil || len(s.List()) != 2 {
+		t.Errorf("Load after erase returned %v, %v", s.List(), err)
+	}
+}
@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/firmware"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/tests/common"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestFirmwareHeader(t *testing.T) {
+	data, err := common.ReadFile("firmware.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := firmware.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.SigIndex != [3]uint8{1, 2, 3} || int(f.CodeLen) != len(data)-firmware.HeaderSize {
+		t.Errorf("Header parsed as %v", f.Header)
+	}
+	if err = f.Verify(nil); err != nil {
+		t.Error(err)
+	}
+
+	tampered := append([]byte{}, data...)
+	tampered[len(tampered)-1] ^= 1
+	if f, err = firmware.Parse(tampered); err != nil || f.Verify(nil) != firmware.ErrSignature {
+		t.Error("Tampered firmware verifies")
+	}
+	reused := append([]byte{}, data...)
+	reused[9] = 1
+	if f, err = firmware.Parse(reused); err != nil || f.Verify(nil) != firmware.ErrSignature {
+		t.Error("Firmware with a key used twice verifies")
+	}
+	unsigned := append([]byte{}, data...)
+	copy(unsigned[8:11], []byte{0, 0, 0})
+	if f, err = firmware.Parse(unsigned); err != nil || f.Verify(nil) != firmware.ErrUnsigned {
+		t.Error("Unsigned firmware verifies")
+	}
+	short := append([]byte{}, data[:len(data)-1]...)
+	if _, err = firmware.Parse(short); err != firmware.ErrLength {
+		t.Errorf("Short firmware parsed with %v", err)
+	}
+	if _, err = firmware.Parse([]byte("MZ")); err != firmware.ErrMagic {
+		t.Errorf("Not a firmware parsed with %v", err)
+	}
+}
+
+func TestFirmwareVersion(t *testing.T) {
+	next, ok := firmware.VersionFromName("/tmp/trezor-1.6.1.bin")
+	if !ok || next != (firmware.Version{Major: 1, Minor: 6, Patch: 1}) {
+		t.Errorf("Version from name %v, %v", next, ok)
+	}
+	if v, err := firmware.ParseVersion("v1.10.0"); err != nil || !next.Less(v) {
+		t.Errorf("Version parsed as %v, %v", v, err)
+	}
+	if _, err := firmware.ParseVersion("1.6"); err == nil {
+		t.Error("Incomplete version parsed")
+	}
+	installed := &messages.Features{MajorVersion: proto.Uint32(1), MinorVersion: proto.Uint32(6), PatchVersion: proto.Uint32(3)}
+	if !firmware.Downgrade(tesoro.NewDeviceInfo(installed), next) {
+		t.Error("1.6.1 over 1.6.3 is not a downgrade")
+	}
+	installed.BootloaderMode = proto.Bool(true)
+	if firmware.Downgrade(tesoro.NewDeviceInfo(installed), next) {
+		t.Error("Bootloader version compared")
+	}
+}
+
+func TestFirmwareUpdate(t *testing.T) {
+	data, err := common.ReadFile("firmware.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := firmware.Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c tesoro.Client
+	c.SetTransport(&common.Transport{})
+	if err = firmware.Update(&c, f, nil); err != firmware.ErrNotBootloader {
+		t.Errorf("Update in normal mode returned %v", err)
+	}
+
+	var trace bytes.Buffer
+	c.SetTracer(slog.New(slog.NewJSONHandler(&trace, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	c.SetTransport(&common.Transport{Features: &messages.Features{BootloaderMode: proto.Bool(true)}})
+	var calls, last int
+	err = firmware.Update(&c, f, func(written, total int) {
+		calls++
+		last = written
+		if total != len(c.FirmwareUpload(data)) {
+			t.Errorf("Progress total %d", total)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls < 2 || last != len(c.FirmwareUpload(data)) {
+		t.Errorf("Progress told %d times, last %d", calls, last)
+	}
+	upload := fmt.Sprintf(`"type":"FirmwareUpload","length":%d}`, len(c.FirmwareUpload(data))-8)
+	if !strings.Contains(trace.String(), upload) || trace.Len() > 10000 {
+		t.Errorf("Upload traced with its payload, %d bytes", trace.Len())
+	}
+	c.SetTracer(nil)
+
+	// the device is gone halfway
+	transport := &common.Transport{Features: &messages.Features{BootloaderMode: proto.Bool(true)}, FailAfter: len(data) / 2}
+	c.SetTransport(transport)
+	calls, last = 0, 0
+	err = firmware.Update(&c, f, func(written, total int) {
+		calls++
+		last = written
+	})
+	if err == nil || last > transport.Written {
+		t.Errorf("Failed upload returned %v, progress %d of %d written", err, last, transport.Written)
+	}
+
+	// transports that only have Write still work, without reporting failures
+	c.SetTransport(writeOnly{&common.Transport{Features: &messages.Features{BootloaderMode: proto.Bool(true)}}})
+	if err = firmware.Update(&c, f, nil); err != nil {
+		t.Errorf("Update through a transport without WriteErr returned %v", err)
+	}
+}
+
+// writeOnly hides WriteErr, like the transports written before it
+type writeOnly struct {
+	t *common.Transport
+}
+
+func (w writeOnly) Write(msg []byte)                   { w.t.Write(msg) }
+func (w writeOnly) Read() ([]byte, uint16, int, error) { return w.t.Read() }
+func (w writeOnly) Close()                             { w.t.Close() }
+
+func TestFirmwareReleases(t *testing.T) {
+	if _, err := firmware.BundledReleases(); err != nil {
+		t.Fatal(err)
+	}
+	// made up hashes, not of any release
+	releases, err := firmware.ParseReleases([]byte(`{
+		"bootloaders": [{"version": "1.0.0", "hash": "0101010101010101010101010101010101010101010101010101010101010101"}],
+		"firmware": [{"version": "1.6.3", "revision": "0202020202020202020202020202020202020202"}],
+		"advisories": [{"id": "TEST-1", "fixed": "1.6.2", "summary": "test"}, {"id": "TEST-2", "fixed": "1.7.0", "summary": "test"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	features := &messages.Features{
+		MajorVersion:   proto.Uint32(1),
+		MinorVersion:   proto.Uint32(6),
+		PatchVersion:   proto.Uint32(3),
+		BootloaderHash: bytes.Repeat([]byte{1}, 32),
+		Revision:       bytes.Repeat([]byte{2}, 20),
+	}
+	report := releases.Check(tesoro.NewDeviceInfo(features))
+	if report.Bootloader != firmware.Official || report.BootloaderVersion != "1.0.0" || report.Firmware != firmware.Official {
+		t.Errorf("Official device reported as %+v", report)
+	}
+	if len(report.Advisories) != 1 || report.Advisories[0].ID != "TEST-2" || report.OK() {
+		t.Errorf("Advisories %v", report.Advisories)
+	}
+
+	features.BootloaderHash[0] = 0
+	features.Revision[0] = 0
+	report = releases.Check(tesoro.NewDeviceInfo(features))
+	if report.Bootloader != firmware.Unknown || report.Firmware != firmware.Mismatch {
+		t.Errorf("Modified device reported as %+v", report)
+	}
+	features.BootloaderHash, features.Revision = nil, nil
+	if report = releases.Check(tesoro.NewDeviceInfo(features)); report.Bootloader != firmware.Unreported || report.Firmware != firmware.Unreported {
+		t.Errorf("Device without hashes reported as %+v", report)
+	}
+
+	if _, err = firmware.ParseReleases([]byte(`{"bootloaders": [{"version": "1.0.0", "hash": "01"}]}`)); err == nil {
+		t.Error("Short hash parsed")
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/conejoninja/tesoro/gpg"
+)
+
+func TestGPGKeyUnwrap(t *testing.T) {
+	// RFC 3394 section 4
+	for _, v := range []struct{ kek, key, wrapped string }{
+		{"000102030405060708090A0B0C0D0E0F", "00112233445566778899AABBCCDDEEFF", "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5"},
+		{"000102030405060708090A0B0C0D0E0F1011121314151617", "00112233445566778899AABBCCDDEEFF", "96778B25AE6CA435F92B5B97C050AED2468AB8A17AD84E5D"},
+		{"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F", "00112233445566778899AABBCCDDEEFF", "64E8C3F9CE0F5BA263E9777905818A2A93C8191E7D6E8AE7"},
+		{"000102030405060708090A0B0C0D0E0F1011121314151617", "00112233445566778899AABBCCDDEEFF0001020304050607", "031D33264E15D33268F24EC260743EDCE1C6C7DDEE725A936BA814915C6762D2"},
+		{"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F", "00112233445566778899AABBCCDDEEFF0001020304050607", "A8F9BC1612C68B3FF6E6F4FBE30E71E4769C8B80A32CB8958CD5D17D6B254DA1"},
+		{"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F", "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F", "28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21"},
+	} {
+		kek, _ := hex.DecodeString(v.kek)
+		key, _ := hex.DecodeString(v.key)
+		wrapped, _ := hex.DecodeString(v.wrapped)
+		unwrapped, err := gpg.AESKeyUnwrap(kek, wrapped)
+		if err != nil || !bytes.Equal(unwrapped, key) {
+			t.Errorf("KEK %s unwrapped %x, %v", v.kek, unwrapped, err)
+		}
+		wrapped[len(wrapped)-1] ^= 1
+		if _, err = gpg.AESKeyUnwrap(kek, wrapped); err == nil {
+			t.Errorf("KEK %s unwrapped a modified key", v.kek)
+		}
+		if _, err = gpg.AESKeyUnwrap(kek, wrapped[:16]); err == nil {
+			t.Errorf("KEK %s unwrapped a short key", v.kek)
+		}
+	}
+}
+
+func TestGPGPacket(t *testing.T) {
+	// the one, two and five bytes lengths and their limits
+	for _, l := range []int{0, 1, 191, 192, 8383, 8384, 100000} {
+		body := bytes.Repeat([]byte{byte(l)}, l)
+		tag, read, err := gpg.ReadPacket(bytes.NewReader(gpg.Packet(11, body)))
+		if err != nil || tag != 11 || !bytes.Equal(read, body) {
+			t.Errorf("Packet of %d bytes read as tag %d, %d bytes, %v", l, tag, len(read), err)
+		}
+	}
+
+	// old format, then new format with a partial length of 2 bytes
+	r := bytes.NewReader([]byte{0x80 | 11<<2, 3, 'a', 'b', 'c', 0xC0 | 11, 0xE1, 'd', 'e', 1, 'f'})
+	for _, expected := range []string{"abc", "def"} {
+		if tag, body, err := gpg.ReadPacket(r); err != nil || tag != 11 || string(body) != expected {
+			t.Errorf("Read tag %d %q, %v, expected %q", tag, body, err, expected)
+		}
+	}
+	if _, _, err := gpg.ReadPacket(r); err != io.EOF {
+		t.Errorf("End of packets returned %v", err)
+	}
+
+	for _, data := range [][]byte{{0x01}, {0xC0 | 11, 5, 'a'}, {0xC0 | 11, 0xE1, 'd', 'e'}, {0x80 | 11<<2 | 1, 0}} {
+		if _, _, err := gpg.ReadPacket(bytes.NewReader(data)); err == nil || err == io.EOF {
+			t.Errorf("Malformed packet %x read, %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/conejoninja/tesoro/internal/secp256k1"
+	"github.com/conejoninja/tesoro/pb/config"
+	"github.com/conejoninja/tesoro/policy"
+	"github.com/conejoninja/tesoro/tests/common"
+	"github.com/conejoninja/tesoro/transport"
+	"github.com/golang/protobuf/proto"
+)
+
+// RFC 6979 nonce, private key 1 and "Satoshi Nakamoto"
+func TestSecp256k1(t *testing.T) {
+	d := make([]byte, 32)
+	d[31] = 1
+	hash := sha256.Sum256([]byte("Satoshi Nakamoto"))
+	sig := common.Sign(d, hash[:])
+	expected := "934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d82442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5"
+	if hex.EncodeToString(sig) != expected {
+		t.Errorf("Signature %x, expected %s", sig, expected)
+	}
+	key, err := secp256k1.ParsePublicKey(common.PublicKey(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secp256k1.Verify(key, hash[:], sig) {
+		t.Error("Signature does not verify")
+	}
+	sig[10] ^= 1
+	if secp256k1.Verify(key, hash[:], sig) {
+		t.Error("Modified signature verifies")
+	}
+	if parsed, err := secp256k1.ParsePublicKey(key.SerializeCompressed()); err != nil || !parsed.IsEqual(key) {
+		t.Errorf("Compressed key parsed as %v, %v", parsed, err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	priv := sha256.Sum256([]byte("configuration key"))
+	other := sha256.Sum256([]byte("another key"))
+	pinned := [][]byte{common.PublicKey(other[:]), common.PublicKey(priv[:])}
+	now := time.Unix(1700000000, 0)
+
+	conf := &config.Configuration{
+		WhitelistUrls: []string{`https?://([a-z]+\.)?example\.com`, `chrome-extension://abc`},
+		BlacklistUrls: []string{`https?://evil\.example\.com`},
+		KnownDevices: []*config.DeviceDescriptor{
+			{VendorId: proto.Uint32(transport.VendorOne), ProductId: proto.Uint32(transport.ProductOne), SerialNumber: proto.String("ABCD")},
+		},
+		ValidUntil: proto.Uint32(uint32(now.Add(time.Hour).Unix())),
+	}
+	blob, err := common.SignConfig(conf, priv[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := policy.Load(blob, pinned, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = policy.Load(blob, pinned[:1], now); err != policy.ErrSignature {
+		t.Errorf("Unpinned key returned %v", err)
+	}
+	tampered := append([]byte{}, blob...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err = policy.Load(tampered, pinned, now); err != policy.ErrSignature {
+		t.Errorf("Modified configuration returned %v", err)
+	}
+	if _, err = policy.Load(blob, pinned, now.Add(2*time.Hour)); err != policy.ErrExpired {
+		t.Errorf("Expired configuration returned %v", err)
+	}
+
+	for url, allowed := range map[string]bool{
+		"https://example.com":             true,
+		"https://wallet.example.com":      true,
+		"https://evil.example.com":        false,
+		"https://example.com.attacker.io": false,
+		"chrome-extension://abc":          true,
+		"http://localhost":                false,
+	} {
+		if p.AllowURL(url) != allowed {
+			t.Errorf("AllowURL(%s) returned %v", url, !allowed)
+		}
+	}
+
+	one := transport.Device{Path: "hid:0", VendorID: transport.VendorOne, ProductID: transport.ProductOne}
+	if !p.NeedsDeviceID() || p.AllowDevice(one, "") || p.AllowDevice(one, "EFGH") || !p.AllowDevice(one, "abcd") {
+		t.Error("Known devices not enforced")
+	}
+	if p.AllowDevice(transport.Device{Path: "hid:1", VendorID: transport.VendorT, ProductID: transport.ProductT}, "ABCD") {
+		t.Error("Unknown model allowed")
+	}
+}
@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/pb/types"
+	"github.com/conejoninja/tesoro/sshagent"
+	"github.com/conejoninja/tesoro/tests/common"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshDevice answers GetPublicKey and SignIdentity as the device does, with
+// one key per curve
+type sshDevice struct {
+	nist    *ecdsa.PrivateKey
+	ed      ed25519.PrivateKey
+	signed  []*messages.SignIdentity
+	invalid bool
+}
+
+func newSSHDevice(t *testing.T) *sshDevice {
+	nist, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sshDevice{nist: nist, ed: ed}
+}
+
+func (d *sshDevice) answer(msgType messages.MessageType, msg proto.Message) proto.Message {
+	switch m := msg.(type) {
+	case *messages.GetPublicKey:
+		pub := elliptic.MarshalCompressed(elliptic.P256(), d.nist.X, d.nist.Y)
+		if m.GetEcdsaCurveName() == sshagent.CurveEd25519 {
+			pub = append([]byte{0}, d.ed.Public().(ed25519.PublicKey)...)
+		}
+		return &messages.PublicKey{Node: &types.HDNodeType{
+			Depth:       proto.Uint32(5),
+			Fingerprint: proto.Uint32(0),
+			ChildNum:    proto.Uint32(0),
+			ChainCode:   make([]byte, 32),
+			PublicKey:   pub,
+		}}
+	case *messages.SignIdentity:
+		d.signed = append(d.signed, m)
+		sig := []byte{0}
+		if m.GetEcdsaCurveName() == sshagent.CurveEd25519 {
+			sig = append(sig, ed25519.Sign(d.ed, m.ChallengeHidden)...)
+		} else {
+			h := sha256.Sum256(m.ChallengeHidden)
+			r, s, _ := ecdsa.Sign(rand.Reader, d.nist, h[:])
+			sig = append(sig, make([]byte, 64)...)
+			r.FillBytes(sig[1:33])
+			s.FillBytes(sig[33:])
+		}
+		if d.invalid {
+			sig = sig[1:]
+		}
+		return &messages.SignedIdentity{Signature: sig}
+	}
+	return nil
+}
+
+func TestSSHAgentParseIdentity(t *testing.T) {
+	for str, expected := range map[string]sshagent.Identity{
+		"ssh://git@github.com":                {URI: "ssh://git@github.com", Curve: sshagent.CurveNist256p1},
+		"ssh://git@github.com,ed25519":        {URI: "ssh://git@github.com", Curve: sshagent.CurveEd25519},
+		"ssh://git@github.com,,3":             {URI: "ssh://git@github.com", Curve: sshagent.CurveNist256p1, Index: 3},
+		"ssh://me@example.com:2222,ed25519,1": {URI: "ssh://me@example.com:2222", Curve: sshagent.CurveEd25519, Index: 1},
+	} {
+		if identity, err := sshagent.ParseIdentity(str); err != nil || identity != expected {
+			t.Errorf("%s parsed as %+v, %v", str, identity, err)
+		}
+	}
+	for _, str := range []string{"git@github.com", "https://github.com", "ssh://git@github.com,secp256k1", "ssh://git@github.com,ed25519,x"} {
+		if _, err := sshagent.ParseIdentity(str); err == nil {
+			t.Errorf("%s parsed", str)
+		}
+	}
+}
+
+func TestSSHAgent(t *testing.T) {
+	device := newSSHDevice(t)
+	var c tesoro.Client
+	c.SetTransport(&common.Transport{Answer: device.answer})
+	identities := []sshagent.Identity{
+		{URI: "ssh://git@github.com", Curve: sshagent.CurveNist256p1},
+		{URI: "ssh://me@example.com", Curve: sshagent.CurveEd25519, Index: 2},
+	}
+	a := sshagent.New(&c, identities...)
+
+	// through the agent protocol, as ssh uses it
+	client, server := net.Pipe()
+	defer client.Close()
+	go agent.ServeAgent(a, server)
+	remote := agent.NewClient(client)
+
+	keys, err := remote.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Type() != ssh.KeyAlgoECDSA256 || keys[1].Type() != ssh.KeyAlgoED25519 || keys[1].Comment != "ssh://me@example.com" {
+		t.Fatalf("Listed %v", keys)
+	}
+
+	data := []byte("session id and user auth request")
+	hosts := []string{"github.com", "example.com"}
+	for i, key := range keys {
+		sig, err := remote.Sign(key, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = key.Verify(data, sig); err != nil {
+			t.Errorf("Signature of %s: %v", key.Comment, err)
+		}
+		request := device.signed[i]
+		if request.Identity.GetHost() != hosts[i] || request.Identity.GetIndex() != identities[i].Index || request.GetEcdsaCurveName() != identities[i].Curve || string(request.ChallengeHidden) != string(data) {
+			t.Errorf("SignIdentity sent as %v", request)
+		}
+	}
+
+	otherKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	other, _ := ssh.NewPublicKey(otherKey)
+	if _, err = remote.Sign(other, data); err == nil {
+		t.Error("Signed with a key of no identity")
+	}
+	if _, err = a.Sign(other, data); err != sshagent.ErrUnknownKey {
+		t.Errorf("Unknown key returned %v", err)
+	}
+
+	device.invalid = true
+	if _, err = a.Sign(keys[0], data); err == nil {
+		t.Error("Short signature accepted")
+	}
+	device.invalid = false
+
+	if err = remote.Lock([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err = remote.List(); err != nil || len(keys) != 0 {
+		t.Errorf("Locked agent listed %v, %v", keys, err)
+	}
+	if _, err = a.Sign(other, data); err != sshagent.ErrLocked {
+		t.Errorf("Locked agent signed, %v", err)
+	}
+	if err = remote.Unlock([]byte("wrong")); err == nil {
+		t.Error("Unlocked with a wrong passphrase")
+	}
+	if err = remote.Unlock([]byte("secret")); err != nil {
+		t.Error(err)
+	}
+	if keys, err = remote.List(); err != nil || len(keys) != 2 {
+		t.Errorf("Unlocked agent listed %v, %v", keys, err)
+	}
+	if err = remote.RemoveAll(); err == nil {
+		t.Error("Keys removed from the device")
+	}
+}
+
+// logWriter hands every log line to the test
+type logWriter chan string
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestSSHAgentErrorLog(t *testing.T) {
+	var c tesoro.Client
+	c.SetTransport(&common.Transport{})
+	a := sshagent.New(&c)
+	lines := make(logWriter, 1)
+	a.SetErrorLog(slog.New(slog.NewTextHandler(lines, nil)))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- a.Serve(l) }()
+
+	// a request longer than the protocol allows ends the connection
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	select {
+	case line := <-lines:
+		if !strings.Contains(line, "level=ERROR") || !strings.Contains(line, "too large") {
+			t.Errorf("Logged %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Connection error not logged")
+	}
+	conn.Close()
+
+	l.Close()
+	if err = <-served; err == nil {
+		t.Error("Serve returned no error after the listener was closed")
+	}
+}
@@ -18,6 +18,9 @@ import (
 
 var testBLClient tesoro.Client
 
+// testBLConnected tells if init found a device, the tests are skipped without one
+var testBLConnected bool
+
 func init() {
 	numberDevices := 0
 
@@ -32,16 +35,20 @@ func init() {
 		}
 
 	})
+	testBLConnected = numberDevices > 0
 	if numberDevices == 0 {
 		fmt.Println("No TREZOR devices found, make sure your device is connected")
-	} else {
-		fmt.Printf("Found %d TREZOR devices connected\n", numberDevices)
+		return
 	}
+	fmt.Printf("Found %d TREZOR devices connected\n", numberDevices)
 	// Introduce delay, or it's too fast and it will fail the tests
 	time.Sleep(1 * time.Second)
 }
 
 func TestBLInitialize(t *testing.T) {
+	if !testBLConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	t.Log("We need to check if device is in bootloader mode.")
 	{
@@ -66,6 +73,9 @@ func TestBLInitialize(t *testing.T) {
 }
 
 func TestBLFirmwareUpload(t *testing.T) {
+	if !testBLConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	t.Log("We'll try to upload a new firmware.")
 	{
@@ -16,6 +16,9 @@ import (
 
 var testClient tesoro.Client
 
+// testConnected tells if init found a device, the tests are skipped without one
+var testConnected bool
+
 func init() {
 	numberDevices := 0
 
@@ -30,16 +33,20 @@ func init() {
 		}
 
 	})
+	testConnected = numberDevices > 0
 	if numberDevices == 0 {
 		fmt.Println("No TREZOR devices found, make sure your device is connected")
-	} else {
-		fmt.Printf("Found %d TREZOR devices connected\n", numberDevices)
+		return
 	}
+	fmt.Printf("Found %d TREZOR devices connected\n", numberDevices)
 	// Introduce delay, or it's too fast and it will fail the tests
 	time.Sleep(1 * time.Second)
 }
 
 func TestPing(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	var expectedPing = "PONG"
 
@@ -66,6 +73,9 @@ func TestPing(t *testing.T) {
 }
 
 func TestPingButton(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	var expectedPing = "PONG"
 
@@ -91,6 +101,9 @@ func TestPingButton(t *testing.T) {
 }
 
 func TestPingButtonCancel(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	var expectedPing = "PONG"
 	var expectedString = "Action cancelled by user"
@@ -117,6 +130,9 @@ func TestPingButtonCancel(t *testing.T) {
 }
 
 func TestInitialize(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	t.Log("We need to test the Initialize.")
 	{
@@ -134,6 +150,9 @@ func TestInitialize(t *testing.T) {
 }
 
 func TestGetFeatures(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	t.Log("We need to test the GetFeatures.")
 	{
@@ -151,6 +170,9 @@ func TestGetFeatures(t *testing.T) {
 }
 
 func TestClearSession(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	t.Log("We need to test the ClearSession.")
 	{
@@ -168,6 +190,9 @@ func TestClearSession(t *testing.T) {
 }
 
 func TestGetEntropy(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	fmt.Println("[WHAT TO DO] Click on \"Confirm\"")
 	t.Log("We need to test the GetEntropy.")
@@ -266,6 +291,9 @@ func aTestLoadDevice18(t *testing.T) {
 }
 
 func TestLoadDevice24(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	t.Log("We need to test the LoadDevice.")
 	{
@@ -303,6 +331,9 @@ func TestLoadDevice24(t *testing.T) {
 }
 
 func TestSetLabel(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	var expectedLabel = "test.LABEL"
 	t.Log("We need to test the SetLabel.")
@@ -336,6 +367,9 @@ func TestSetLabel(t *testing.T) {
 }
 
 func TestSetLabel2(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	var expectedLabel = "label.TEST"
 	t.Log("We need to test the SetLabel.")
@@ -369,6 +403,9 @@ func TestSetLabel2(t *testing.T) {
 }
 
 func TestSetHomeScreen(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	t.Log("We need to test the SetHomeScreen.")
 	{
@@ -387,6 +424,9 @@ func TestSetHomeScreen(t *testing.T) {
 }
 
 func TestSetHomeScreen2(t *testing.T) {
+	if !testConnected {
+		t.Skip("no TREZOR device connected")
+	}
 
 	t.Log("We need to test the SetHomeScreen (again).")
 	{
@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+func TestTPMEntropy(t *testing.T) {
+	if bits := tpm.Entropy("aaaaaaaaaaaa"); bits > 5 {
+		t.Errorf("Repeated characters estimated at %.1f bits", bits)
+	}
+	if bits := tpm.Entropy("Tr0ub4dor&3xK9!q"); bits < 80 {
+		t.Errorf("Mixed password estimated at %.1f bits", bits)
+	}
+}
+
+func TestTPMBreaches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tesoro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	hash := func(password string) string {
+		h := sha1.Sum([]byte(password))
+		return strings.ToUpper(hex.EncodeToString(h[:]))
+	}
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("%s:%d", hash(fmt.Sprint("password", i)), i+1))
+	}
+	sort.Strings(lines)
+	sorted := filepath.Join(dir, "pwned-passwords-sha1-ordered-by-hash.txt")
+	if err = ioutil.WriteFile(sorted, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := filepath.Join(dir, "range")
+	os.Mkdir(ranges, 0700)
+	h := hash("password42")
+	ioutil.WriteFile(filepath.Join(ranges, h[:5]), []byte(h[5:]+":43\n"), 0600)
+
+	for _, path := range []string{sorted, ranges} {
+		b, err := tpm.OpenBreaches(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count, err := b.Count("password42"); count != 43 || err != nil {
+			t.Errorf("%s: Count of a breached password returned %d %v", path, count, err)
+		}
+		if count, err := b.Count("not breached"); count != 0 || err != nil {
+			t.Errorf("%s: Count of a safe password returned %d %v", path, count, err)
+		}
+	}
+
+	b, _ := tpm.OpenBreaches(sorted)
+	for _, i := range []int{0, 1, 250, 498, 499} {
+		if count, _ := b.Count(fmt.Sprint("password", i)); count != i+1 {
+			t.Errorf("Count of password%d returned %d", i, count)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+// memoryServer stands in for a WebDAV collection and for the Dropbox API,
+// keeping every file in memory with a revision counter.
+type memoryServer struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	revs  map[string]int
+}
+
+func newMemoryServer() *httptest.Server {
+	m := &memoryServer{files: map[string][]byte{}, revs: map[string]int{}}
+	return httptest.NewServer(m)
+}
+
+func (m *memoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/2/files/") {
+		m.dropbox(w, r)
+		return
+	}
+
+	name := r.URL.Path
+	data, exists := m.files[name]
+	etag := `"` + strconv.Itoa(m.revs[name]) + `"`
+	switch r.Method {
+	case "GET", "HEAD":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	case "PUT":
+		if (r.Header.Get("If-None-Match") == "*" && exists) ||
+			(r.Header.Get("If-Match") != "" && (!exists || r.Header.Get("If-Match") != etag)) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		m.files[name], _ = ioutil.ReadAll(r.Body)
+		m.revs[name]++
+		w.Header().Set("ETag", `"`+strconv.Itoa(m.revs[name])+`"`)
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (m *memoryServer) dropbox(w http.ResponseWriter, r *http.Request) {
+	var arg struct {
+		Path string `json:"path"`
+		Mode struct {
+			Tag    string `json:".tag"`
+			Update string `json:"update"`
+		} `json:"mode"`
+	}
+	if r.Header.Get("Dropbox-API-Arg") != "" {
+		json.Unmarshal([]byte(r.Header.Get("Dropbox-API-Arg")), &arg)
+	} else {
+		json.NewDecoder(r.Body).Decode(&arg)
+	}
+
+	data, exists := m.files[arg.Path]
+	fail := func(summary string) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error_summary": summary})
+	}
+	metadata := func() []byte {
+		meta, _ := json.Marshal(map[string]interface{}{
+			"name": arg.Path[1:],
+			"rev":  "rev" + strconv.Itoa(m.revs[arg.Path]),
+			"size": len(m.files[arg.Path]),
+		})
+		return meta
+	}
+
+	switch r.URL.Path {
+	case "/2/files/download", "/2/files/get_metadata":
+		if !exists {
+			fail("path/not_found/")
+			return
+		}
+		if r.URL.Path == "/2/files/get_metadata" {
+			w.Write(metadata())
+			return
+		}
+		w.Header().Set("Dropbox-API-Result", string(metadata()))
+		w.Write(data)
+	case "/2/files/upload":
+		if (arg.Mode.Tag == "add" && exists) ||
+			(arg.Mode.Tag == "update" && arg.Mode.Update != "rev"+strconv.Itoa(m.revs[arg.Path])) {
+			fail("path/conflict/file/")
+			return
+		}
+		m.files[arg.Path], _ = ioutil.ReadAll(r.Body)
+		m.revs[arg.Path]++
+		w.Write(metadata())
+	}
+}
+
+func testBackend(t *testing.T, backend tpm.Backend) {
+	if _, _, err := backend.Read("vault.pswd"); err != tpm.ErrNotExist {
+		t.Fatalf("Read of a missing file returned %v, expected ErrNotExist", err)
+	}
+
+	first, err := backend.Write("vault.pswd", []byte("first"), "")
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err = backend.Write("vault.pswd", []byte("again"), ""); err != tpm.ErrConflict {
+		t.Errorf("Creating an existing file returned %v, expected ErrConflict", err)
+	}
+
+	data, info, err := backend.Read("vault.pswd")
+	if err != nil || string(data) != "first" || info.Revision != first.Revision {
+		t.Fatalf("Read returned %q %q %v", data, info.Revision, err)
+	}
+
+	second, err := backend.Write("vault.pswd", []byte("second"), first.Revision)
+	if err != nil {
+		t.Fatalf("Write at the current revision failed: %v", err)
+	}
+	if second.Revision == first.Revision {
+		t.Errorf("Revision did not change after a write")
+	}
+
+	// someone else wrote "second" since we read "first"
+	if _, err = backend.Write("vault.pswd", []byte("stale"), first.Revision); err != tpm.ErrConflict {
+		t.Errorf("Write at a stale revision returned %v, expected ErrConflict", err)
+	}
+	if info, err = backend.Stat("vault.pswd"); err != nil || info.Revision != second.Revision {
+		t.Errorf("Stat returned %q %v, expected %q", info.Revision, err, second.Revision)
+	}
+}
+
+func TestTPMLocalBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tesoro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	testBackend(t, tpm.NewLocal(dir))
+}
+
+func TestTPMWebDAVBackend(t *testing.T) {
+	server := newMemoryServer()
+	defer server.Close()
+	testBackend(t, tpm.NewWebDAV(server.URL+"/TPM/", "", ""))
+}
+
+func TestTPMDropboxBackend(t *testing.T) {
+	server := newMemoryServer()
+	defer server.Close()
+	dropbox := tpm.NewDropbox("token")
+	dropbox.APIURL = server.URL
+	dropbox.ContentURL = server.URL
+	testBackend(t, dropbox)
+}
@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+var convertAccounts = []tpm.Account{
+	{Name: "Mail", URL: "https://mail.example.com", Username: "me@example.com", Password: `p"a,s;s<w>o&rd`, Notes: "line 1\nline 2", Tags: []string{"Social"}},
+	{Name: "Router", URL: "http://192.168.1.1", Username: "admin", Password: "admin"},
+}
+
+func TestTPMConvertRoundTrip(t *testing.T) {
+	for _, format := range []tpm.Format{tpm.FormatKeePass, tpm.FormatBitwarden, tpm.FormatCSV} {
+		var buf bytes.Buffer
+		if err := tpm.Write(format, &buf, convertAccounts); err != nil {
+			t.Fatalf("%s: Write failed: %v", format, err)
+		}
+		accounts, err := tpm.Parse(format, &buf)
+		if err != nil {
+			t.Fatalf("%s: Parse failed: %v", format, err)
+		}
+		if !reflect.DeepEqual(accounts, convertAccounts) {
+			t.Errorf("%s: round trip returned %+v", format, accounts)
+		}
+	}
+}
+
+func TestTPMParseKeePassGroups(t *testing.T) {
+	xml := `<?xml version="1.0" encoding="utf-8" standalone="yes"?>
+<KeePassFile>
+	<Meta><RecycleBinUUID>bin</RecycleBinUUID></Meta>
+	<Root><Group><UUID>root</UUID><Name>Database</Name>
+		<Group><UUID>work</UUID><Name>Work</Name>
+			<Entry><UUID>a</UUID><Tags>vpn;admin</Tags>
+				<String><Key>Title</Key><Value>VPN</Value></String>
+				<String><Key>Password</Key><Value ProtectInMemory="True">secret</Value></String>
+				<History><Entry><String><Key>Title</Key><Value>old VPN</Value></String></Entry></History>
+			</Entry>
+		</Group>
+		<Group><UUID>bin</UUID><Name>Recycle Bin</Name>
+			<Entry><String><Key>Title</Key><Value>deleted</Value></String></Entry>
+		</Group>
+	</Group></Root>
+</KeePassFile>`
+	accounts, err := tpm.ParseKeePass(strings.NewReader(xml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []tpm.Account{{Name: "VPN", Password: "secret", Tags: []string{"Work", "vpn", "admin"}}}
+	if !reflect.DeepEqual(accounts, expected) {
+		t.Errorf("ParseKeePass returned %+v", accounts)
+	}
+}
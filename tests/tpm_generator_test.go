@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+func TestTPMGeneratorChars(t *testing.T) {
+	g := tpm.NewGenerator()
+	g.Length = 12
+	g.Digits = false
+	g.Symbols = true
+	entropyCalls := 0
+	g.Entropy = func(n int) ([]byte, error) {
+		entropyCalls++
+		return make([]byte, n), nil
+	}
+
+	password, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(password) != 12 || strings.ContainsAny(password, "0123456789") {
+		t.Errorf("Unexpected password %q", password)
+	}
+	if !strings.ContainsAny(password, "abcdefghijklmnopqrstuvwxyz") || !strings.ContainsAny(password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+		t.Errorf("Password %q does not use every class", password)
+	}
+	if entropyCalls != 1 {
+		t.Errorf("Device was asked for entropy %d times", entropyCalls)
+	}
+
+	// a device returning zeros must not make passwords predictable
+	other, _ := g.Generate()
+	if other == password {
+		t.Errorf("Generated the same password twice")
+	}
+}
+
+func TestTPMGeneratorWords(t *testing.T) {
+	words, err := tpm.ParseWordList(strings.NewReader("11111\tabacus\n11112\tabdomen\n\n11113\tabdominal\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(words, " ") != "abacus abdomen abdominal" {
+		t.Fatalf("ParseWordList returned %v", words)
+	}
+
+	g := tpm.NewGenerator()
+	g.Words = 5
+	g.WordList = words
+	g.Separator = "-"
+	passphrase, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(passphrase, "-")
+	if len(parts) != 5 {
+		t.Fatalf("Unexpected passphrase %q", passphrase)
+	}
+	for _, p := range parts {
+		if !strings.HasPrefix(p, "ab") {
+			t.Errorf("Word %q is not in the list", p)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+func mergeEntry(title, nonce string, tags ...int) tesoro.Entry {
+	return tesoro.Entry{Title: title, Nonce: nonce, Tags: tags}
+}
+
+func copyStorage(s tesoro.Storage) tesoro.Storage {
+	c := s
+	c.Tags = map[string]tesoro.Tag{}
+	for k, t := range s.Tags {
+		c.Tags[k] = t
+	}
+	c.Entries = map[string]tesoro.Entry{}
+	for k, e := range s.Entries {
+		c.Entries[k] = e
+	}
+	return c
+}
+
+func TestTPMMerge(t *testing.T) {
+	base := tpm.NewStorage()
+	base.Entries["1"] = mergeEntry("unchanged", "a")
+	base.Entries["2"] = mergeEntry("edited by us", "b")
+	base.Entries["3"] = mergeEntry("edited by them", "c")
+	base.Entries["4"] = mergeEntry("deleted by us", "d")
+	base.Entries["5"] = mergeEntry("edited by both", "e")
+
+	ours := copyStorage(base)
+	theirs := copyStorage(base)
+
+	ours.Entries["2"] = mergeEntry("edited by us", "b2")
+	theirs.Entries["3"] = mergeEntry("edited by them", "c2")
+	delete(ours.Entries, "4")
+	ours.Entries["5"] = mergeEntry("edited by both", "e-ours")
+	theirs.Entries["5"] = mergeEntry("edited by both", "e-theirs")
+	ours.Entries["6"] = mergeEntry("added by us", "f", 3)
+	ours.Tags["3"] = tesoro.Tag{Title: "Work"}
+	theirs.Entries["6"] = mergeEntry("added by them", "g", 3)
+	theirs.Tags["3"] = tesoro.Tag{Title: "Home"}
+
+	merged, conflicts := tpm.Merge(base, ours, theirs)
+
+	expected := map[string]string{"1": "a", "2": "b2", "3": "c2", "5": "e-theirs", "6": "g"}
+	for id, nonce := range expected {
+		if merged.Entries[id].Nonce != nonce {
+			t.Errorf("Entry %s is %+v, expected nonce %s", id, merged.Entries[id], nonce)
+		}
+	}
+	if _, ok := merged.Entries["4"]; ok {
+		t.Errorf("Deleted entry was kept")
+	}
+	if len(merged.Entries) != 7 {
+		t.Fatalf("Merged %d entries, expected 7", len(merged.Entries))
+	}
+	if len(conflicts) != 1 || conflicts[0].ID != "5" || merged.Entries[conflicts[0].Copy].Nonce != "e-ours" {
+		t.Errorf("Unexpected conflicts %+v", conflicts)
+	}
+
+	// our new entry keeps our new tag, moved to a free id
+	for _, e := range merged.Entries {
+		if e.Nonce == "f" {
+			if len(e.Tags) != 1 || merged.Tags[strconv.Itoa(e.Tags[0])].Title != "Work" {
+				t.Errorf("Our entry lost its tag: %+v", e)
+			}
+		}
+	}
+	if merged.Tags["3"].Title != "Home" {
+		t.Errorf("Their tag was replaced: %+v", merged.Tags)
+	}
+}
+
+func TestTPMEntryEqual(t *testing.T) {
+	a := tesoro.Entry{Title: "a", Tags: []int{1, 2}}
+	b := tesoro.Entry{Title: "a", Tags: []int{2, 1}, Password: tesoro.EncryptedData{Data: []byte{}}}
+	if !a.Equal(b) {
+		t.Errorf("Entries differing in tag order or empty data are not equal")
+	}
+	b.Tags = []int{1}
+	if a.Equal(b) {
+		t.Errorf("Entries with different tags are equal")
+	}
+}
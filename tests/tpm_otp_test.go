@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+// RFC 6238 appendix B, SHA1 with 8 digits
+func TestTPMTOTP(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	o, err := tpm.FindOTP("recovery codes in the drawer\notpauth://totp/Example:me@example.com?secret=" + secret + "&digits=8&issuer=Example\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.HOTP || o.Issuer != "Example" || o.Account != "me@example.com" {
+		t.Errorf("Unexpected OTP %+v", o)
+	}
+	for unix, expected := range map[int64]string{59: "94287082", 1111111109: "07081804", 1234567890: "89005924", 2000000000: "69279037"} {
+		code, remaining := o.TOTP(time.Unix(unix, 0))
+		if code != expected {
+			t.Errorf("TOTP at %d returned %s, expected %s", unix, code, expected)
+		}
+		if remaining <= 0 || remaining > 30*time.Second {
+			t.Errorf("TOTP at %d valid for %s", unix, remaining)
+		}
+	}
+}
+
+// RFC 4226 appendix D
+func TestTPMHOTP(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	o, err := tpm.ParseOTP("otpauth://hotp/me?secret=" + secret + "&counter=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"755224", "287082", "359152", "969429"} {
+		if code := o.Next(); code != expected {
+			t.Errorf("HOTP returned %s, expected %s", code, expected)
+		}
+	}
+	if o.Counter != 4 {
+		t.Errorf("Counter is %d, expected 4", o.Counter)
+	}
+
+	if _, err = tpm.FindOTP("JBSW Y3DP EHPK 3PXP"); err != nil {
+		t.Errorf("Base32 secret not found: %v", err)
+	}
+	if _, err = tpm.FindOTP("just a note"); err != tpm.ErrNoOTP {
+		t.Errorf("FindOTP on a plain note returned %v", err)
+	}
+}
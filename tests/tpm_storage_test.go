@@ -0,0 +1,29 @@
+package tests
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/tpm"
+)
+
+func TestTPMEncryptStorage(t *testing.T) {
+	key := strings.Repeat("ab", 32)
+	storage := tpm.NewStorage()
+	storage.Entries["1"] = mergeEntry("entry", "a")
+	data, err := tesoro.EncryptStorage(storage, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := tesoro.DecryptStorage(string(data), key)
+	if err != nil || decrypted.Entries["1"].Title != "entry" {
+		t.Errorf("Storage decrypted as %+v, %v", decrypted, err)
+	}
+	for _, bad := range []string{"", "zz", strings.Repeat("ab", 16)} {
+		if _, err = tesoro.EncryptStorage(storage, bad); !errors.Is(err, tesoro.ErrEncrypting) {
+			t.Errorf("Key %q returned %v", bad, err)
+		}
+	}
+}
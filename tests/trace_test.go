@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/tests/common"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestTrace(t *testing.T) {
+	var buf bytes.Buffer
+	var c tesoro.Client
+	c.SetTransport(&common.Transport{})
+	// without the time, its digits could match the secrets
+	c.SetTracer(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})))
+
+	c.Call(c.PinMatrixAck("1234"))
+	c.Call(c.LoadDevice("all all all all all all all all all all all all", false, "label", "9876", false, 0))
+	c.Call(c.CipherKeyValue(true, "key", []byte("secret value"), []uint32{1}, nil, false, false))
+	c.Call(c.SetLabel("tesoro"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("%d lines traced, expected 8:\n%s", len(lines), buf.String())
+	}
+	trace := buf.String()
+	for _, secret := range []string{"1234", "all all", "9876", `"value":"c2Vj`} {
+		if strings.Contains(trace, secret) {
+			t.Errorf("%s in the trace:\n%s", secret, trace)
+		}
+	}
+	for _, expected := range []string{
+		`"direction":"out","type":"PinMatrixAck","length":6,"message":{"pin":"[redacted]"}`,
+		`"direction":"in","type":"Success","length":4,"message":{"message":"ok"}`,
+		`"value":"[redacted]"`,
+		`"message":{"label":"tesoro"}`,
+	} {
+		if !strings.Contains(trace, expected) {
+			t.Errorf("%s not in the trace:\n%s", expected, trace)
+		}
+	}
+
+	c.SetTracer(nil)
+	buf.Reset()
+	c.Call(c.Initialize())
+	if buf.Len() != 0 {
+		t.Errorf("Traced after SetTracer(nil): %s", buf.String())
+	}
+}
+
+// the secrets the device answers with never reach the trace either
+func TestTraceSecrets(t *testing.T) {
+	sessionKey := bytes.Repeat([]byte{0x5e}, 65)
+	entropy := bytes.Repeat([]byte{0xe7}, 32)
+	plaintext := []byte("plaintext of the message")
+	transport := &common.Transport{Answer: func(msgType messages.MessageType, msg proto.Message) proto.Message {
+		switch msgType {
+		case messages.MessageType_MessageType_GetECDHSessionKey:
+			return &messages.ECDHSessionKey{SessionKey: sessionKey}
+		case messages.MessageType_MessageType_GetEntropy:
+			return &messages.Entropy{Entropy: entropy}
+		case messages.MessageType_MessageType_DecryptMessage:
+			return &messages.DecryptedMessage{Message: plaintext, Address: proto.String("1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH")}
+		}
+		return nil
+	}}
+	var buf bytes.Buffer
+	var c tesoro.Client
+	c.SetTransport(transport)
+	c.SetTracer(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	if str, _ := c.Call(c.GetECDHSessionKey("gpg://tesoro", 0, []byte{4}, "nist256p1")); str != string(sessionKey) {
+		t.Errorf("Session key answered as %x", str)
+	}
+	c.Call(c.GetEntropy(32))
+	c.Call(c.EntropyAck(entropy))
+	c.Call(c.EncryptMessage("pubkey", string(plaintext), false, "m/44'/0'/0'", "Bitcoin"))
+	c.Call(c.DecryptMessage("m/44'/0'/0'", []byte("nonce"), []byte("ciphertext"), []byte("hmac")))
+
+	trace := buf.String()
+	for _, secret := range [][]byte{sessionKey, entropy, plaintext} {
+		for _, encoded := range []string{string(secret), hex.EncodeToString(secret[:8]), base64.StdEncoding.EncodeToString(secret)[:8]} {
+			if strings.Contains(trace, encoded) {
+				t.Errorf("%s in the trace:\n%s", encoded, trace)
+			}
+		}
+	}
+	for _, expected := range []string{
+		`"type":"ECDHSessionKey","length":67,"message":{"session_key":"[redacted]"}`,
+		`"type":"Entropy","length":34,"message":{"entropy":"[redacted]"}`,
+		`"type":"EntropyAck","length":34,"message":{"entropy":"[redacted]"}`,
+		`"message":"[redacted]","pubkey"`,
+		`"type":"DecryptedMessage","length":62,"message":{"address":"1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH","message":"[redacted]"}`,
+	} {
+		if !strings.Contains(trace, expected) {
+			t.Errorf("%s not in the trace:\n%s", expected, trace)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/conejoninja/tesoro/tests/common"
+	"github.com/conejoninja/tesoro/transport"
+	"github.com/conejoninja/tesoro/u2f"
+)
+
+// u2fToken answers register and authenticate APDUs as the device does,
+// with one key pair for every application
+type u2fToken struct {
+	key, attestation *ecdsa.PrivateKey
+	cert             []byte
+	keyHandle        []byte
+	counter          uint32
+}
+
+func newU2FToken(t *testing.T) *u2fToken {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attestation, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test attestation"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &attestation.PublicKey, attestation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &u2fToken{key: key, attestation: attestation, cert: cert, keyHandle: bytes.Repeat([]byte{7}, 64), counter: 41}
+}
+
+func (u *u2fToken) publicKey() []byte {
+	pub, _ := u.key.PublicKey.ECDH()
+	return pub.Bytes()
+}
+
+func (u *u2fToken) answer(cmd byte, apdu []byte) []byte {
+	ins, p1 := apdu[1], apdu[2]
+	data := apdu[7 : 7+int(binary.BigEndian.Uint16(apdu[5:7]))]
+	switch ins {
+	case 0x01:
+		challenge, app := data[:32], data[32:64]
+		signed := append([]byte{0}, app...)
+		signed = append(signed, challenge...)
+		signed = append(signed, u.keyHandle...)
+		signed = append(signed, u.publicKey()...)
+		h := sha256.Sum256(signed)
+		sig, _ := ecdsa.SignASN1(rand.Reader, u.attestation, h[:])
+		resp := append([]byte{0x05}, u.publicKey()...)
+		resp = append(resp, byte(len(u.keyHandle)))
+		resp = append(resp, u.keyHandle...)
+		resp = append(resp, u.cert...)
+		resp = append(resp, sig...)
+		return append(resp, 0x90, 0x00)
+	case 0x02:
+		challenge, app, handle := data[:32], data[32:64], data[65:]
+		if !bytes.Equal(handle, u.keyHandle) {
+			return []byte{0x6A, 0x80}
+		}
+		if p1 == 0x07 {
+			return []byte{0x69, 0x85}
+		}
+		u.counter++
+		resp := []byte{1, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(resp[1:], u.counter)
+		h := sha256.Sum256(append(append(append([]byte{}, app...), resp...), challenge...))
+		sig, _ := ecdsa.SignASN1(rand.Reader, u.key, h[:])
+		return append(append(resp, sig...), 0x90, 0x00)
+	case 0x03:
+		return []byte("U2F_V2\x90\x00")
+	}
+	return []byte{0x6D, 0x00}
+}
+
+func TestU2FFraming(t *testing.T) {
+	token := newU2FToken(t)
+	device := &common.U2FDevice{CID: 0x01020304, Answer: token.answer}
+	var tr transport.TransportU2F
+	tr.SetDevice(device)
+	dev := u2f.New(&tr)
+
+	version, err := dev.Version()
+	if err != nil || version != "U2F_V2" {
+		t.Fatalf("Version returned %q, %v", version, err)
+	}
+	// INIT on the broadcast channel, then the message on the allocated one
+	if len(device.Packets) != 2 || binary.BigEndian.Uint32(device.Packets[0]) != 0xFFFFFFFF || device.Packets[0][4] != transport.U2FHIDInit {
+		t.Fatalf("INIT sent as %x", device.Packets)
+	}
+	if binary.BigEndian.Uint32(device.Packets[1]) != device.CID || device.Packets[1][4] != transport.U2FHIDMsg {
+		t.Errorf("Message sent as %x", device.Packets[1])
+	}
+
+	app, challenge := u2f.AppParam("https://example.com"), bytes.Repeat([]byte{1}, 32)
+	reg, err := dev.Register(app, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the 7 bytes header, 64 bytes of parameters and 2 of Le need a
+	// continuation packet with sequence 0
+	last := device.Packets[len(device.Packets)-1]
+	if len(device.Packets) != 4 || binary.BigEndian.Uint32(last) != device.CID || last[4] != 0 {
+		t.Errorf("Register sent as %x", device.Packets[2:])
+	}
+	if !bytes.Equal(reg.KeyHandle, token.keyHandle) || !bytes.Equal(reg.PublicKey, token.publicKey()) {
+		t.Errorf("Registration read as %+v", reg)
+	}
+	if _, err = reg.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	auth, err := dev.Authenticate(app, challenge, reg.KeyHandle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auth.UserPresence || auth.Counter != 42 {
+		t.Errorf("Authentication read as %+v", auth)
+	}
+	if err = auth.Verify(reg.PublicKey, app, challenge); err != nil {
+		t.Error(err)
+	}
+
+	if ok, err := dev.CheckKeyHandle(app, reg.KeyHandle); !ok || err != nil {
+		t.Errorf("Own key handle checked as %v, %v", ok, err)
+	}
+	if ok, err := dev.CheckKeyHandle(app, bytes.Repeat([]byte{8}, 64)); ok || err != nil {
+		t.Errorf("Other key handle checked as %v, %v", ok, err)
+	}
+
+	// a slow answer after the packets of another channel
+	device.Delay, device.Other = 3, true
+	if auth, err = dev.Authenticate(app, challenge, reg.KeyHandle); err != nil || auth.Counter != 43 {
+		t.Errorf("Slow authentication returned %+v, %v", auth, err)
+	}
+
+	// answers on another channel only
+	tr.Timeout = 300 * time.Millisecond
+	device.CID++
+	if _, err = dev.Version(); err != transport.ErrU2FTimeout {
+		t.Errorf("Answer on another channel returned %v", err)
+	}
+}
+
+func TestU2FParseCounterBackup(t *testing.T) {
+	for data, expected := range map[string]uint32{
+		`{"u2f_counter":1234,"date":"2018-01-02T03:04:05Z"}`: 1234,
+		`{"u2f_counter":0}`: 0,
+		"4294967295\n":      4294967295,
+	} {
+		if counter, err := u2f.ParseCounterBackup([]byte(data)); err != nil || counter != expected {
+			t.Errorf("%s parsed as %d, %v", data, counter, err)
+		}
+	}
+	for _, data := range []string{`{}`, `null`, `{"counter":5}`, `[]`, "", "abc", "4294967296", "-1"} {
+		if counter, err := u2f.ParseCounterBackup([]byte(data)); err == nil {
+			t.Errorf("%q parsed as %d", data, counter)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package tpm
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Kinds of audit findings
+const (
+	FindingEmpty    = "empty password"
+	FindingReused   = "reused password"
+	FindingShort    = "short password"
+	FindingWeak     = "low entropy password"
+	FindingBreached = "breached password"
+	FindingInsecure = "URL without https"
+)
+
+// Finding is a problem found in an entry. It never holds the password.
+type Finding struct {
+	ID     string
+	Kind   string
+	Detail string
+}
+
+func (f Finding) String() string {
+	if f.Detail == "" {
+		return fmt.Sprintf("#%s: %s", f.ID, f.Kind)
+	}
+	return fmt.Sprintf("#%s: %s, %s", f.ID, f.Kind, f.Detail)
+}
+
+// AuditOptions are the thresholds of Audit
+type AuditOptions struct {
+	MinLength int
+	// MinEntropy in bits, estimated from the length and character classes
+	MinEntropy float64
+	// Breaches is checked when not nil
+	Breaches *Breaches
+}
+
+// DefaultAuditOptions flags passwords under 10 characters or 50 bits
+var DefaultAuditOptions = AuditOptions{MinLength: 10, MinEntropy: 50}
+
+// Audit unlocks every entry on the device and reports weak, reused, empty
+// and breached passwords and URLs that are not https. Callers confirm the
+// whole run once, but the device still asks for each entry: the key of an
+// entry comes from a CipherKeyValue whose derivation includes its title,
+// username and ask_on_decrypt flag, so no single unlock can open them all
+// without re-encrypting the vault in a format TPM does not read.
+func (v *Vault) Audit(opts AuditOptions) ([]Finding, error) {
+	var findings []Finding
+	reused := map[string][]string{}
+
+	for _, r := range v.List() {
+		item, err := v.Get(r.ID)
+		if err != nil {
+			return findings, fmt.Errorf("entry %s: %v", r.ID, err)
+		}
+
+		if u, err := url.Parse(item.Title); err == nil && u.Scheme != "" && u.Scheme != "https" {
+			findings = append(findings, Finding{ID: r.ID, Kind: FindingInsecure, Detail: u.Scheme + "://"})
+		}
+
+		if item.Password == "" {
+			findings = append(findings, Finding{ID: r.ID, Kind: FindingEmpty})
+			continue
+		}
+		reused[item.Password] = append(reused[item.Password], r.ID)
+
+		length := len([]rune(item.Password))
+		if length < opts.MinLength {
+			findings = append(findings, Finding{ID: r.ID, Kind: FindingShort, Detail: fmt.Sprintf("%d characters", length)})
+		} else if bits := Entropy(item.Password); bits < opts.MinEntropy {
+			findings = append(findings, Finding{ID: r.ID, Kind: FindingWeak, Detail: fmt.Sprintf("about %.0f bits", bits)})
+		}
+
+		if opts.Breaches != nil {
+			count, err := opts.Breaches.Count(item.Password)
+			if err != nil {
+				return findings, err
+			}
+			if count > 0 {
+				findings = append(findings, Finding{ID: r.ID, Kind: FindingBreached, Detail: fmt.Sprintf("seen %d times", count)})
+			}
+		}
+	}
+
+	for _, ids := range reused {
+		if len(ids) < 2 {
+			continue
+		}
+		for _, id := range ids {
+			var others []string
+			for _, other := range ids {
+				if other != id {
+					others = append(others, "#"+other)
+				}
+			}
+			findings = append(findings, Finding{ID: id, Kind: FindingReused, Detail: "also in " + strings.Join(others, ", ")})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return idLess(findings[i].ID, findings[j].ID)
+	})
+	return findings, nil
+}
+
+// Entropy estimates the bits of a password from its length and the
+// character classes it uses. Repeated characters only count once per run.
+func Entropy(password string) float64 {
+	var lower, upper, digit, symbol, other bool
+	length := 0
+	var last rune
+	for i, r := range password {
+		switch {
+		case r < unicode.MaxASCII && unicode.IsLower(r):
+			lower = true
+		case r < unicode.MaxASCII && unicode.IsUpper(r):
+			upper = true
+		case r < unicode.MaxASCII && unicode.IsDigit(r):
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+		if i == 0 || r != last {
+			length++
+		}
+		last = r
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(length) * math.Log2(float64(pool))
+}
@@ -0,0 +1,110 @@
+package tpm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrConflict = errors.New("vault file was modified by someone else")
+
+// FileInfo describes the stored vault file. Revision is opaque: a content
+// hash, an ETag or a Dropbox rev depending on the backend.
+type FileInfo struct {
+	Size     int64
+	Revision string
+	Modified time.Time
+}
+
+// Backend stores the encrypted vault file
+type Backend interface {
+	// Read returns the file and its current revision, ErrNotExist if there
+	// is no such file
+	Read(name string) ([]byte, FileInfo, error)
+	// Write stores the file only if it is still at revision, or does not
+	// exist when revision is empty. ErrConflict is returned otherwise.
+	Write(name string, data []byte, revision string) (FileInfo, error)
+	Stat(name string) (FileInfo, error)
+}
+
+// Local keeps the vault file in a directory of the local filesystem
+type Local struct {
+	Dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+func (l *Local) Read(name string) ([]byte, FileInfo, error) {
+	path := filepath.Join(l.Dir, name)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, FileInfo{}, ErrNotExist
+	}
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	return data, FileInfo{Size: int64(len(data)), Revision: contentRevision(data), Modified: fi.ModTime()}, nil
+}
+
+func (l *Local) Write(name string, data []byte, revision string) (FileInfo, error) {
+	current, err := l.Stat(name)
+	switch {
+	case err == ErrNotExist:
+		if revision != "" {
+			return FileInfo{}, ErrConflict
+		}
+	case err != nil:
+		return FileInfo{}, err
+	case current.Revision != revision:
+		return FileInfo{}, ErrConflict
+	}
+
+	if err = writeFileAtomic(filepath.Join(l.Dir, name), data); err != nil {
+		return FileInfo{}, err
+	}
+	return l.Stat(name)
+}
+
+// writeFileAtomic writes to a temporary file and renames it over path, so
+// a crash leaves either the old or the new file but never half of one
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (l *Local) Stat(name string) (FileInfo, error) {
+	_, info, err := l.Read(name)
+	return info, err
+}
+
+func contentRevision(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:16])
+}
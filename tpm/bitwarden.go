@@ -0,0 +1,147 @@
+package tpm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/conejoninja/tesoro"
+)
+
+// Bitwarden unencrypted JSON export
+type bitwardenFile struct {
+	Encrypted bool              `json:"encrypted"`
+	Folders   []bitwardenFolder `json:"folders"`
+	Items     []bitwardenItem   `json:"items"`
+}
+
+type bitwardenFolder struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type bitwardenItem struct {
+	ID       string          `json:"id"`
+	FolderID *string         `json:"folderId"`
+	Type     int             `json:"type"`
+	Name     string          `json:"name"`
+	Notes    *string         `json:"notes"`
+	Favorite bool            `json:"favorite"`
+	Login    *bitwardenLogin `json:"login,omitempty"`
+}
+
+type bitwardenLogin struct {
+	Username *string        `json:"username"`
+	Password *string        `json:"password"`
+	URIs     []bitwardenURI `json:"uris"`
+}
+
+type bitwardenURI struct {
+	Match *int   `json:"match"`
+	URI   string `json:"uri"`
+}
+
+const (
+	bitwardenLoginType      = 1
+	bitwardenSecureNoteType = 2
+)
+
+var ErrBitwardenEncrypted = errors.New("bitwarden export is encrypted, export it as unencrypted JSON")
+
+// ParseBitwarden reads a Bitwarden unencrypted JSON export. Logins and
+// secure notes are imported, the folder of an item becomes its tag; cards
+// and identities are skipped.
+func ParseBitwarden(r io.Reader) ([]Account, error) {
+	var f bitwardenFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	if f.Encrypted {
+		return nil, ErrBitwardenEncrypted
+	}
+	folders := map[string]string{}
+	for _, folder := range f.Folders {
+		folders[folder.ID] = folder.Name
+	}
+
+	var accounts []Account
+	for _, item := range f.Items {
+		if item.Type != bitwardenLoginType && item.Type != bitwardenSecureNoteType {
+			continue
+		}
+		a := Account{Name: item.Name, Notes: deref(item.Notes)}
+		if item.FolderID != nil && folders[*item.FolderID] != "" {
+			a.Tags = []string{folders[*item.FolderID]}
+		}
+		if item.Login != nil {
+			a.Username = deref(item.Login.Username)
+			a.Password = deref(item.Login.Password)
+			if len(item.Login.URIs) > 0 {
+				a.URL = item.Login.URIs[0].URI
+			}
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+// WriteBitwarden writes the accounts as Bitwarden logins. Bitwarden has a
+// single folder per item, the first tag is used.
+func WriteBitwarden(w io.Writer, accounts []Account) error {
+	f := bitwardenFile{Folders: []bitwardenFolder{}, Items: []bitwardenItem{}}
+	folders := map[string]string{}
+	for _, a := range accounts {
+		item := bitwardenItem{
+			ID:    bitwardenUUID(),
+			Type:  bitwardenLoginType,
+			Name:  a.Name,
+			Notes: nullable(a.Notes),
+			Login: &bitwardenLogin{
+				Username: nullable(a.Username),
+				Password: nullable(a.Password),
+				URIs:     []bitwardenURI{},
+			},
+		}
+		if a.URL != "" {
+			item.Login.URIs = append(item.Login.URIs, bitwardenURI{URI: a.URL})
+		}
+		if len(a.Tags) > 0 {
+			id, ok := folders[a.Tags[0]]
+			if !ok {
+				id = bitwardenUUID()
+				folders[a.Tags[0]] = id
+				f.Folders = append(f.Folders, bitwardenFolder{ID: id, Name: a.Tags[0]})
+			}
+			item.FolderID = &id
+		}
+		f.Items = append(f.Items, item)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(f)
+}
+
+func bitwardenUUID() string {
+	b, _ := tesoro.GenerateRandomBytes(16)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// nullable returns nil for an empty string, the way Bitwarden exports it
+func nullable(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,145 @@
+package tpm
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Breaches looks passwords up in an offline copy of the Have I Been Pwned
+// SHA-1 list, so no password or hash ever leaves the computer. Path is
+// either the single file ordered by hash, with HASH:COUNT lines, or a
+// directory of range files named after the first five characters of the
+// hash, with SUFFIX:COUNT lines as the range API returns them.
+type Breaches struct {
+	Path string
+	dir  bool
+}
+
+func OpenBreaches(path string) (*Breaches, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Breaches{Path: path, dir: fi.IsDir()}, nil
+}
+
+// Count returns how many times the password was seen in breaches
+func (b *Breaches) Count(password string) (int, error) {
+	h := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(h[:]))
+	if b.dir {
+		return b.countRange(hash)
+	}
+	return b.countSorted(hash)
+}
+
+func (b *Breaches) countRange(hash string) (int, error) {
+	f, err := os.Open(filepath.Join(b.Path, hash[:5]))
+	if os.IsNotExist(err) {
+		f, err = os.Open(filepath.Join(b.Path, hash[:5]+".txt"))
+	}
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if count, ok := matchLine(scanner.Text(), hash[5:]); ok {
+			return count, nil
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// countSorted does a binary search on the file offsets, the full list is
+// tens of gigabytes
+func (b *Breaches) countSorted(hash string) (int, error) {
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// lines starting before lo are lower than hash, the ones starting at
+	// hi or later are not
+	lo, hi := int64(0), fi.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, next, err := lineAt(f, mid)
+		if err == io.EOF {
+			hi = mid
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if strings.ToUpper(line) < hash {
+			lo = next
+		} else {
+			hi = mid
+		}
+	}
+
+	line, _, err := lineAt(f, lo)
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	count, _ := matchLine(line, hash)
+	return count, nil
+}
+
+// lineAt returns the first line starting at offset or after it, without
+// its line break, and the offset of the line that follows
+func lineAt(f *os.File, offset int64) (string, int64, error) {
+	start := offset
+	if offset > 0 {
+		start = offset - 1
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	r := bufio.NewReader(f)
+	if offset > 0 {
+		skipped, err := r.ReadString('\n')
+		if err != nil {
+			return "", 0, io.EOF
+		}
+		start += int64(len(skipped))
+	}
+	line, err := r.ReadString('\n')
+	if line == "" && err != nil {
+		return "", 0, io.EOF
+	}
+	return strings.TrimRight(line, "\r\n"), start + int64(len(line)), nil
+}
+
+// matchLine parses a HASH:COUNT line, hash being the whole SHA-1 or the
+// suffix after the range prefix
+func matchLine(line, hash string) (int, bool) {
+	i := strings.IndexByte(line, ':')
+	if i < 0 || !strings.EqualFold(line[:i], hash) {
+		return 0, false
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[i+1:]))
+	if err != nil {
+		count = 1
+	}
+	return count, true
+}
@@ -0,0 +1,195 @@
+package tpm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format of a file exchanged with other password managers
+type Format string
+
+const (
+	FormatKeePass   Format = "keepass"
+	FormatBitwarden Format = "bitwarden"
+	FormatCSV       Format = "csv"
+)
+
+var ErrUnknownFormat = errors.New("unknown format, use keepass, bitwarden or csv")
+
+// Account is an entry as other password managers see it: a name, the URL
+// it is used on and tags by name. TPM keeps the URL as the entry title and
+// the name as its note.
+type Account struct {
+	Name     string
+	URL      string
+	Username string
+	Password string
+	Notes    string
+	Tags     []string
+}
+
+// Parse reads the accounts of an export made by another password manager
+func Parse(format Format, r io.Reader) ([]Account, error) {
+	switch format {
+	case FormatKeePass:
+		return ParseKeePass(r)
+	case FormatBitwarden:
+		return ParseBitwarden(r)
+	case FormatCSV:
+		return ParseCSV(r)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Write writes the accounts in a format other password managers import.
+// The output holds every password in clear.
+func Write(format Format, w io.Writer, accounts []Account) error {
+	switch format {
+	case FormatKeePass:
+		return WriteKeePass(w, accounts)
+	case FormatBitwarden:
+		return WriteBitwarden(w, accounts)
+	case FormatCSV:
+		return WriteCSV(w, accounts)
+	}
+	return ErrUnknownFormat
+}
+
+// Preview is the outcome of an import, computed without touching the vault
+// or the device.
+type Preview struct {
+	// New accounts that will be added
+	New []Account
+	// Duplicates already in the vault with the same title and username,
+	// they are not imported
+	Duplicates []Account
+	// NewTags that will be created
+	NewTags []string
+}
+
+func (p Preview) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d entries to import, %d already in the vault\n", len(p.New), len(p.Duplicates))
+	for _, a := range p.New {
+		fmt.Fprintf(&b, "  + %s (%s) user %q", a.Name, a.URL, a.Username)
+		if len(a.Tags) > 0 {
+			fmt.Fprintf(&b, " tags %s", strings.Join(a.Tags, ", "))
+		}
+		b.WriteString("\n")
+	}
+	for _, a := range p.Duplicates {
+		fmt.Fprintf(&b, "  = %s (%s) user %q\n", a.Name, a.URL, a.Username)
+	}
+	if len(p.NewTags) > 0 {
+		fmt.Fprintf(&b, "New tags: %s\n", strings.Join(p.NewTags, ", "))
+	}
+	return b.String()
+}
+
+// Preview is the dry run of Import
+func (v *Vault) Preview(accounts []Account) Preview {
+	var p Preview
+	seen := map[string]bool{}
+	for _, e := range v.Storage.Entries {
+		seen[e.Title+"\x00"+e.Username] = true
+	}
+	newTag := map[string]bool{}
+	for _, a := range accounts {
+		item := a.item()
+		key := item.Title + "\x00" + item.Username
+		if seen[key] {
+			p.Duplicates = append(p.Duplicates, a)
+			continue
+		}
+		seen[key] = true
+		p.New = append(p.New, a)
+		for _, tag := range a.Tags {
+			if _, ok := v.TagID(tag); !ok && !newTag[strings.ToLower(tag)] {
+				newTag[strings.ToLower(tag)] = true
+				p.NewTags = append(p.NewTags, tag)
+			}
+		}
+	}
+	return p
+}
+
+// Import creates the tags and adds the new accounts of the preview, each
+// with its own nonce encrypted by the device. It returns the ids of the
+// entries added before any error.
+func (v *Vault) Import(p Preview) ([]string, error) {
+	for _, tag := range p.NewTags {
+		v.AddTag(tag)
+	}
+	ids := make([]string, 0, len(p.New))
+	for _, a := range p.New {
+		item := a.item()
+		for _, tag := range a.Tags {
+			if id, ok := v.TagID(tag); ok {
+				item.Tags = append(item.Tags, id)
+			}
+		}
+		id, err := v.Add(item)
+		if err != nil {
+			return ids, fmt.Errorf("importing %s: %v", a.Name, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Export decrypts every entry, unlocking each one on the device
+func (v *Vault) Export() ([]Account, error) {
+	records := v.List()
+	accounts := make([]Account, 0, len(records))
+	for _, r := range records {
+		item, err := v.Get(r.ID)
+		if err != nil {
+			return nil, err
+		}
+		a := Account{
+			Name:     item.Note,
+			URL:      item.Title,
+			Username: item.Username,
+			Password: item.Password,
+			Notes:    item.SafeNote,
+		}
+		if a.Name == "" {
+			a.Name = item.Title
+		}
+		for _, id := range item.Tags {
+			if tag, ok := v.Storage.Tags[strconv.Itoa(id)]; ok && id != 0 {
+				a.Tags = append(a.Tags, tag.Title)
+			}
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+func (a Account) item() Item {
+	item := Item{
+		Title:    a.URL,
+		Username: a.Username,
+		Note:     a.Name,
+		Password: a.Password,
+		SafeNote: a.Notes,
+	}
+	if item.Title == "" {
+		item.Title = a.Name
+	}
+	return item
+}
+
+// splitTags splits a list of tags separated by commas or semicolons
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
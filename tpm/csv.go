@@ -0,0 +1,104 @@
+package tpm
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+)
+
+// csvColumns maps the header names used by common exports (Bitwarden,
+// LastPass, Chrome, Firefox, 1Password) to the Account fields
+var csvColumns = map[string]string{
+	"name":           "name",
+	"title":          "name",
+	"url":            "url",
+	"uri":            "url",
+	"login_uri":      "url",
+	"website":        "url",
+	"username":       "username",
+	"user":           "username",
+	"login":          "username",
+	"login_username": "username",
+	"password":       "password",
+	"login_password": "password",
+	"notes":          "notes",
+	"note":           "notes",
+	"extra":          "notes",
+	"tags":           "tags",
+	"folder":         "tags",
+	"grouping":       "tags",
+}
+
+var ErrCSVHeader = errors.New("csv needs a header row with at least a name or url column")
+
+// ParseCSV reads a CSV file whose first row names the columns. Tags may be
+// separated by commas or semicolons.
+func ParseCSV(r io.Reader) ([]Account, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, ErrCSVHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if field, ok := csvColumns[name]; ok {
+			if _, dup := columns[field]; !dup {
+				columns[field] = i
+			}
+		}
+	}
+	_, hasName := columns["name"]
+	_, hasURL := columns["url"]
+	if !hasName && !hasURL {
+		return nil, ErrCSVHeader
+	}
+
+	var accounts []Account
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		a := Account{
+			Name:     field("name"),
+			URL:      field("url"),
+			Username: field("username"),
+			Password: field("password"),
+			Notes:    field("notes"),
+			Tags:     splitTags(field("tags")),
+		}
+		if a.Name == "" && a.URL == "" {
+			continue
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+// WriteCSV writes the accounts with a name,url,username,password,notes,tags
+// header
+func WriteCSV(w io.Writer, accounts []Account) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"name", "url", "username", "password", "notes", "tags"})
+	for _, a := range accounts {
+		cw.Write([]string{a.Name, a.URL, a.Username, a.Password, a.Notes, strings.Join(a.Tags, ";")})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,183 @@
+package tpm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Dropbox keeps the vault file in a Dropbox app folder through the HTTP API
+// v2, where TPM stores it. Revisions are Dropbox revs and writes use the
+// "update" mode so a concurrent change is reported as a conflict.
+type Dropbox struct {
+	Token string
+	// Folder inside the app folder, empty for its root
+	Folder string
+	// APIURL and ContentURL default to the Dropbox endpoints and can point
+	// to any server speaking the same API.
+	APIURL     string
+	ContentURL string
+	Client     *http.Client
+}
+
+const (
+	dropboxAPIURL     = "https://api.dropboxapi.com"
+	dropboxContentURL = "https://content.dropboxapi.com"
+)
+
+func NewDropbox(token string) *Dropbox {
+	return &Dropbox{Token: token, APIURL: dropboxAPIURL, ContentURL: dropboxContentURL, Client: http.DefaultClient}
+}
+
+type dropboxMetadata struct {
+	Name           string `json:"name"`
+	Rev            string `json:"rev"`
+	Size           int64  `json:"size"`
+	ServerModified string `json:"server_modified"`
+}
+
+func (m dropboxMetadata) info() FileInfo {
+	modified, _ := time.Parse(time.RFC3339, m.ServerModified)
+	return FileInfo{Size: m.Size, Revision: m.Rev, Modified: modified}
+}
+
+type dropboxWriteMode struct {
+	Tag    string `json:".tag"`
+	Update string `json:"update,omitempty"`
+}
+
+func (d *Dropbox) path(name string) string {
+	folder := strings.Trim(d.Folder, "/")
+	if folder == "" {
+		return "/" + name
+	}
+	return "/" + folder + "/" + name
+}
+
+func (d *Dropbox) post(url string, arg interface{}, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.Token)
+	if arg != nil {
+		argJSON, err := json.Marshal(arg)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Dropbox-API-Arg", string(argJSON))
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (d *Dropbox) Read(name string) ([]byte, FileInfo, error) {
+	resp, err := d.post(d.contentURL()+"/2/files/download", map[string]string{"path": d.path(name)}, "", nil)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	defer resp.Body.Close()
+	if err = dropboxError(resp); err != nil {
+		return nil, FileInfo{}, err
+	}
+
+	var meta dropboxMetadata
+	if err = json.Unmarshal([]byte(resp.Header.Get("Dropbox-API-Result")), &meta); err != nil {
+		return nil, FileInfo{}, fmt.Errorf("dropbox: invalid download metadata: %v", err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	return data, meta.info(), err
+}
+
+func (d *Dropbox) Write(name string, data []byte, revision string) (FileInfo, error) {
+	mode := dropboxWriteMode{Tag: "add"}
+	if revision != "" {
+		mode = dropboxWriteMode{Tag: "update", Update: revision}
+	}
+	arg := struct {
+		Path       string           `json:"path"`
+		Mode       dropboxWriteMode `json:"mode"`
+		Autorename bool             `json:"autorename"`
+		Mute       bool             `json:"mute"`
+	}{d.path(name), mode, false, true}
+
+	resp, err := d.post(d.contentURL()+"/2/files/upload", arg, "application/octet-stream", data)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer resp.Body.Close()
+	if err = dropboxError(resp); err != nil {
+		return FileInfo{}, err
+	}
+
+	var meta dropboxMetadata
+	if err = json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return FileInfo{}, err
+	}
+	return meta.info(), nil
+}
+
+func (d *Dropbox) Stat(name string) (FileInfo, error) {
+	body, _ := json.Marshal(map[string]string{"path": d.path(name)})
+	resp, err := d.post(d.apiURL()+"/2/files/get_metadata", nil, "application/json", body)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer resp.Body.Close()
+	if err = dropboxError(resp); err != nil {
+		return FileInfo{}, err
+	}
+
+	var meta dropboxMetadata
+	if err = json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return FileInfo{}, err
+	}
+	return meta.info(), nil
+}
+
+func (d *Dropbox) apiURL() string {
+	if d.APIURL == "" {
+		return dropboxAPIURL
+	}
+	return strings.TrimRight(d.APIURL, "/")
+}
+
+func (d *Dropbox) contentURL() string {
+	if d.ContentURL == "" {
+		return dropboxContentURL
+	}
+	return strings.TrimRight(d.ContentURL, "/")
+}
+
+// dropboxError maps the endpoint specific errors, reported as 409 with an
+// error_summary like "path/not_found/..." or "path/conflict/file/..."
+func dropboxError(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusConflict {
+		var apiErr struct {
+			Summary string `json:"error_summary"`
+		}
+		json.Unmarshal(body, &apiErr)
+		switch {
+		case strings.Contains(apiErr.Summary, "not_found"):
+			return ErrNotExist
+		case strings.Contains(apiErr.Summary, "conflict"):
+			return ErrConflict
+		}
+		return fmt.Errorf("dropbox: %s", apiErr.Summary)
+	}
+	return fmt.Errorf("dropbox: server answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
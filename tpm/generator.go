@@ -0,0 +1,192 @@
+package tpm
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode"
+)
+
+const (
+	lowerChars  = "abcdefghijklmnopqrstuvwxyz"
+	upperChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	digitChars  = "0123456789"
+	symbolChars = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+)
+
+var (
+	ErrNoCharset  = errors.New("generator needs at least one character class")
+	ErrNoWordList = errors.New("generator needs a word list for passphrases")
+)
+
+// Generator makes passwords from random characters, or diceware passphrases
+// when Words is set. The randomness comes from the device, through Entropy,
+// mixed with the local random source so neither has to be trusted alone.
+type Generator struct {
+	Length  int
+	Lower   bool
+	Upper   bool
+	Digits  bool
+	Symbols bool
+
+	// Words in the passphrase, taken from WordList and joined by Separator
+	Words     int
+	WordList  []string
+	Separator string
+
+	// Entropy returns n random bytes from the device, nil to only use the
+	// local random source
+	Entropy func(n int) ([]byte, error)
+}
+
+// NewGenerator returns a generator of 20 characters passwords of letters
+// and digits
+func NewGenerator() *Generator {
+	return &Generator{Length: 20, Lower: true, Upper: true, Digits: true, Separator: " "}
+}
+
+// Generate returns a new password. The device is asked for entropy once.
+func (g *Generator) Generate() (string, error) {
+	rnd, err := g.random()
+	if err != nil {
+		return "", err
+	}
+
+	if g.Words > 0 {
+		if len(g.WordList) < 2 {
+			return "", ErrNoWordList
+		}
+		words := make([]string, g.Words)
+		for i := range words {
+			n, err := uniform(rnd, len(g.WordList))
+			if err != nil {
+				return "", err
+			}
+			words[i] = g.WordList[n]
+		}
+		return strings.Join(words, g.Separator), nil
+	}
+
+	var classes []string
+	for _, class := range []struct {
+		enabled bool
+		chars   string
+	}{{g.Lower, lowerChars}, {g.Upper, upperChars}, {g.Digits, digitChars}, {g.Symbols, symbolChars}} {
+		if class.enabled {
+			classes = append(classes, class.chars)
+		}
+	}
+	if len(classes) == 0 {
+		return "", ErrNoCharset
+	}
+	charset := strings.Join(classes, "")
+
+	// draw again until every class is used, if the length allows it
+	for {
+		password := make([]byte, g.Length)
+		for i := range password {
+			n, err := uniform(rnd, len(charset))
+			if err != nil {
+				return "", err
+			}
+			password[i] = charset[n]
+		}
+		if g.Length < len(classes) || usesAll(string(password), classes) {
+			return string(password), nil
+		}
+	}
+}
+
+// random returns a stream keyed with the device and local entropy
+func (g *Generator) random() (io.Reader, error) {
+	local := make([]byte, 32)
+	if _, err := rand.Read(local); err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write(local)
+	if g.Entropy != nil {
+		device, err := g.Entropy(32)
+		if err != nil {
+			return nil, err
+		}
+		if len(device) < 32 {
+			return nil, errors.New("not enough entropy from device")
+		}
+		h.Write(device)
+	}
+
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.StreamReader{S: cipher.NewCTR(block, make([]byte, aes.BlockSize)), R: zeroReader{}}, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// uniform returns a number in [0, n) without modulo bias
+func uniform(r io.Reader, n int) (int, error) {
+	max := uint32(n)
+	limit := ^uint32(0) - ^uint32(0)%max
+	var b [4]byte
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		if v := binary.BigEndian.Uint32(b[:]); v < limit {
+			return int(v % max), nil
+		}
+	}
+}
+
+func usesAll(password string, classes []string) bool {
+	for _, class := range classes {
+		if !strings.ContainsAny(password, class) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseWordList reads a diceware word list, either one word per line or
+// the "11111	word" lines of the EFF lists
+func ParseWordList(r io.Reader) ([]string, error) {
+	var words []string
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		word := fields[0]
+		if len(fields) > 1 && strings.IndexFunc(word, func(r rune) bool { return !unicode.IsDigit(r) }) < 0 {
+			word = fields[1]
+		}
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(words) < 2 {
+		return nil, ErrNoWordList
+	}
+	return words, nil
+}
@@ -0,0 +1,74 @@
+package tpm
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Version is a previous, still encrypted, copy of the vault file
+type Version struct {
+	Slot     int
+	Size     int64
+	Modified time.Time
+}
+
+func (v *Vault) versionName(slot int) string {
+	return fmt.Sprintf("%s.%d", v.filename, slot)
+}
+
+// keepVersion writes the file about to be replaced in the free or oldest
+// of the History slots
+func (v *Vault) keepVersion(content []byte) error {
+	slot, revision := 0, ""
+	var oldest time.Time
+	for i := 1; i <= v.History; i++ {
+		info, err := v.backend.Stat(v.versionName(i))
+		if err == ErrNotExist {
+			slot, revision = i, ""
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if slot == 0 || info.Modified.Before(oldest) {
+			slot, revision, oldest = i, info.Revision, info.Modified
+		}
+	}
+	_, err := v.backend.Write(v.versionName(slot), content, revision)
+	return err
+}
+
+// Versions returns the previous versions kept, newest first
+func (v *Vault) Versions() ([]Version, error) {
+	var versions []Version
+	for i := 1; i <= v.History; i++ {
+		info, err := v.backend.Stat(v.versionName(i))
+		if err == ErrNotExist {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, Version{Slot: i, Size: info.Size, Modified: info.Modified})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Modified.After(versions[j].Modified)
+	})
+	return versions, nil
+}
+
+// Restore replaces the storage with a previous version. Nothing is written
+// until the vault is saved, and the current file is then kept as a version.
+func (v *Vault) Restore(slot int) error {
+	content, _, err := v.backend.Read(v.versionName(slot))
+	if err != nil {
+		return err
+	}
+	s, err := v.decrypt(content)
+	if err != nil {
+		return err
+	}
+	v.Storage = s
+	return nil
+}
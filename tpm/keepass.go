@@ -0,0 +1,122 @@
+package tpm
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"strings"
+
+	"github.com/conejoninja/tesoro"
+)
+
+// KeePass 2 unencrypted XML export, as written by File > Export > KeePass
+// XML (2.x)
+type keePassFile struct {
+	XMLName xml.Name     `xml:"KeePassFile"`
+	Meta    keePassMeta  `xml:"Meta"`
+	Root    keePassGroup `xml:"Root>Group"`
+}
+
+type keePassMeta struct {
+	Generator      string `xml:"Generator"`
+	RecycleBinUUID string `xml:"RecycleBinUUID,omitempty"`
+}
+
+type keePassGroup struct {
+	UUID    string         `xml:"UUID"`
+	Name    string         `xml:"Name"`
+	Entries []keePassEntry `xml:"Entry"`
+	Groups  []keePassGroup `xml:"Group"`
+}
+
+type keePassEntry struct {
+	UUID    string          `xml:"UUID"`
+	Tags    string          `xml:"Tags,omitempty"`
+	Strings []keePassString `xml:"String"`
+}
+
+type keePassString struct {
+	Key   string       `xml:"Key"`
+	Value keePassValue `xml:"Value"`
+}
+
+type keePassValue struct {
+	Value           string `xml:",chardata"`
+	ProtectInMemory string `xml:"ProtectInMemory,attr,omitempty"`
+}
+
+// ParseKeePass reads a KeePass 2 XML export. Entry tags and the names of
+// the groups holding the entry become tags, the root group and the recycle
+// bin are left out.
+func ParseKeePass(r io.Reader) ([]Account, error) {
+	var f keePassFile
+	if err := xml.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	var accounts []Account
+	var walk func(g keePassGroup, groups []string)
+	walk = func(g keePassGroup, groups []string) {
+		if f.Meta.RecycleBinUUID != "" && g.UUID == f.Meta.RecycleBinUUID {
+			return
+		}
+		for _, e := range g.Entries {
+			var a Account
+			a.Tags = append(a.Tags, groups...)
+			a.Tags = append(a.Tags, splitTags(e.Tags)...)
+			for _, s := range e.Strings {
+				switch s.Key {
+				case "Title":
+					a.Name = s.Value.Value
+				case "URL":
+					a.URL = s.Value.Value
+				case "UserName":
+					a.Username = s.Value.Value
+				case "Password":
+					a.Password = s.Value.Value
+				case "Notes":
+					a.Notes = s.Value.Value
+				}
+			}
+			accounts = append(accounts, a)
+		}
+		for _, sub := range g.Groups {
+			walk(sub, append(groups[:len(groups):len(groups)], sub.Name))
+		}
+	}
+	walk(f.Root, nil)
+	return accounts, nil
+}
+
+// WriteKeePass writes the accounts as a KeePass 2 XML file with every entry
+// in the root group and its tags in the entry
+func WriteKeePass(w io.Writer, accounts []Account) error {
+	f := keePassFile{
+		Meta: keePassMeta{Generator: "tesoro"},
+		Root: keePassGroup{UUID: keePassUUID(), Name: "TREZOR Password Manager"},
+	}
+	for _, a := range accounts {
+		f.Root.Entries = append(f.Root.Entries, keePassEntry{
+			UUID: keePassUUID(),
+			Tags: strings.Join(a.Tags, ";"),
+			Strings: []keePassString{
+				{Key: "Title", Value: keePassValue{Value: a.Name}},
+				{Key: "UserName", Value: keePassValue{Value: a.Username}},
+				{Key: "Password", Value: keePassValue{Value: a.Password, ProtectInMemory: "True"}},
+				{Key: "URL", Value: keePassValue{Value: a.URL}},
+				{Key: "Notes", Value: keePassValue{Value: a.Notes}},
+			},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	return enc.Encode(f)
+}
+
+func keePassUUID() string {
+	uuid, _ := tesoro.GenerateRandomBytes(16)
+	return base64.StdEncoding.EncodeToString(uuid)
+}
@@ -0,0 +1,64 @@
+package tpm
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Match returns the entries whose item/URL is for that address, the most
+// specific first. An entry titled "github.com" matches any protocol and
+// path on that host, "https://github.com/org" only https and paths under
+// /org. When username is not empty the entry must be for that user.
+func (v *Vault) Match(protocol, host, path, username string) []Record {
+	type match struct {
+		Record
+		score int
+	}
+	var matches []match
+	for _, r := range v.List() {
+		if username != "" && r.Entry.Username != username {
+			continue
+		}
+		if score, ok := matchURL(r.Entry.Title, protocol, host, path); ok {
+			matches = append(matches, match{r, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	records := make([]Record, len(matches))
+	for i, m := range matches {
+		records[i] = m.Record
+	}
+	return records
+}
+
+func matchURL(title, protocol, host, path string) (int, bool) {
+	title = strings.TrimSpace(title)
+	if !strings.Contains(title, "://") {
+		title = "//" + title
+	}
+	u, err := url.Parse(title)
+	if err != nil || u.Host == "" || !strings.EqualFold(u.Host, host) {
+		return 0, false
+	}
+
+	score := 1
+	if u.Scheme != "" {
+		if !strings.EqualFold(u.Scheme, protocol) {
+			return 0, false
+		}
+		score++
+	}
+	entryPath := strings.Trim(u.Path, "/")
+	if entryPath != "" {
+		path = strings.Trim(path, "/")
+		if path != entryPath && !strings.HasPrefix(path, entryPath+"/") {
+			return 0, false
+		}
+		score += 1 + strings.Count(entryPath, "/")
+	}
+	return score, true
+}
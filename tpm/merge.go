@@ -0,0 +1,151 @@
+package tpm
+
+import (
+	"strconv"
+
+	"github.com/conejoninja/tesoro"
+)
+
+// Conflict is an entry changed in both copies of the vault. Both versions
+// are kept: theirs under ID and ours as a new entry under Copy. Copy is
+// empty when the entry was edited on one side and deleted on the other,
+// the edit is kept.
+type Conflict struct {
+	ID   string
+	Copy string
+}
+
+// Merge combines the changes made to base in ours and theirs. Changes
+// made on one side only are applied, and entries added on both sides are
+// all kept. Entries changed differently on both sides are returned as
+// conflicts, nothing is lost.
+func Merge(base, ours, theirs tesoro.Storage) (tesoro.Storage, []Conflict) {
+	merged := tesoro.Storage{
+		Version: theirs.Version,
+		Config:  theirs.Config,
+		Tags:    map[string]tesoro.Tag{},
+		Entries: map[string]tesoro.Entry{},
+	}
+	if ours.Config != base.Config {
+		merged.Config = ours.Config
+	}
+
+	// new ids go after every id used in any copy
+	lastTag := maxID(base.Tags, ours.Tags, theirs.Tags)
+	lastEntry := maxID(base.Entries, ours.Entries, theirs.Entries)
+
+	// tags first, as ours may have to be given a new id
+	tagIDs := map[int]int{}
+	for k, t := range theirs.Tags {
+		merged.Tags[k] = t
+	}
+	for k, t := range ours.Tags {
+		b, inBase := base.Tags[k]
+		th, inTheirs := theirs.Tags[k]
+		switch {
+		case inBase && t == b:
+			// unchanged by us, theirs wins, deletion included
+		case !inTheirs, th == t, inBase && th == b:
+			// added or renamed by us, renames win over deletions
+			merged.Tags[k] = t
+		default:
+			// both created or renamed the same id differently
+			old, _ := strconv.Atoi(k)
+			lastTag++
+			merged.Tags[strconv.Itoa(lastTag)] = t
+			tagIDs[old] = lastTag
+		}
+	}
+	for k := range base.Tags {
+		if _, inOurs := ours.Tags[k]; !inOurs {
+			if th, inTheirs := theirs.Tags[k]; inTheirs && th == base.Tags[k] {
+				delete(merged.Tags, k)
+			}
+		}
+	}
+
+	var conflicts []Conflict
+	for k, t := range theirs.Entries {
+		merged.Entries[k] = t
+	}
+	for k, o := range ours.Entries {
+		b, inBase := base.Entries[k]
+		t, inTheirs := theirs.Entries[k]
+		switch {
+		case inBase && o.Equal(b):
+			// unchanged by us
+		case !inTheirs && !inBase:
+			merged.Entries[k] = retag(o, tagIDs)
+		case !inTheirs:
+			// edited by us, deleted by them
+			merged.Entries[k] = retag(o, tagIDs)
+			conflicts = append(conflicts, Conflict{ID: k})
+		case o.Equal(t):
+		case inBase && t.Equal(b):
+			merged.Entries[k] = retag(o, tagIDs)
+		case !inBase:
+			// both added an entry under the same id
+			lastEntry++
+			merged.Entries[strconv.Itoa(lastEntry)] = retag(o, tagIDs)
+		default:
+			lastEntry++
+			id := strconv.Itoa(lastEntry)
+			merged.Entries[id] = retag(o, tagIDs)
+			conflicts = append(conflicts, Conflict{ID: k, Copy: id})
+		}
+	}
+	for k, b := range base.Entries {
+		if _, inOurs := ours.Entries[k]; inOurs {
+			continue
+		}
+		t, inTheirs := theirs.Entries[k]
+		if !inTheirs {
+			continue
+		}
+		if t.Equal(b) {
+			delete(merged.Entries, k)
+		} else {
+			// deleted by us, edited by them
+			conflicts = append(conflicts, Conflict{ID: k})
+		}
+	}
+	return merged, conflicts
+}
+
+func retag(e tesoro.Entry, ids map[int]int) tesoro.Entry {
+	if len(ids) == 0 {
+		return e
+	}
+	tags := make([]int, len(e.Tags))
+	for i, t := range e.Tags {
+		if id, ok := ids[t]; ok {
+			t = id
+		}
+		tags[i] = t
+	}
+	e.Tags = tags
+	return e
+}
+
+// maxID returns the highest numeric key of the maps
+func maxID(maps ...interface{}) int {
+	max := 0
+	check := func(k string) {
+		if i, err := strconv.Atoi(k); err == nil && i > max {
+			max = i
+		}
+	}
+	for _, m := range maps {
+		switch m := m.(type) {
+		case map[string]tesoro.Tag:
+			for k := range m {
+				check(k)
+			}
+		case map[string]tesoro.Entry:
+			for k := range m {
+				check(k)
+			}
+		}
+	}
+	return max
+}
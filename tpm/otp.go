@@ -0,0 +1,224 @@
+package tpm
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNoOTP = errors.New("entry has no otpauth:// URI or base32 secret in its safe note")
+
+// OTP is a one-time password generator, RFC 4226 HOTP or RFC 6238 TOTP,
+// as described by an otpauth:// URI
+type OTP struct {
+	HOTP      bool
+	Secret    []byte
+	Algorithm string
+	Digits    int
+	Period    int
+	Counter   uint64
+	Issuer    string
+	Account   string
+
+	// uri as found in the safe note, nil for a bare secret
+	uri  *url.URL
+	line string
+}
+
+// ParseOTP reads an otpauth://totp/ or otpauth://hotp/ URI
+func ParseOTP(uri string) (*OTP, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "otpauth" || (u.Host != "totp" && u.Host != "hotp") {
+		return nil, errors.New("not an otpauth://totp or otpauth://hotp URI")
+	}
+	q := u.Query()
+	o := &OTP{HOTP: u.Host == "hotp", Algorithm: "SHA1", Digits: 6, Period: 30, uri: u, line: uri}
+	if o.Secret, err = decodeSecret(q.Get("secret")); err != nil {
+		return nil, err
+	}
+	if alg := strings.ToUpper(q.Get("algorithm")); alg != "" {
+		o.Algorithm = alg
+	}
+	if o.hash() == nil {
+		return nil, fmt.Errorf("unsupported OTP algorithm %s", o.Algorithm)
+	}
+	if digits := q.Get("digits"); digits != "" {
+		if o.Digits, err = strconv.Atoi(digits); err != nil || o.Digits < 6 || o.Digits > 10 {
+			return nil, fmt.Errorf("invalid OTP digits %s", digits)
+		}
+	}
+	if period := q.Get("period"); period != "" {
+		if o.Period, err = strconv.Atoi(period); err != nil || o.Period <= 0 {
+			return nil, fmt.Errorf("invalid OTP period %s", period)
+		}
+	}
+	if counter := q.Get("counter"); counter != "" {
+		if o.Counter, err = strconv.ParseUint(counter, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid HOTP counter %s", counter)
+		}
+	}
+
+	label := strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(label, ":"); i >= 0 {
+		o.Issuer, o.Account = label[:i], strings.TrimSpace(label[i+1:])
+	} else {
+		o.Account = label
+	}
+	if issuer := q.Get("issuer"); issuer != "" {
+		o.Issuer = issuer
+	}
+	return o, nil
+}
+
+// FindOTP looks for an otpauth:// URI, or a line with only a base32 secret
+// taken as a default TOTP, in a safe note
+func FindOTP(safeNote string) (*OTP, error) {
+	lines := strings.Split(safeNote, "\n")
+	for _, line := range lines {
+		for _, field := range strings.Fields(line) {
+			if strings.HasPrefix(field, "otpauth://") {
+				return ParseOTP(field)
+			}
+		}
+	}
+	for _, line := range lines {
+		if secret, err := decodeSecret(line); err == nil && len(secret) >= 10 && isSecretLine(line) {
+			return &OTP{Secret: secret, Algorithm: "SHA1", Digits: 6, Period: 30, line: line}, nil
+		}
+	}
+	return nil, ErrNoOTP
+}
+
+// isSecretLine accepts a single word or groups of four characters, the way
+// secrets are usually shown next to the QR code
+func isSecretLine(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 1 {
+		return true
+	}
+	for _, f := range fields[:len(fields)-1] {
+		if len(f) != 4 {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Join(strings.Fields(secret), ""))
+	secret = strings.TrimRight(secret, "=")
+	if secret == "" {
+		return nil, errors.New("missing OTP secret")
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+}
+
+func (o *OTP) hash() func() hash.Hash {
+	switch o.Algorithm {
+	case "SHA1":
+		return sha1.New
+	case "SHA256":
+		return sha256.New
+	case "SHA512":
+		return sha512.New
+	}
+	return nil
+}
+
+// Generate returns the RFC 4226 code for a counter value
+func (o *OTP) Generate(counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(o.hash(), o.Secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := uint64(binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff)
+	mod := uint64(1)
+	for i := 0; i < o.Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", o.Digits, code%mod)
+}
+
+// TOTP returns the RFC 6238 code at t and how long it is valid
+func (o *OTP) TOTP(t time.Time) (string, time.Duration) {
+	period := int64(o.Period)
+	step := t.Unix() / period
+	next := time.Unix((step+1)*period, 0)
+	return o.Generate(uint64(step)), next.Sub(t)
+}
+
+// Next returns the HOTP code of the current counter and moves the counter
+// forward
+func (o *OTP) Next() string {
+	code := o.Generate(o.Counter)
+	o.Counter++
+	if o.uri != nil {
+		q := o.uri.Query()
+		q.Set("counter", strconv.FormatUint(o.Counter, 10))
+		o.uri.RawQuery = q.Encode()
+	}
+	return code
+}
+
+// update writes the counter back into the safe note the OTP came from
+func (o *OTP) update(safeNote string) string {
+	if o.uri == nil {
+		return safeNote
+	}
+	uri := o.uri.String()
+	note := strings.Replace(safeNote, o.line, uri, 1)
+	o.line = uri
+	return note
+}
+
+// OTPCode is the one-time code of an entry
+type OTPCode struct {
+	Code string
+	// Remaining validity of a TOTP code
+	Remaining time.Duration
+	// Counter used for a HOTP code
+	Counter uint64
+	HOTP    bool
+}
+
+// OTP unlocks the entry and returns its current one-time code. For HOTP
+// the counter kept in the safe note is moved forward, the entry is
+// encrypted again and the vault has to be saved.
+func (v *Vault) OTP(id string, now time.Time) (OTPCode, error) {
+	item, err := v.Get(id)
+	if err != nil {
+		return OTPCode{}, err
+	}
+	o, err := FindOTP(item.SafeNote)
+	if err != nil {
+		return OTPCode{}, err
+	}
+
+	if !o.HOTP {
+		code, remaining := o.TOTP(now)
+		return OTPCode{Code: code, Remaining: remaining}, nil
+	}
+	counter := o.Counter
+	code := o.Next()
+	item.SafeNote = o.update(item.SafeNote)
+	if err = v.Update(id, item); err != nil {
+		return OTPCode{}, err
+	}
+	return OTPCode{Code: code, Counter: counter, HOTP: true}, nil
+}
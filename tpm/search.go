@@ -0,0 +1,84 @@
+package tpm
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Orders of the entries, as stored in Storage.Config.OrderType
+const (
+	// OrderDate lists the entries in the order they were added
+	OrderDate = "date"
+	// OrderTitle lists them alphabetically by title
+	OrderTitle = "title"
+)
+
+// Title is the name of the entry shown by TPM. TPM keeps it in the note
+// field and the item or URL in the title field, the title is used when
+// the entry has no name.
+func (r Record) Title() string {
+	if r.Entry.Note != "" {
+		return r.Entry.Note
+	}
+	return r.Entry.Title
+}
+
+// URL is the item or URL the entry is for
+func (r Record) URL() string {
+	return r.Entry.Title
+}
+
+// Search returns the entries ordered by the OrderType of the vault whose
+// title, URL or username contain text, ignoring case, and that have the
+// tag. An empty text or tag matches every entry.
+func (v *Vault) Search(text, tag string) ([]Record, error) {
+	tagID := -1
+	if tag != "" {
+		id, ok := v.TagID(tag)
+		if !ok {
+			return nil, ErrTagNotFound
+		}
+		// every entry is in All
+		if id != 0 {
+			tagID = id
+		}
+	}
+	text = strings.ToLower(text)
+
+	var records []Record
+	for _, r := range v.List() {
+		if tagID >= 0 && !hasTag(r.Entry.Tags, tagID) {
+			continue
+		}
+		if text != "" &&
+			!strings.Contains(strings.ToLower(r.Entry.Note), text) &&
+			!strings.Contains(strings.ToLower(r.Entry.Title), text) &&
+			!strings.Contains(strings.ToLower(r.Entry.Username), text) {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// SetOrder changes how the entries are listed, OrderDate or OrderTitle
+func (v *Vault) SetOrder(order string) error {
+	if order != OrderDate && order != OrderTitle {
+		return errors.New("unknown order, use date or title")
+	}
+	v.Storage.Config.OrderType = order
+	return nil
+}
+
+func (v *Vault) sort(records []Record) {
+	if v.Storage.Config.OrderType == OrderTitle {
+		sort.SliceStable(records, func(i, j int) bool {
+			return strings.ToLower(records[i].Title()) < strings.ToLower(records[j].Title())
+		})
+		return
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return idLess(records[i].ID, records[j].ID)
+	})
+}
@@ -0,0 +1,125 @@
+package tpm
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/conejoninja/tesoro"
+)
+
+var (
+	ErrTagNotFound = errors.New("tag does not exist")
+	ErrTagExists   = errors.New("there is already a tag with that title")
+	// ErrTagAll is returned when renaming or deleting the "All" tag
+	ErrTagAll = errors.New("the All tag can not be changed")
+)
+
+// TagID returns the id of the tag with that title, compared case
+// insensitively
+func (v *Vault) TagID(title string) (int, bool) {
+	for k, tag := range v.Storage.Tags {
+		if strings.EqualFold(tag.Title, title) {
+			id, err := strconv.Atoi(k)
+			return id, err == nil
+		}
+	}
+	return 0, false
+}
+
+// AddTag creates a tag and returns its id, or the id of the existing tag
+// with the same title
+func (v *Vault) AddTag(title string) int {
+	if id, ok := v.TagID(title); ok {
+		return id
+	}
+	max := 0
+	for k := range v.Storage.Tags {
+		if i, err := strconv.Atoi(k); err == nil && i > max {
+			max = i
+		}
+	}
+	if v.Storage.Tags == nil {
+		v.Storage.Tags = map[string]tesoro.Tag{}
+	}
+	v.Storage.Tags[strconv.Itoa(max+1)] = tesoro.Tag{Title: title, Icon: "tag"}
+	return max + 1
+}
+
+// RenameTag changes the title of a tag, the entries keep it
+func (v *Vault) RenameTag(title, newTitle string) error {
+	id, ok := v.TagID(title)
+	if !ok {
+		return ErrTagNotFound
+	}
+	if id == 0 {
+		return ErrTagAll
+	}
+	if other, ok := v.TagID(newTitle); ok && other != id {
+		return ErrTagExists
+	}
+	key := strconv.Itoa(id)
+	tag := v.Storage.Tags[key]
+	tag.Title = newTitle
+	v.Storage.Tags[key] = tag
+	return nil
+}
+
+// DeleteTag removes a tag and takes it out of every entry
+func (v *Vault) DeleteTag(title string) error {
+	id, ok := v.TagID(title)
+	if !ok {
+		return ErrTagNotFound
+	}
+	if id == 0 {
+		return ErrTagAll
+	}
+	delete(v.Storage.Tags, strconv.Itoa(id))
+	for k, e := range v.Storage.Entries {
+		if !hasTag(e.Tags, id) {
+			continue
+		}
+		tags := make([]int, 0, len(e.Tags)-1)
+		for _, t := range e.Tags {
+			if t != id {
+				tags = append(tags, t)
+			}
+		}
+		e.Tags = tags
+		v.Storage.Entries[k] = e
+	}
+	return nil
+}
+
+// Tags returns the tags ordered by id with the number of entries in each
+func (v *Vault) Tags() ([]tesoro.Tag, []int) {
+	ids := make([]int, 0, len(v.Storage.Tags))
+	for k := range v.Storage.Tags {
+		if id, err := strconv.Atoi(k); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	tags := make([]tesoro.Tag, len(ids))
+	counts := make([]int, len(ids))
+	for i, id := range ids {
+		tags[i] = v.Storage.Tags[strconv.Itoa(id)]
+		for _, e := range v.Storage.Entries {
+			if id == 0 || hasTag(e.Tags, id) {
+				counts[i]++
+			}
+		}
+	}
+	return tags, counts
+}
+
+func hasTag(tags []int, id int) bool {
+	for _, t := range tags {
+		if t == id {
+			return true
+		}
+	}
+	return false
+}
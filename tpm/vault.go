@@ -0,0 +1,331 @@
+// Package tpm manages TREZOR Password Manager vaults: the encrypted
+// storage file and its entries, whose passwords and safe notes are only
+// decrypted with the nonce the device unlocks.
+package tpm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/conejoninja/tesoro"
+	"github.com/conejoninja/tesoro/pb/messages"
+)
+
+var (
+	ErrNotExist = errors.New("vault file does not exist")
+	ErrNotFound = errors.New("entry does not exist")
+)
+
+// Item is an entry with its secrets in clear
+type Item struct {
+	Title    string
+	Username string
+	Note     string
+	Tags     []int
+	Password string
+	SafeNote string
+}
+
+// Record is an entry of the vault together with its id
+type Record struct {
+	ID    string
+	Entry tesoro.Entry
+}
+
+// Vault is an unlocked password manager storage
+type Vault struct {
+	client   *tesoro.Client
+	backend  Backend
+	filename string
+	encKey   string
+	revision string
+	// content is the encrypted file at revision, base what it holds
+	content []byte
+	base    tesoro.Storage
+
+	Storage tesoro.Storage
+	// History is the number of previous versions kept next to the file
+	History int
+}
+
+// DefaultHistory is the number of previous versions kept by default
+const DefaultHistory = 5
+
+// NewStorage returns the storage TPM creates for a new vault
+func NewStorage() tesoro.Storage {
+	return tesoro.Storage{
+		Version: "0.0.1",
+		Config:  tesoro.Config{OrderType: "date"},
+		Tags: map[string]tesoro.Tag{
+			"0": {Title: "All", Icon: "home"},
+			"1": {Title: "Social", Icon: "person-stalker"},
+			"2": {Title: "Bitcoin", Icon: "social-bitcoin"},
+		},
+		Entries: map[string]tesoro.Entry{},
+	}
+}
+
+// Open asks the device for the master key and reads the vault from the
+// backend. A vault that does not exist yet is opened empty and created on
+// the first Save.
+func Open(client *tesoro.Client, backend Backend) (*Vault, error) {
+	str, msgType, err := client.Exchange(client.GetMasterKey())
+	if err != nil {
+		return nil, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_CipheredKeyValue {
+		return nil, fmt.Errorf("unexpected response from device: %s", str)
+	}
+
+	v := &Vault{client: client, backend: backend, History: DefaultHistory}
+	masterKey := hex.EncodeToString([]byte(str))
+	v.filename, _, v.encKey = tesoro.GetFileEncKey(masterKey)
+
+	content, info, err := backend.Read(v.filename)
+	if err == ErrNotExist {
+		v.Storage = NewStorage()
+		v.base = tesoro.Storage{}
+		return v, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Storage, err = v.decrypt(content); err != nil {
+		return nil, err
+	}
+	v.base, _ = v.decrypt(content)
+	v.revision = info.Revision
+	v.content = content
+	return v, nil
+}
+
+func (v *Vault) decrypt(content []byte) (tesoro.Storage, error) {
+	s, err := tesoro.DecryptStorage(string(content), v.encKey)
+	if err != nil {
+		return s, err
+	}
+	if s.Entries == nil {
+		s.Entries = map[string]tesoro.Entry{}
+	}
+	if s.Tags == nil {
+		s.Tags = map[string]tesoro.Tag{}
+	}
+	return s, nil
+}
+
+// Filename of the vault in the backend
+func (v *Vault) Filename() string {
+	return v.filename
+}
+
+// List returns the entries ordered by the OrderType of the vault
+func (v *Vault) List() []Record {
+	records := make([]Record, 0, len(v.Storage.Entries))
+	for id, e := range v.Storage.Entries {
+		records = append(records, Record{ID: id, Entry: e})
+	}
+	v.sort(records)
+	return records
+}
+
+func idLess(a, b string) bool {
+	ia, errA := strconv.Atoi(a)
+	ib, errB := strconv.Atoi(b)
+	if errA == nil && errB == nil {
+		return ia < ib
+	}
+	return a < b
+}
+
+// Get unlocks the entry on the device and returns it decrypted
+func (v *Vault) Get(id string) (Item, error) {
+	e, ok := v.Storage.Entries[id]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+
+	nonce, err := v.entryNonce(e)
+	if err != nil {
+		return Item{}, err
+	}
+	item := Item{Title: e.Title, Username: e.Username, Note: e.Note, Tags: e.Tags}
+	if item.Password, err = decryptValue(e.Password, nonce); err != nil {
+		return Item{}, fmt.Errorf("entry %s password: %v", id, err)
+	}
+	if item.SafeNote, err = decryptValue(e.SafeNote, nonce); err != nil {
+		return Item{}, fmt.Errorf("entry %s safe note: %v", id, err)
+	}
+	return item, nil
+}
+
+// Add encrypts the item under a fresh nonce and returns its new id
+func (v *Vault) Add(item Item) (string, error) {
+	e, err := v.encrypt(item)
+	if err != nil {
+		return "", err
+	}
+
+	max := 0
+	for k := range v.Storage.Entries {
+		i, err := strconv.Atoi(k)
+		if err == nil && i > max {
+			max = i
+		}
+	}
+	id := strconv.Itoa(max + 1)
+	v.Storage.Entries[id] = e
+	return id, nil
+}
+
+// Update replaces the entry. The nonce is regenerated because the key that
+// protects it depends on the title and username.
+func (v *Vault) Update(id string, item Item) error {
+	if _, ok := v.Storage.Entries[id]; !ok {
+		return ErrNotFound
+	}
+	e, err := v.encrypt(item)
+	if err != nil {
+		return err
+	}
+	v.Storage.Entries[id] = e
+	return nil
+}
+
+func (v *Vault) Delete(id string) error {
+	if _, ok := v.Storage.Entries[id]; !ok {
+		return ErrNotFound
+	}
+	delete(v.Storage.Entries, id)
+	return nil
+}
+
+// Save encrypts the storage and writes it to the backend, keeping the file
+// it replaces as a previous version. ErrConflict is returned, and nothing
+// written, if the file changed since it was read.
+func (v *Vault) Save() error {
+	data, err := tesoro.EncryptStorage(v.Storage, v.encKey)
+	if err != nil {
+		return err
+	}
+	// never replace a good file with one that can not be read back
+	if _, err = v.decrypt(data); err != nil {
+		return fmt.Errorf("encrypted vault does not decrypt: %v", err)
+	}
+
+	info, err := v.backend.Write(v.filename, data, v.revision)
+	if err != nil {
+		return err
+	}
+	previous := v.content
+	v.revision = info.Revision
+	v.content = data
+	v.base, _ = v.decrypt(data)
+
+	if previous != nil && v.History > 0 {
+		if err = v.keepVersion(previous); err != nil {
+			return fmt.Errorf("vault saved, but the previous version was not kept: %v", err)
+		}
+	}
+	return nil
+}
+
+// Sync saves the vault, merging it first with the file in the backend if
+// someone else changed it since it was read. Entries changed on both
+// sides are kept twice and returned as conflicts.
+func (v *Vault) Sync() ([]Conflict, error) {
+	var conflicts []Conflict
+	for retry := 0; retry < 3; retry++ {
+		err := v.Save()
+		if err != ErrConflict {
+			return conflicts, err
+		}
+
+		content, info, err := v.backend.Read(v.filename)
+		if err != nil {
+			return conflicts, err
+		}
+		theirs, err := v.decrypt(content)
+		if err != nil {
+			return conflicts, err
+		}
+		var merged []Conflict
+		v.Storage, merged = Merge(v.base, v.Storage, theirs)
+		conflicts = append(conflicts, merged...)
+		v.base = theirs
+		v.revision = info.Revision
+		v.content = content
+	}
+	return conflicts, ErrConflict
+}
+
+// Changed reports whether the file in the backend is no longer the one the
+// vault was read from.
+func (v *Vault) Changed() (bool, error) {
+	info, err := v.backend.Stat(v.filename)
+	if err == ErrNotExist {
+		return v.revision != "", nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.Revision != v.revision, nil
+}
+
+func (v *Vault) entryNonce(e tesoro.Entry) (string, error) {
+	str, msgType, err := v.client.Exchange(v.client.GetEntryNonce(e.Title, e.Username, e.Nonce))
+	if err != nil {
+		return "", err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_CipheredKeyValue {
+		return "", fmt.Errorf("unexpected response from device: %s", str)
+	}
+	return str, nil
+}
+
+func (v *Vault) encrypt(item Item) (tesoro.Entry, error) {
+	e := tesoro.Entry{Title: item.Title, Username: item.Username, Note: item.Note, Tags: item.Tags}
+	if e.Tags == nil {
+		e.Tags = []int{}
+	}
+
+	nonceByte, err := tesoro.GenerateRandomBytes(32)
+	if err != nil {
+		return e, err
+	}
+	nonce := string(nonceByte)
+	str, msgType, err := v.client.Exchange(v.client.SetEntryNonce(e.Title, e.Username, nonce))
+	if err != nil {
+		return e, err
+	}
+	if messages.MessageType(msgType) != messages.MessageType_MessageType_CipheredKeyValue {
+		return e, fmt.Errorf("unexpected response from device: %s", str)
+	}
+	e.Nonce = hex.EncodeToString([]byte(str))
+
+	password, _ := json.Marshal(item.Password)
+	safeNote, _ := json.Marshal(item.SafeNote)
+	e.Password = tesoro.EncryptedData{Type: "Buffer", Data: tesoro.EncryptEntry(string(password), nonce)}
+	e.SafeNote = tesoro.EncryptedData{Type: "Buffer", Data: tesoro.EncryptEntry(string(safeNote), nonce)}
+	return e, nil
+}
+
+// decryptValue decrypts a password or safe note, stored by TPM as a JSON
+// string.
+func decryptValue(data tesoro.EncryptedData, nonce string) (string, error) {
+	if len(data.Data) == 0 {
+		return "", nil
+	}
+	plain, err := tesoro.DecryptEntry(string(data.Data), nonce)
+	if err != nil {
+		return "", err
+	}
+	var value string
+	if err = json.Unmarshal([]byte(plain), &value); err != nil {
+		return plain, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,127 @@
+package tpm
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebDAV keeps the vault file in a WebDAV collection. Revisions are the
+// ETags of the server, and writes are made conditional with If-Match.
+type WebDAV struct {
+	// URL of the collection, e.g. https://cloud.example.com/remote.php/dav/files/me/TPM/
+	URL      string
+	User     string
+	Password string
+	Client   *http.Client
+}
+
+func NewWebDAV(collection, user, password string) *WebDAV {
+	return &WebDAV{URL: collection, User: user, Password: password, Client: http.DefaultClient}
+}
+
+func (w *WebDAV) fileURL(name string) string {
+	return strings.TrimRight(w.URL, "/") + "/" + url.PathEscape(name)
+}
+
+func (w *WebDAV) do(method, name string, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, w.fileURL(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if w.User != "" {
+		req.SetBasicAuth(w.User, w.Password)
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (w *WebDAV) Read(name string) ([]byte, FileInfo, error) {
+	resp, err := w.do("GET", name, nil, nil)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	defer resp.Body.Close()
+	if err = httpError(resp); err != nil {
+		return nil, FileInfo{}, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	info := responseInfo(resp)
+	info.Size = int64(len(data))
+	return data, info, nil
+}
+
+func (w *WebDAV) Write(name string, data []byte, revision string) (FileInfo, error) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	if revision == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", revision)
+	}
+	resp, err := w.do("PUT", name, data, header)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+	if err = httpError(resp); err != nil {
+		return FileInfo{}, err
+	}
+
+	// not every server returns the new ETag on PUT
+	if resp.Header.Get("ETag") == "" {
+		return w.Stat(name)
+	}
+	info := responseInfo(resp)
+	info.Size = int64(len(data))
+	return info, nil
+}
+
+func (w *WebDAV) Stat(name string) (FileInfo, error) {
+	resp, err := w.do("HEAD", name, nil, nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+	if err = httpError(resp); err != nil {
+		return FileInfo{}, err
+	}
+	info := responseInfo(resp)
+	info.Size = resp.ContentLength
+	return info, nil
+}
+
+func responseInfo(resp *http.Response) FileInfo {
+	info := FileInfo{Revision: resp.Header.Get("ETag")}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.Modified = modified
+	} else {
+		info.Modified = time.Now()
+	}
+	return info
+}
+
+func httpError(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotExist
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return ErrConflict
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	return nil
+}
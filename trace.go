@@ -0,0 +1,110 @@
+package tesoro
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+
+	"github.com/conejoninja/tesoro/pb/messages"
+	"github.com/conejoninja/tesoro/transport"
+)
+
+// redacted lists the JSON fields that never reach the trace
+var redacted = map[messages.MessageType][]string{
+	messages.MessageType_MessageType_PinMatrixAck:     {"pin"},
+	messages.MessageType_MessageType_PassphraseAck:    {"passphrase"},
+	messages.MessageType_MessageType_LoadDevice:       {"mnemonic", "node", "pin"},
+	messages.MessageType_MessageType_WordAck:          {"word"},
+	messages.MessageType_MessageType_CipherKeyValue:   {"value"},
+	messages.MessageType_MessageType_CipheredKeyValue: {"value"},
+	messages.MessageType_MessageType_ECDHSessionKey:   {"session_key"},
+	messages.MessageType_MessageType_Entropy:          {"entropy"},
+	messages.MessageType_MessageType_EntropyAck:       {"entropy"},
+	messages.MessageType_MessageType_EncryptMessage:   {"message"},
+	messages.MessageType_MessageType_DecryptedMessage: {"message"},
+}
+
+// SetTracer logs every message written to or read from the device at the
+// debug level: direction, type, length and the message as JSON, without
+// the PIN, passphrase, words, mnemonic, ciphered values, ECDH session keys,
+// entropy and plaintexts of EncryptMessage and DecryptMessage. A message
+// that cannot be redacted is traced without its fields. A nil logger stops
+// the trace.
+func (c *Client) SetTracer(logger *slog.Logger) {
+	c.tracer = logger
+}
+
+func (c *Client) write(msg []byte) error {
+	if c.tracer != nil && len(msg) >= 8 {
+		c.trace("out", binary.BigEndian.Uint16(msg[2:4]), msg[8:])
+	}
+	return c.writeTransport(msg)
+}
+
+// writeTransport writes to the transport, with its error when it reports
+// one
+func (c *Client) writeTransport(msg []byte) error {
+	if w, ok := c.t.(transport.ErrWriter); ok {
+		return w.WriteErr(msg)
+	}
+	c.t.Write(msg)
+	return nil
+}
+
+// traceLength traces the type and length of a message with its "##", type
+// and length header, without its payload
+func (c *Client) traceLength(direction string, msg []byte) {
+	if len(msg) >= 8 {
+		c.tracer.LogAttrs(context.Background(), slog.LevelDebug, "message", c.traceAttrs(direction, binary.BigEndian.Uint16(msg[2:4]), len(msg)-8)...)
+	}
+}
+
+func (c *Client) read() ([]byte, uint16, int, error) {
+	marshalled, msgType, length, err := c.t.Read()
+	if c.tracer != nil && err == nil && msgType != 999 {
+		c.trace("in", msgType, marshalled)
+	}
+	return marshalled, msgType, length, err
+}
+
+func (c *Client) traceAttrs(direction string, msgType uint16, length int) []slog.Attr {
+	name := "MessageType_" + strconv.Itoa(int(msgType))
+	if info, ok := Message(messages.MessageType(msgType)); ok {
+		name = info.Name
+	}
+	return []slog.Attr{
+		slog.String("direction", direction),
+		slog.String("type", name),
+		slog.Int("length", length),
+	}
+}
+
+func (c *Client) trace(direction string, msgType uint16, marshalled []byte) {
+	attrs := c.traceAttrs(direction, msgType, len(marshalled))
+	msg, err := Decode(msgType, marshalled)
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	} else if msgJSON, err := redact(messages.MessageType(msgType), msg); err == nil {
+		attrs = append(attrs, slog.Any("message", msgJSON))
+	}
+	c.tracer.LogAttrs(context.Background(), slog.LevelDebug, "message", attrs...)
+}
+
+func redact(msgType messages.MessageType, msg interface{}) (json.RawMessage, error) {
+	msgJSON, err := json.Marshal(msg)
+	if err != nil || len(redacted[msgType]) == 0 {
+		return msgJSON, err
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(msgJSON, &fields); err != nil {
+		return nil, err
+	}
+	for _, field := range redacted[msgType] {
+		if _, ok := fields[field]; ok {
+			fields[field] = json.RawMessage(`"[redacted]"`)
+		}
+	}
+	return json.Marshal(fields)
+}
@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/conejoninja/hid"
+)
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+// BusHID finds TREZOR One devices through the USB HID walk. Devices are
+// named by their position in the walk, which follows the bus and device
+// numbers: "hid:0" is the first one.
+type BusHID struct{}
+
+func (b *BusHID) Enumerate() ([]Device, error) {
+	var devices []Device
+	b.walk(func(n int, device hid.Device) bool {
+		info := device.Info()
+		devices = append(devices, Device{Path: fmt.Sprintf("hid:%d", n), VendorID: int(info.Vendor), ProductID: int(info.Product)})
+		return false
+	})
+	return devices, nil
+}
+
+func (b *BusHID) Connect(device Device) (Transport, error) {
+	var t *TransportHID
+	b.walk(func(n int, d hid.Device) bool {
+		if fmt.Sprintf("hid:%d", n) != device.Path {
+			return false
+		}
+		t = &TransportHID{}
+		t.SetDevice(d)
+		return true
+	})
+	if t == nil {
+		return nil, ErrDeviceNotFound
+	}
+	return t, nil
+}
+
+// walk calls fn with the n-th TREZOR One found until it returns true
+func (b *BusHID) walk(fn func(n int, device hid.Device) bool) {
+	n, done := 0, false
+	hid.UsbWalk(func(device hid.Device) {
+		info := device.Info()
+		if done || info.Vendor != VendorOne || info.Product != ProductOne || info.Interface != 0 {
+			return
+		}
+		done = fn(n, device)
+		n++
+	})
+}
@@ -2,6 +2,7 @@ package transport
 
 import (
 	"encoding/binary"
+	"io"
 	"log"
 	"math"
 	"time"
@@ -25,6 +26,10 @@ func (t *TransportHIDAndroid) Close() {
 }
 
 func (t *TransportHIDAndroid) Write(msg []byte) {
+	t.WriteErr(msg)
+}
+
+func (t *TransportHIDAndroid) WriteErr(msg []byte) error {
 	for len(msg) > 0 && t.device != nil {
 		blank := make([]byte, 64)
 		l := int(math.Min(63, float64(len(msg))))
@@ -32,16 +37,18 @@ func (t *TransportHIDAndroid) Write(msg []byte) {
 		copy(blank, tmp)
 		n, err := t.device.Write(blank, 1*time.Second)
 
-		if err == nil && n > 0 {
-			if len(msg) < 64 {
-				break
-			} else {
-				msg = msg[63:]
-			}
-		} else {
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		if len(msg) < 64 {
 			break
 		}
+		msg = msg[63:]
 	}
+	return nil
 }
 
 func (t *TransportHIDAndroid) Read() ([]byte, uint16, int, error) {
@@ -7,18 +7,30 @@ const (
 	ProductT   = 0x53C1
 )
 
+// ReportPayload is how much of a message goes in each 64 bytes HID report,
+// after the '?' that starts it
+const ReportPayload = 63
+
 type Device struct {
 	Path      string
 	VendorID  int
 	ProductID int
 }
 
+// Transport is a connection to a device
 type Transport interface {
 	Write([]byte)
 	Read() ([]byte, uint16, int, error)
 	Close()
 }
 
+// ErrWriter is a Transport that reports failed writes. WriteErr returns the
+// error of the first report that could not be written, the client uses it
+// instead of Write when the transport has it.
+type ErrWriter interface {
+	WriteErr([]byte) error
+}
+
 type Bus interface {
 	Enumerate() ([]Device, error)
 	Connect(device Device) (Transport, error)
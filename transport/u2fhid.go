@@ -0,0 +1,187 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/conejoninja/hid"
+)
+
+// InterfaceU2F is the USB interface of the U2F (FIDO CTAP1) HID endpoint
+const InterfaceU2F = 1
+
+// U2F HID commands
+const (
+	U2FHIDPing  = 0x81
+	U2FHIDMsg   = 0x83
+	U2FHIDInit  = 0x86
+	U2FHIDWink  = 0x88
+	U2FHIDError = 0xBF
+)
+
+const u2fBroadcastCID = 0xFFFFFFFF
+
+// u2fTimeout is how long a response may take by default, the device only
+// answers a register or authenticate once it has signed
+const u2fTimeout = 5 * time.Second
+
+var ErrU2FTimeout = errors.New("u2f: no response from the device")
+
+// TransportU2F speaks the U2F HID framing on the device's second interface.
+// Unlike TransportHID it does not carry wire protocol messages, only U2F
+// APDUs.
+type TransportU2F struct {
+	// Timeout is how long to wait for a response, 5 seconds when zero
+	Timeout time.Duration
+
+	device hid.Device
+	cid    uint32
+}
+
+func (t *TransportU2F) SetDevice(device hid.Device) {
+	t.device = device
+	if err := t.device.Open(); err != nil {
+		log.Println("Open error: ", err)
+	}
+}
+
+func (t *TransportU2F) Close() {
+	t.device.Close()
+}
+
+// Msg sends an APDU and returns the raw response, status word included.
+func (t *TransportU2F) Msg(apdu []byte) ([]byte, error) {
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	return t.exchange(t.cid, U2FHIDMsg, apdu)
+}
+
+// Wink makes the device blink or show its U2F screen.
+func (t *TransportU2F) Wink() error {
+	if err := t.init(); err != nil {
+		return err
+	}
+	_, err := t.exchange(t.cid, U2FHIDWink, nil)
+	return err
+}
+
+// init allocates a channel with the U2FHID_INIT broadcast. The answers to
+// the INIT of other programs, with another nonce, are skipped.
+func (t *TransportU2F) init() error {
+	if t.cid != 0 {
+		return nil
+	}
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	if err := t.write(u2fBroadcastCID, U2FHIDInit, nonce); err != nil {
+		return err
+	}
+	deadline := t.deadline()
+	for {
+		resp, err := t.readUntil(u2fBroadcastCID, U2FHIDInit, deadline)
+		if err != nil {
+			return err
+		}
+		if len(resp) < 12 {
+			return errors.New("u2f: invalid init response")
+		}
+		if string(resp[:8]) == string(nonce) {
+			t.cid = binary.BigEndian.Uint32(resp[8:12])
+			return nil
+		}
+	}
+}
+
+func (t *TransportU2F) deadline() time.Time {
+	if t.Timeout > 0 {
+		return time.Now().Add(t.Timeout)
+	}
+	return time.Now().Add(u2fTimeout)
+}
+
+func (t *TransportU2F) exchange(cid uint32, cmd byte, data []byte) ([]byte, error) {
+	if err := t.write(cid, cmd, data); err != nil {
+		return nil, err
+	}
+	return t.readUntil(cid, cmd, t.deadline())
+}
+
+func (t *TransportU2F) write(cid uint32, cmd byte, data []byte) error {
+	packet := make([]byte, 64)
+	binary.BigEndian.PutUint32(packet, cid)
+	packet[4] = cmd
+	binary.BigEndian.PutUint16(packet[5:], uint16(len(data)))
+	n := copy(packet[7:], data)
+	if _, err := t.device.Write(packet, 1*time.Second); err != nil {
+		return err
+	}
+
+	for seq := byte(0); n < len(data); seq++ {
+		packet = make([]byte, 64)
+		binary.BigEndian.PutUint32(packet, cid)
+		packet[4] = seq
+		n += copy(packet[5:], data[n:])
+		if _, err := t.device.Write(packet, 1*time.Second); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readUntil reads the response of the channel to cmd, waiting for it up
+// to deadline
+func (t *TransportU2F) readUntil(cid uint32, cmd byte, deadline time.Time) ([]byte, error) {
+	buf, err := t.readPacket(cid, deadline)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 7 {
+		return nil, errors.New("u2f: short packet")
+	}
+	if buf[4] == U2FHIDError {
+		return nil, fmt.Errorf("u2f: device error %d", buf[7])
+	}
+	if buf[4] != cmd {
+		return nil, fmt.Errorf("u2f: unexpected command 0x%x", buf[4])
+	}
+
+	length := int(binary.BigEndian.Uint16(buf[5:7]))
+	data := append([]byte{}, buf[7:]...)
+	for len(data) < length {
+		if buf, err = t.readPacket(cid, deadline); err != nil {
+			return nil, err
+		}
+		if buf[4]&0x80 != 0 {
+			return nil, errors.New("u2f: response interrupted")
+		}
+		data = append(data, buf[5:]...)
+	}
+	return data[:length], nil
+}
+
+// readPacket returns the next packet of the channel. The reads that time
+// out are repeated until deadline, and the packets of other channels are
+// skipped, as U2FHID asks.
+func (t *TransportU2F) readPacket(cid uint32, deadline time.Time) ([]byte, error) {
+	for time.Now().Before(deadline) {
+		buf, err := t.device.Read(-1, 100*time.Millisecond)
+		if err != nil {
+			if timeout, ok := err.(interface{ Timeout() bool }); ok && timeout.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+		if len(buf) < 5 || binary.BigEndian.Uint32(buf) != cid {
+			continue
+		}
+		return buf, nil
+	}
+	return nil, ErrU2FTimeout
+}
@@ -0,0 +1,303 @@
+// Package u2f implements the FIDO U2F (CTAP1) register and authenticate
+// commands over the device's U2F HID interface.
+package u2f
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/conejoninja/hid"
+	"github.com/conejoninja/tesoro/transport"
+)
+
+// APDU instructions
+const (
+	insRegister     = 0x01
+	insAuthenticate = 0x02
+	insVersion      = 0x03
+)
+
+// Authenticate control bytes
+const (
+	authEnforce   = 0x03
+	authCheckOnly = 0x07
+)
+
+// Status words
+const (
+	swNoError                = 0x9000
+	swConditionsNotSatisfied = 0x6985
+	swWrongData              = 0x6A80
+)
+
+var (
+	ErrNoDevice    = errors.New("u2f: no TREZOR U2F interface found")
+	ErrWrongData   = errors.New("u2f: key handle not recognised by this device")
+	ErrTimeout     = errors.New("u2f: user presence was not confirmed in time")
+	ErrAttestation = errors.New("u2f: attestation signature does not verify")
+
+	errConditionsNotSatisfied = errors.New("u2f: conditions not satisfied")
+)
+
+// Device is a U2F token, the TREZOR U2F interface
+type Device struct {
+	t *transport.TransportU2F
+	// Timeout is how long to wait for the user to confirm on the device
+	Timeout time.Duration
+}
+
+// Open looks for the first connected TREZOR and opens its U2F interface.
+func Open() (*Device, error) {
+	var d *Device
+	hid.UsbWalk(func(device hid.Device) {
+		info := device.Info()
+		if d == nil && info.Vendor == transport.VendorOne && info.Product == transport.ProductOne && info.Interface == transport.InterfaceU2F {
+			var t transport.TransportU2F
+			t.SetDevice(device)
+			d = New(&t)
+		}
+	})
+	if d == nil {
+		return nil, ErrNoDevice
+	}
+	return d, nil
+}
+
+func New(t *transport.TransportU2F) *Device {
+	return &Device{t: t, Timeout: 30 * time.Second}
+}
+
+func (d *Device) Close() {
+	d.t.Close()
+}
+
+// Registration is the answer to a register request
+type Registration struct {
+	AppParam        []byte
+	ChallengeParam  []byte
+	PublicKey       []byte
+	KeyHandle       []byte
+	AttestationCert []byte
+	Signature       []byte
+}
+
+// Authentication is the answer to an authenticate request
+type Authentication struct {
+	UserPresence bool
+	Counter      uint32
+	Signature    []byte
+}
+
+// AppParam is the application parameter for an AppID or RP ID
+func AppParam(appID string) []byte {
+	h := sha256.Sum256([]byte(appID))
+	return h[:]
+}
+
+// Version returns the U2F protocol version supported, "U2F_V2".
+func (d *Device) Version() (string, error) {
+	resp, err := d.apdu(insVersion, 0, nil)
+	return string(resp), err
+}
+
+// Register creates a new key pair for appParam. The device asks the user to
+// confirm and the request is repeated until they do or Timeout passes.
+func (d *Device) Register(appParam, challengeParam []byte) (*Registration, error) {
+	if len(appParam) != 32 || len(challengeParam) != 32 {
+		return nil, errors.New("u2f: parameters must be 32 bytes")
+	}
+	resp, err := d.waitPresence(insRegister, 0, append(append([]byte{}, challengeParam...), appParam...))
+	if err != nil {
+		return nil, err
+	}
+
+	// 0x05 | public key (65) | L | key handle (L) | certificate | signature
+	if len(resp) < 67 || resp[0] != 0x05 {
+		return nil, errors.New("u2f: malformed register response")
+	}
+	reg := &Registration{AppParam: appParam, ChallengeParam: challengeParam, PublicKey: resp[1:66]}
+	l := int(resp[66])
+	if len(resp) < 67+l {
+		return nil, errors.New("u2f: malformed register response")
+	}
+	reg.KeyHandle = resp[67 : 67+l]
+	rest := resp[67+l:]
+
+	var cert asn1.RawValue
+	sig, err := asn1.Unmarshal(rest, &cert)
+	if err != nil {
+		return nil, fmt.Errorf("u2f: malformed attestation certificate: %v", err)
+	}
+	reg.AttestationCert = cert.FullBytes
+	reg.Signature = sig
+	return reg, nil
+}
+
+// Verify checks the attestation signature against the attestation
+// certificate sent by the device.
+func (r *Registration) Verify() (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(r.AttestationCert)
+	if err != nil {
+		return nil, err
+	}
+	signed := []byte{0x00}
+	signed = append(signed, r.AppParam...)
+	signed = append(signed, r.ChallengeParam...)
+	signed = append(signed, r.KeyHandle...)
+	signed = append(signed, r.PublicKey...)
+	if err = cert.CheckSignature(x509.ECDSAWithSHA256, signed, r.Signature); err != nil {
+		return cert, ErrAttestation
+	}
+	return cert, nil
+}
+
+// Authenticate signs challengeParam with the key behind keyHandle.
+func (d *Device) Authenticate(appParam, challengeParam, keyHandle []byte) (*Authentication, error) {
+	data := append(append([]byte{}, challengeParam...), appParam...)
+	data = append(data, byte(len(keyHandle)))
+	data = append(data, keyHandle...)
+
+	resp, err := d.waitPresence(insAuthenticate, authEnforce, data)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 5 {
+		return nil, errors.New("u2f: malformed authenticate response")
+	}
+	return &Authentication{
+		UserPresence: resp[0]&0x01 == 0x01,
+		Counter:      binary.BigEndian.Uint32(resp[1:5]),
+		Signature:    resp[5:],
+	}, nil
+}
+
+// Verify checks an authentication signature with the public key obtained
+// at registration.
+func (a *Authentication) Verify(publicKey, appParam, challengeParam []byte) error {
+	x, y := elliptic.Unmarshal(elliptic.P256(), publicKey)
+	if x == nil {
+		return errors.New("u2f: invalid public key")
+	}
+	signed := append([]byte{}, appParam...)
+	if a.UserPresence {
+		signed = append(signed, 0x01)
+	} else {
+		signed = append(signed, 0x00)
+	}
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, a.Counter)
+	signed = append(signed, counter...)
+	signed = append(signed, challengeParam...)
+	h := sha256.Sum256(signed)
+	if !ecdsa.VerifyASN1(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, h[:], a.Signature) {
+		return errors.New("u2f: authentication signature does not verify")
+	}
+	return nil
+}
+
+// CheckKeyHandle reports whether keyHandle was issued by this device for
+// appParam, without asking the user.
+func (d *Device) CheckKeyHandle(appParam, keyHandle []byte) (bool, error) {
+	data := append(make([]byte, 32), appParam...)
+	data = append(data, byte(len(keyHandle)))
+	data = append(data, keyHandle...)
+	_, err := d.apdu(insAuthenticate, authCheckOnly, data)
+	switch err {
+	case errConditionsNotSatisfied:
+		return true, nil
+	case ErrWrongData:
+		return false, nil
+	}
+	return false, err
+}
+
+func (d *Device) waitPresence(ins, p1 byte, data []byte) ([]byte, error) {
+	deadline := time.Now().Add(d.Timeout)
+	for {
+		resp, err := d.apdu(ins, p1, data)
+		if err != errConditionsNotSatisfied {
+			return resp, err
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrTimeout
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// apdu sends an extended length APDU and checks the status word.
+func (d *Device) apdu(ins, p1 byte, data []byte) ([]byte, error) {
+	req := []byte{0x00, ins, p1, 0x00, 0x00, byte(len(data) >> 8), byte(len(data))}
+	req = append(req, data...)
+	req = append(req, 0x00, 0x00)
+
+	resp, err := d.t.Msg(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 2 {
+		return nil, errors.New("u2f: short response")
+	}
+	sw := binary.BigEndian.Uint16(resp[len(resp)-2:])
+	switch sw {
+	case swNoError:
+		return resp[:len(resp)-2], nil
+	case swConditionsNotSatisfied:
+		return nil, errConditionsNotSatisfied
+	case swWrongData:
+		return nil, ErrWrongData
+	}
+	return nil, fmt.Errorf("u2f: status 0x%04x", sw)
+}
+
+// CounterBackup is the file written by the shell's u2fbackup command
+type CounterBackup struct {
+	Counter *uint32   `json:"u2f_counter"`
+	Date    time.Time `json:"date"`
+}
+
+var errNoCounter = errors.New("u2f: backup does not contain a counter")
+
+// ParseCounterBackup reads a counter backup, either the JSON written by
+// u2fbackup or a plain number.
+func ParseCounterBackup(data []byte) (uint32, error) {
+	var backup CounterBackup
+	if err := json.Unmarshal(data, &backup); err == nil {
+		if backup.Counter == nil {
+			return 0, errNoCounter
+		}
+		return *backup.Counter, nil
+	}
+	counter, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return 0, errNoCounter
+	}
+	return uint32(counter), nil
+}
+
+// ReadCounter reads the device's global U2F counter by registering and
+// authenticating against a throwaway application. The user has to confirm
+// both operations on the device.
+func (d *Device) ReadCounter() (uint32, error) {
+	app := AppParam("tesoro:u2f-counter")
+	challenge := make([]byte, 32)
+	reg, err := d.Register(app, challenge)
+	if err != nil {
+		return 0, err
+	}
+	auth, err := d.Authenticate(app, challenge, reg.KeyHandle)
+	if err != nil {
+		return 0, err
+	}
+	return auth.Counter, nil
+}